@@ -0,0 +1,136 @@
+package pk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+)
+
+// AffectedBy returns the tasks in the plan that cover any of the given files,
+// with Paths narrowed to the directories the files belong to. Files are
+// slash-separated paths relative to the git root, as printed by
+// `git diff --name-only`.
+//
+// Each file is attributed to the task path that most closely contains it, so
+// a change in a nested Go module does not also select the task in the parent
+// module. Files outside all of a task's paths do not affect it.
+func (p *Plan) AffectedBy(files []string) []TaskInfo {
+	if p == nil {
+		return nil
+	}
+	affected := p.affectedPaths(files)
+	var result []TaskInfo
+	for _, info := range p.Tasks() {
+		if paths, ok := affected[info.Name]; ok {
+			info.Paths = paths
+			result = append(result, info)
+		}
+	}
+	return result
+}
+
+// affectedPaths maps effective task names to the paths affected by files.
+// Tasks without affected paths are omitted.
+func (p *Plan) affectedPaths(files []string) map[string][]string {
+	affected := make(map[string][]string)
+	for _, instance := range p.taskInstances {
+		paths := instance.resolvedPaths
+		if len(paths) == 0 {
+			paths = []string{"."}
+		}
+		hit := make(map[string]bool)
+		for _, file := range files {
+			if dir, ok := nearestPath(file, paths); ok {
+				hit[dir] = true
+			}
+		}
+		for _, dir := range paths {
+			if hit[dir] {
+				affected[instance.name] = append(affected[instance.name], dir)
+			}
+		}
+	}
+	return affected
+}
+
+// nearestPath returns the deepest of paths that contains file.
+func nearestPath(file string, paths []string) (string, bool) {
+	best, found := "", false
+	for _, dir := range paths {
+		if !containsPath(dir, file) {
+			continue
+		}
+		if !found || depth(dir) > depth(best) {
+			best, found = dir, true
+		}
+	}
+	return best, found
+}
+
+// containsPath reports whether the slash-separated path p is dir or below it.
+func containsPath(dir, p string) bool {
+	return dir == "." || p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// depth returns the number of path segments in a slash-separated directory.
+func depth(dir string) int {
+	if dir == "." {
+		return 0
+	}
+	return strings.Count(dir, "/") + 1
+}
+
+// changedFiles returns the files changed on HEAD since it diverged from ref,
+// relative to the git root.
+func changedFiles(ctx context.Context, gitRoot, ref string) ([]string, error) {
+	if ref == "" || strings.HasPrefix(ref, "-") {
+		return nil, fmt.Errorf("invalid git ref %q", ref)
+	}
+	rangeSpec := ref + "...HEAD"
+	cmd := exec.CommandContext(ctx, "git", "diff", "--name-only", rangeSpec)
+	cmd.Dir = gitRoot
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("git diff %s: %w\n%s", rangeSpec, err, stderr.String())
+	}
+	var files []string
+	for line := range strings.SplitSeq(stdout.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
+
+// withAffected returns a context that restricts task execution to the given
+// task paths (see [Plan.affectedPaths]).
+func withAffected(ctx context.Context, affected map[string][]string) context.Context {
+	return context.WithValue(ctx, ctxkey.Affected{}, affected)
+}
+
+// affectedFromContext returns the affected task paths, or nil when execution
+// is not restricted.
+func affectedFromContext(ctx context.Context) map[string][]string {
+	m, _ := ctx.Value(ctxkey.Affected{}).(map[string][]string)
+	return m
+}
+
+// skipUnaffected reports whether the task instance at path is excluded by
+// the --since filter in ctx.
+func skipUnaffected(ctx context.Context, effectiveName, path string) bool {
+	affected := affectedFromContext(ctx)
+	if affected == nil {
+		return false
+	}
+	if path == "" {
+		path = "."
+	}
+	return !slices.Contains(affected[effectiveName], path)
+}
@@ -0,0 +1,147 @@
+package pk
+
+import (
+	"context"
+	"os/exec"
+	"slices"
+	"testing"
+
+	pkrun "github.com/fredrikaverpil/pocket/pk/run"
+)
+
+func TestPlan_AffectedBy(t *testing.T) {
+	noop := func(_ context.Context) error { return nil }
+	goTest := &Task{Name: "go-test", Usage: "test", Do: noop}
+	mdFormat := &Task{Name: "md-format", Usage: "format", Do: noop}
+	allDirs := []string{".", "services", "services/api", "services/web", "docs"}
+	cfg := &Config{Auto: Serial(
+		WithOptions(goTest, WithPath("^\\.$", "services/api", "services/web")),
+		mdFormat,
+	)}
+	plan, err := newPlan(cfg, "/tmp", allDirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		files []string
+		want  map[string][]string
+	}{
+		{
+			name:  "NestedModule",
+			files: []string{"services/api/main.go"},
+			want:  map[string][]string{"go-test": {"services/api"}, "md-format": {"."}},
+		},
+		{
+			name:  "RootFile",
+			files: []string{"go.mod"},
+			want:  map[string][]string{"go-test": {"."}, "md-format": {"."}},
+		},
+		{
+			name:  "SeveralModules",
+			files: []string{"services/web/app.go", "services/api/main.go"},
+			want:  map[string][]string{"go-test": {"services/api", "services/web"}, "md-format": {"."}},
+		},
+		{
+			name:  "NoChanges",
+			files: nil,
+			want:  map[string][]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(map[string][]string)
+			for _, info := range plan.AffectedBy(tt.files) {
+				got[info.Name] = info.Paths
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("AffectedBy(%v) = %v, want %v", tt.files, got, tt.want)
+			}
+			for name, paths := range tt.want {
+				if !slices.Equal(got[name], paths) {
+					t.Errorf("%s paths = %v, want %v", name, got[name], paths)
+				}
+			}
+		})
+	}
+}
+
+func TestPlan_AffectedBy_PrefixIsNotParent(t *testing.T) {
+	task := &Task{Name: "lint", Usage: "lint", Do: func(_ context.Context) error { return nil }}
+	plan, err := newPlan(&Config{Auto: WithOptions(task, WithPath("^api$"))}, "/tmp", []string{".", "api", "api2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := plan.AffectedBy([]string{"api2/main.go"}); len(got) != 0 {
+		t.Errorf("expected api2 not to affect api, got %v", got)
+	}
+}
+
+func TestTask_Run_SkipsUnaffectedPaths(t *testing.T) {
+	var ran []string
+	var subRuns int
+	sub := &Task{Name: "sub", Usage: "sub", Do: func(_ context.Context) error {
+		subRuns++
+		return nil
+	}}
+	task := &Task{Name: "lint", Usage: "lint", Body: Serial(sub, Do(func(ctx context.Context) error {
+		ran = append(ran, pkrun.PathFromContext(ctx))
+		return nil
+	}))}
+	plan, err := newPlan(&Config{Auto: WithOptions(task, WithPath("a", "b"))}, "/tmp", []string{".", "a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := integrationCtx(t, plan)
+	ctx = withAffected(ctx, plan.affectedPaths([]string{"b/main.go"}))
+	if err := plan.tree.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ran, []string{"b"}) {
+		t.Errorf("expected lint to run only in b, ran in %v", ran)
+	}
+	if subRuns != 1 {
+		t.Errorf("expected composed subtask of an affected task to run, ran %d times", subRuns)
+	}
+}
+
+func TestChangedFiles(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(cmd.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	writeFile(t, dir+"/README.md", "readme")
+	git("add", "-A")
+	git("commit", "-q", "-m", "initial")
+	git("tag", "base")
+	writeFile(t, dir+"/services/api/main.go", "package main")
+	git("add", "-A")
+	git("commit", "-q", "-m", "change")
+
+	files, err := changedFiles(context.Background(), dir, "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(files, []string{"services/api/main.go"}) {
+		t.Errorf("changedFiles = %v, want [services/api/main.go]", files)
+	}
+
+	if _, err := changedFiles(context.Background(), dir, "--output=x"); err == nil {
+		t.Error("expected refs starting with '-' to be rejected")
+	}
+}
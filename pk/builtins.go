@@ -31,6 +31,11 @@ var builtins = []*Task{
 	shimsTask,
 	planTask,
 	execTask,
+	watchTask,
+	completionTask,
+	logsTask,
+	historyTask,
+	rerunTask,
 	gitDiffTask,
 	commitsCheckTask,
 	selfUpdateTask,
@@ -53,6 +58,9 @@ var shimsTask = &Task{
 	Usage:      "regenerate shims in all directories",
 	HideHeader: true,
 	Do: func(ctx context.Context) error {
+		if pkrun.DryRun(ctx) {
+			return nil
+		}
 		gitRoot, err := repopath.GitRoot()
 		if err != nil {
 			return fmt.Errorf("finding git root: %w", err)
@@ -309,6 +317,10 @@ var selfUpdateTask = &Task{
 			return fmt.Errorf("tidying pocket module: %w", err)
 		}
 
+		if pkrun.DryRun(ctx) {
+			pkrun.Printf(ctx, "  [dry-run] regenerate %s\n", filepath.Join(pocketDir, "main.go"))
+			return nil
+		}
 		if pkrun.Verbose(ctx) {
 			pkrun.Printf(ctx, "  regenerating main.go\n")
 		}
@@ -338,6 +350,10 @@ var purgeTask = &Task{
 		}
 
 		for _, dir := range dirsToRemove {
+			if pkrun.DryRun(ctx) {
+				pkrun.Printf(ctx, "  [dry-run] remove %s\n", dir)
+				continue
+			}
 			if err := os.RemoveAll(dir); err != nil {
 				return fmt.Errorf("removing %s: %w", dir, err)
 			}
@@ -424,7 +440,7 @@ func printPlanText(ctx context.Context, p *Plan) {
 	printTree(ctx, p.tree, "", true, "", nil, p)
 
 	pkrun.Println(ctx)
-	pkrun.Printf(ctx, "Legend: [→] = Serial, [⚡] = Parallel\n")
+	pkrun.Printf(ctx, "Legend: [→] = Serial, [⚡] = Parallel, [🧹] = Finally\n")
 }
 
 // printTree recursively prints the composition tree structure.
@@ -462,17 +478,23 @@ func printTree(
 			markers = append(markers, "manual")
 		}
 
-		marker := ""
-		if len(markers) > 0 {
-			marker = " [" + strings.Join(markers, ", ") + "]"
-		}
-
 		paths := pathsForTreeNode(activePaths, effectiveName, p)
 		pathLabel := "[skipped]"
 		if paths != nil {
 			pathLabel = formatPaths(paths)
 		}
 
+		// Paths whose inputs are unchanged since the last successful run.
+		cached := cachedPaths(ctx, p, v, effectiveName, paths)
+		if len(cached) > 0 && len(cached) == len(paths) {
+			markers = append(markers, "cached")
+		}
+
+		marker := ""
+		if len(markers) > 0 {
+			marker = " [" + strings.Join(markers, ", ") + "]"
+		}
+
 		pkrun.Printf(ctx, "%s%s%s%s\n", prefix, branch, effectiveName, marker)
 
 		continuation := "│   "
@@ -480,6 +502,36 @@ func printTree(
 			continuation = "    "
 		}
 		pkrun.Printf(ctx, "%s%s    paths: %s\n", prefix, continuation, pathLabel)
+		if len(cached) > 0 && len(cached) < len(paths) {
+			pkrun.Printf(ctx, "%s%s    cached: %s\n", prefix, continuation, formatPaths(cached))
+		}
+		if deps := depNames(v, nameSuffix); len(deps) > 0 {
+			pkrun.Printf(ctx, "%s%s    deps: %s\n", prefix, continuation, strings.Join(deps, ", "))
+		}
+		if instance := p.taskInstanceByName(effectiveName); instance != nil {
+			if instance.retry != nil {
+				pkrun.Printf(ctx, "%s%s    retry: %s\n", prefix, continuation, instance.retry)
+			}
+			if instance.timeout > 0 {
+				pkrun.Printf(ctx, "%s%s    timeout: %s\n", prefix, continuation, instance.timeout)
+			}
+			if len(instance.locks) > 0 {
+				pkrun.Printf(ctx, "%s%s    locks: %s\n", prefix, continuation, strings.Join(instance.locks, ", "))
+			}
+			if instance.parallelPaths && len(paths) > 1 {
+				pkrun.Printf(ctx, "%s%s    parallel paths: yes\n", prefix, continuation)
+			}
+			if instance.outputMode == OutputStream {
+				pkrun.Printf(ctx, "%s%s    output: stream\n", prefix, continuation)
+			}
+			if len(instance.env) > 0 {
+				env := make([]string, 0, len(instance.env))
+				for _, source := range instance.env {
+					env = append(env, source.String())
+				}
+				pkrun.Printf(ctx, "%s%s    env: %s\n", prefix, continuation, strings.Join(env, ", "))
+			}
+		}
 
 	case *jsonTaskRef:
 		markers := []string{"task ref"}
@@ -525,6 +577,17 @@ func printTree(
 			printTree(ctx, child, childPrefix, i == len(v.runnables)-1, nameSuffix, activePaths, p)
 		}
 
+	case *finally:
+		pkrun.Printf(ctx, "%s%s[🧹] Finally (last runs always)\n", prefix, branch)
+		childPrefix := prefix
+		if isLast {
+			childPrefix += "    "
+		} else {
+			childPrefix += "│   "
+		}
+		printTree(ctx, v.body, childPrefix, false, nameSuffix, activePaths, p)
+		printTree(ctx, v.cleanup, childPrefix, true, nameSuffix, activePaths, p)
+
 	case *pathFilter:
 		childSuffix := nameSuffix
 		if v.nameSuffix != "" {
@@ -563,6 +626,23 @@ func printTree(
 	}
 }
 
+// depNames returns the effective names of the tasks a task depends on.
+func depNames(t *Task, nameSuffix string) []string {
+	var names []string
+	for _, dep := range t.Deps {
+		for _, d := range composedTasks(dep) {
+			name := d.Name
+			if nameSuffix != "" {
+				name = d.Name + ":" + nameSuffix
+			}
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
 func pathsForTreeNode(activePaths []string, taskName string, p *Plan) []string {
 	paths := []string{"."}
 	if activePaths != nil {
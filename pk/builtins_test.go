@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"strings"
 	"testing"
 
 	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
@@ -229,3 +230,49 @@ func TestPrintTree(t *testing.T) {
 		t.Errorf("expected tree formatting characters, got:\n%s", output)
 	}
 }
+
+func TestPrintTree_Finally(t *testing.T) {
+	noop := func(_ context.Context) error { return nil }
+	up := &Task{Name: "db-up", Usage: "db-up", Do: noop}
+	test := &Task{Name: "test", Usage: "test", Do: noop}
+	down := &Task{Name: "db-down", Usage: "db-down", Do: noop}
+	plan, err := newPlan(&Config{Auto: Finally(Serial(up, test), down)}, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	ctx := context.WithValue(context.Background(), ctxkey.Output{}, &pkrun.Output{Stdout: &buf, Stderr: &buf})
+	printTree(ctx, plan.tree, "", true, "", nil, plan)
+
+	output := buf.String()
+	for _, want := range []string{
+		"└── [🧹] Finally (last runs always)",
+		"    ├── [→] Serial",
+		"    └── db-down",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected tree output to contain %q, got:\n%s", want, output)
+		}
+	}
+}
+
+func TestPrintTree_Deps(t *testing.T) {
+	noop := func(_ context.Context) error { return nil }
+	generate := &Task{Name: "generate", Usage: "generate", Do: noop}
+	lint := &Task{Name: "lint", Usage: "lint", Deps: []Runnable{generate}, Do: noop}
+	plan, err := newPlan(&Config{Auto: Serial(lint)}, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	out := &pkrun.Output{Stdout: &buf, Stderr: &buf}
+	ctx := context.WithValue(context.Background(), ctxkey.Output{}, out)
+
+	printTree(ctx, plan.tree, "", true, "", nil, plan)
+
+	if output := buf.String(); !strings.Contains(output, "deps: generate") {
+		t.Errorf("expected tree output to list dependencies, got:\n%s", output)
+	}
+}
@@ -0,0 +1,379 @@
+package pk
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fredrikaverpil/pocket/pk/repopath"
+	pkrun "github.com/fredrikaverpil/pocket/pk/run"
+)
+
+const (
+	// cacheDirName is the directory under .pocket where task hashes are stored.
+	cacheDirName = "cache"
+	// cacheMaxAge is how long an entry is kept after it was last stored or
+	// hit. Entries of renamed tasks, removed paths, and old flag values are
+	// pruned whenever a task stores its hash.
+	cacheMaxAge = 30 * 24 * time.Hour
+)
+
+// taskCache decides whether a task with declared Inputs can be skipped at a path.
+// A task is cached when the hash of its inputs and outputs matches the hash
+// recorded after its last successful run with the same resolved flags and
+// environment.
+type taskCache struct {
+	gitRoot       string
+	path          string // Execution path relative to git root.
+	key           string // Identifies (task, path, resolved flags, environment).
+	inputs        []string
+	outputs       []string
+	skipDirs      []string
+	includeHidden bool
+}
+
+// newTaskCache returns the cache entry for a task at path, or nil if the task
+// declares no Inputs. env is the environment the task's commands run with,
+// including variables read from env files.
+func newTaskCache(
+	p *Plan,
+	t *Task,
+	effectiveName, execPath string,
+	flags map[string]any,
+	env pkrun.EnvConfig,
+) (*taskCache, error) {
+	if len(t.Inputs) == 0 {
+		return nil, nil
+	}
+	gitRoot, err := repopath.GitRoot()
+	if err != nil {
+		return nil, fmt.Errorf("finding git root: %w", err)
+	}
+	if execPath == "" {
+		execPath = "."
+	}
+	key, err := cacheKey(effectiveName, execPath, flags, env)
+	if err != nil {
+		return nil, err
+	}
+
+	skipDirs, includeHidden := DefaultSkipDirs, false
+	if p != nil && p.skipDirs != nil {
+		skipDirs, includeHidden = p.skipDirs, p.includeHidden
+	}
+
+	return &taskCache{
+		gitRoot:       gitRoot,
+		path:          execPath,
+		key:           key,
+		inputs:        t.Inputs,
+		outputs:       t.Outputs,
+		skipDirs:      skipDirs,
+		includeHidden: includeHidden,
+	}, nil
+}
+
+// cachedPaths returns the subset of paths at which the task would currently be
+// skipped as cached, using its declared flags merged with plan overrides and
+// the environment of its instance. Errors are treated as cache misses since
+// this is only used for display.
+func cachedPaths(ctx context.Context, p *Plan, t *Task, effectiveName string, paths []string) []string {
+	if len(t.Inputs) == 0 || noCacheFromContext(ctx) {
+		return nil
+	}
+	instance := p.taskInstanceByName(effectiveName)
+	var flags map[string]any
+	if t.Flags != nil {
+		var err error
+		if flags, err = structToMap(t.Flags); err != nil {
+			return nil
+		}
+		if instance != nil {
+			maps.Copy(flags, instance.flags)
+		}
+	}
+	var env []envSource
+	if instance != nil {
+		env = instance.env
+	}
+	var cached []string
+	for _, dir := range paths {
+		envCtx, err := contextWithEnvSources(ctx, dir, env)
+		if err != nil {
+			continue
+		}
+		c, err := newTaskCache(p, t, effectiveName, dir, flags, pkrun.EnvConfigFromContext(envCtx))
+		if err != nil {
+			continue
+		}
+		if hit, err := c.hit(); err == nil && hit {
+			cached = append(cached, dir)
+		}
+	}
+	return cached
+}
+
+// cacheKey derives the cache file name from the task name, path, flags, and
+// environment. Maps marshal with sorted keys, so equal flags and variables
+// produce equal keys. Only the hash is stored, so secret values do not end up
+// in the cache directory.
+func cacheKey(effectiveName, execPath string, flags map[string]any, env pkrun.EnvConfig) (string, error) {
+	flagsJSON, err := json.Marshal(flags)
+	if err != nil {
+		return "", fmt.Errorf("task %q: encoding flags for cache key: %w", effectiveName, err)
+	}
+	envJSON, err := json.Marshal(env)
+	if err != nil {
+		return "", fmt.Errorf("task %q: encoding environment for cache key: %w", effectiveName, err)
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s", effectiveName, execPath, flagsJSON, envJSON)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// file returns the path of the file holding the recorded hash.
+func (c *taskCache) file() string {
+	return filepath.Join(c.gitRoot, ".pocket", cacheDirName, c.key)
+}
+
+// hit reports whether the current inputs and outputs match the recorded hash.
+func (c *taskCache) hit() (bool, error) {
+	recorded, err := os.ReadFile(c.file())
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("reading cache: %w", err)
+	}
+	sum, ok, err := c.hash()
+	if err != nil || !ok {
+		return false, err
+	}
+	if sum != strings.TrimSpace(string(recorded)) {
+		return false, nil
+	}
+	// Keep entries in use from being pruned.
+	now := time.Now()
+	_ = os.Chtimes(c.file(), now, now)
+	return true, nil
+}
+
+// store records the current hash after a successful run, and prunes entries
+// older than cacheMaxAge. Nothing is recorded if a declared output pattern
+// matches no files, or if a file disappears while it is hashed.
+func (c *taskCache) store() error {
+	sum, ok, err := c.hash()
+	if err != nil || !ok {
+		return err
+	}
+	dir := filepath.Dir(c.file())
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("creating cache directory: %w", err)
+	}
+	if err := pruneCache(dir, time.Now().Add(-cacheMaxAge)); err != nil {
+		return fmt.Errorf("pruning cache: %w", err)
+	}
+	// Write atomically so parallel tasks never observe a partial hash.
+	tmp, err := os.CreateTemp(dir, c.key+".*")
+	if err != nil {
+		return fmt.Errorf("writing cache: %w", err)
+	}
+	if _, err := tmp.WriteString(sum + "\n"); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("writing cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("writing cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.file()); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("writing cache: %w", err)
+	}
+	return nil
+}
+
+// pruneCache removes the entries in dir last stored or hit before cutoff.
+// Entries removed concurrently by a parallel task are ignored.
+func pruneCache(dir string, cutoff time.Time) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// hash returns a digest over the names and contents of all files matched by
+// the input and output patterns. ok is false when an output pattern matches
+// nothing, meaning the outputs are missing and the task must run, or when a
+// file disappears while it is hashed, such as a temporary file removed by a
+// concurrent process.
+func (c *taskCache) hash() (sum string, ok bool, err error) {
+	files, err := c.files()
+	if err != nil {
+		return "", false, err
+	}
+	return c.hashFiles(files)
+}
+
+// hashFiles computes the digest described at hash over files, given relative
+// to the execution path.
+func (c *taskCache) hashFiles(files []string) (sum string, ok bool, err error) {
+	h := sha256.New()
+	for i, patterns := range [][]string{c.inputs, c.outputs} {
+		kind := "input"
+		if i == 1 {
+			kind = "output"
+		}
+		for _, pattern := range patterns {
+			matched := 0
+			for _, rel := range files {
+				if !matchGlob(pattern, rel) {
+					continue
+				}
+				matched++
+				fileSum, err := hashFile(filepath.Join(c.gitRoot, c.path, filepath.FromSlash(rel)))
+				if errors.Is(err, fs.ErrNotExist) {
+					return "", false, nil
+				}
+				if err != nil {
+					return "", false, err
+				}
+				fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\n", kind, pattern, rel, fileSum)
+			}
+			if kind == "output" && matched == 0 {
+				return "", false, nil
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), true, nil
+}
+
+// files lists regular files below the execution path, relative to it with
+// forward slashes. Directories are skipped using the plan's walk rules.
+func (c *taskCache) files() ([]string, error) {
+	root := filepath.Join(c.gitRoot, c.path)
+	var files []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p != root && errors.Is(err, fs.ErrNotExist) {
+				return nil // Removed while walking.
+			}
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		if d.IsDir() {
+			if skipDir(d.Name(), c.skipDirs, c.includeHidden) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing cache inputs in %s: %w", c.path, err)
+	}
+	slices.Sort(files)
+	return files, nil
+}
+
+// hashFile returns the hex sha256 of a file's contents.
+func hashFile(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", fmt.Errorf("hashing %s: %w", name, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hashing %s: %w", name, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// matchGlob reports whether the slash-separated name matches pattern.
+// Pattern segments use [path.Match] syntax, and a "**" segment matches
+// zero or more directories.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// validateGlobs checks that all Inputs and Outputs patterns are well-formed.
+func validateGlobs(t *Task) error {
+	for _, pattern := range slices.Concat(t.Inputs, t.Outputs) {
+		if pattern == "" || path.IsAbs(pattern) || strings.Contains(pattern, "\\") {
+			return fmt.Errorf("task %q: invalid cache pattern %q: must be a relative, slash-separated glob", t.Name, pattern)
+		}
+		for seg := range strings.SplitSeq(pattern, "/") {
+			if seg == ".." {
+				return fmt.Errorf("task %q: invalid cache pattern %q: must not leave the task path", t.Name, pattern)
+			}
+			if _, err := path.Match(seg, ""); err != nil {
+				return fmt.Errorf("task %q: invalid cache pattern %q: %w", t.Name, pattern, err)
+			}
+		}
+	}
+	if len(t.Outputs) > 0 && len(t.Inputs) == 0 {
+		return fmt.Errorf("task %q: Outputs requires Inputs", t.Name)
+	}
+	return nil
+}
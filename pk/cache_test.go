@@ -0,0 +1,362 @@
+package pk
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+	pkrun "github.com/fredrikaverpil/pocket/pk/run"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"go.mod", "go.mod", true},
+		{"*.go", "main.go", true},
+		{"*.go", "cmd/main.go", false},
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "cmd/app/main.go", true},
+		{"cmd/**", "cmd/app/main.go", true},
+		{"cmd/**/main.go", "cmd/main.go", true},
+		{"cmd/**/main.go", "pkg/main.go", false},
+		{"**", "any/file.txt", true},
+		{"*.go", "go.mod", false},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateGlobs(t *testing.T) {
+	tests := []struct {
+		name    string
+		task    *Task
+		wantErr string
+	}{
+		{"Valid", &Task{Name: "t", Inputs: []string{"**/*.go"}, Outputs: []string{"bin/app"}}, ""},
+		{"Absolute", &Task{Name: "t", Inputs: []string{"/etc/passwd"}}, "relative"},
+		{"ParentDir", &Task{Name: "t", Inputs: []string{"../*.go"}}, "must not leave"},
+		{"BadPattern", &Task{Name: "t", Inputs: []string{"[.go"}}, "invalid cache pattern"},
+		{"OutputsWithoutInputs", &Task{Name: "t", Outputs: []string{"bin/app"}}, "Outputs requires Inputs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateGlobs(tt.task)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestTask_Run_Cache(t *testing.T) {
+	root := e2eSetup(t)
+	writeFile(t, filepath.Join(root, "main.go"), "package main")
+
+	var runs int
+	task := &Task{
+		Name:   "lint",
+		Usage:  "lint",
+		Inputs: []string{"**/*.go"},
+		Do: func(_ context.Context) error {
+			runs++
+			return nil
+		},
+	}
+	plan, err := newPlan(&Config{Auto: task}, root, []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// runOnce runs the task with a fresh tracker, as a new invocation would.
+	runOnce := func() string {
+		t.Helper()
+		ctx, out := integrationCtx(t, plan)
+		if err := task.run(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	runOnce()
+	if out := runOnce(); !strings.Contains(out, ":: lint [cached]") {
+		t.Errorf("expected cached header, got %q", out)
+	}
+	if runs != 1 {
+		t.Fatalf("expected unchanged inputs to skip the task, ran %d times", runs)
+	}
+
+	writeFile(t, filepath.Join(root, "main.go"), "package main // changed")
+	runOnce()
+	if runs != 2 {
+		t.Fatalf("expected changed inputs to run the task, ran %d times", runs)
+	}
+
+	// Files not matched by Inputs do not invalidate the cache.
+	writeFile(t, filepath.Join(root, "README.md"), "docs")
+	runOnce()
+	if runs != 2 {
+		t.Fatalf("expected unrelated file to keep the cache, ran %d times", runs)
+	}
+
+	// --no-cache bypasses the check.
+	ctx, _ := integrationCtx(t, plan)
+	ctx = context.WithValue(ctx, ctxkey.NoCache{}, true)
+	if err := task.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if runs != 3 {
+		t.Fatalf("expected --no-cache to run the task, ran %d times", runs)
+	}
+}
+
+func TestTask_Run_CacheKeyedByPathAndFlags(t *testing.T) {
+	root := e2eSetup(t)
+	writeFile(t, filepath.Join(root, "a", "main.go"), "package a")
+	writeFile(t, filepath.Join(root, "b", "main.go"), "package b")
+
+	type lintFlags struct {
+		Fix bool `flag:"fix" usage:"apply fixes"`
+	}
+	var runs []string
+	task := &Task{
+		Name:   "lint",
+		Usage:  "lint",
+		Flags:  lintFlags{},
+		Inputs: []string{"*.go"},
+		Do: func(ctx context.Context) error {
+			runs = append(runs, pkrun.PathFromContext(ctx))
+			return nil
+		},
+	}
+	plan, err := newPlan(&Config{Auto: WithOptions(task, WithPath("a", "b"))}, root, []string{".", "a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(cliFlags map[string]any) {
+		t.Helper()
+		ctx, _ := integrationCtx(t, plan)
+		if cliFlags != nil {
+			ctx = withCLIFlags(ctx, "lint", cliFlags)
+		}
+		if err := plan.tree.run(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run(nil)
+	run(nil)
+	if len(runs) != 2 {
+		t.Fatalf("expected one run per path, got %v", runs)
+	}
+
+	// Different resolved flags use a separate cache entry.
+	run(map[string]any{"fix": true})
+	if len(runs) != 4 {
+		t.Fatalf("expected changed flags to run again in both paths, got %v", runs)
+	}
+}
+
+func TestTask_Run_CacheKeyedByEnv(t *testing.T) {
+	root := e2eSetup(t)
+	writeFile(t, filepath.Join(root, "main.go"), "package main")
+	writeFile(t, filepath.Join(root, ".env"), "TARGET=linux")
+
+	var runs int
+	task := &Task{
+		Name:   "build",
+		Usage:  "build",
+		Inputs: []string{"*.go"},
+		Do: func(_ context.Context) error {
+			runs++
+			return nil
+		},
+	}
+
+	run := func(value string) {
+		t.Helper()
+		cfg := &Config{Auto: WithOptions(task, WithEnvFile(".env"), WithEnv("MODE="+value))}
+		plan, err := newPlan(cfg, root, []string{"."})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, _ := integrationCtx(t, plan)
+		if err := plan.tree.run(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run("debug")
+	run("debug")
+	if runs != 1 {
+		t.Fatalf("expected an unchanged environment to keep the cache, ran %d times", runs)
+	}
+
+	run("release")
+	if runs != 2 {
+		t.Fatalf("expected a changed WithEnv value to run the task, ran %d times", runs)
+	}
+
+	writeFile(t, filepath.Join(root, ".env"), "TARGET=darwin")
+	run("release")
+	if runs != 3 {
+		t.Fatalf("expected a changed env file to run the task, ran %d times", runs)
+	}
+}
+
+func TestTask_Run_CacheOutputs(t *testing.T) {
+	root := e2eSetup(t)
+	writeFile(t, filepath.Join(root, "main.go"), "package main")
+
+	var runs int
+	task := &Task{
+		Name:    "build",
+		Usage:   "build",
+		Inputs:  []string{"*.go"},
+		Outputs: []string{"bin/app"},
+		Do: func(_ context.Context) error {
+			runs++
+			writeFile(t, filepath.Join(root, "bin", "app"), "binary")
+			return nil
+		},
+	}
+	plan, err := newPlan(&Config{Auto: task}, root, []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runOnce := func() {
+		t.Helper()
+		ctx, _ := integrationCtx(t, plan)
+		if err := task.run(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runOnce()
+	runOnce()
+	if runs != 1 {
+		t.Fatalf("expected cached run, ran %d times", runs)
+	}
+
+	// A missing output forces a rerun.
+	if err := os.Remove(filepath.Join(root, "bin", "app")); err != nil {
+		t.Fatal(err)
+	}
+	runOnce()
+	if runs != 2 {
+		t.Fatalf("expected missing output to run the task, ran %d times", runs)
+	}
+}
+
+func TestTask_Run_CacheNotStoredOnFailure(t *testing.T) {
+	root := e2eSetup(t)
+	writeFile(t, filepath.Join(root, "main.go"), "package main")
+
+	var runs int
+	task := &Task{
+		Name:   "lint",
+		Usage:  "lint",
+		Inputs: []string{"*.go"},
+		Do: func(_ context.Context) error {
+			runs++
+			return os.ErrInvalid
+		},
+	}
+	plan, err := newPlan(&Config{Auto: task}, root, []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		ctx, _ := integrationCtx(t, plan)
+		_ = task.run(ctx)
+	}
+	if runs != 2 {
+		t.Errorf("expected failed runs not to be cached, ran %d times", runs)
+	}
+}
+
+func TestPrintTree_Cached(t *testing.T) {
+	root := e2eSetup(t)
+	writeFile(t, filepath.Join(root, "main.go"), "package main")
+
+	task := &Task{Name: "lint", Usage: "lint", Inputs: []string{"*.go"}, Do: func(_ context.Context) error { return nil }}
+	plan, err := newPlan(&Config{Auto: task}, root, []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, out := integrationCtx(t, plan)
+	printTree(ctx, plan.tree, "", true, "", nil, plan)
+	if strings.Contains(out.String(), "cached") {
+		t.Fatalf("expected no cached marker before first run, got:\n%s", out.String())
+	}
+
+	if err := task.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	printTree(ctx, plan.tree, "", true, "", nil, plan)
+	if !strings.Contains(out.String(), "lint [cached]") {
+		t.Errorf("expected cached marker after successful run, got:\n%s", out.String())
+	}
+}
+
+// writeFile writes content to name, creating parent directories.
+func writeFile(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTaskCache_FileRemovedWhileHashing(t *testing.T) {
+	root := e2eSetup(t)
+	writeFile(t, filepath.Join(root, "main.go"), "package main")
+	c := &taskCache{gitRoot: root, path: ".", key: "test", inputs: []string{"**/*.go"}}
+
+	// The file list includes a file that is gone by the time it is hashed.
+	if _, ok, err := c.hashFiles([]string{"gone.go", "main.go"}); err != nil || ok {
+		t.Fatalf("expected a removed file to skip the cache without error, got ok=%v, err=%v", ok, err)
+	}
+}
+
+func TestPruneCache(t *testing.T) {
+	dir := t.TempDir()
+	old, recent := filepath.Join(dir, "old"), filepath.Join(dir, "recent")
+	writeFile(t, old, "hash")
+	writeFile(t, recent, "hash")
+	stale := time.Now().Add(-cacheMaxAge - time.Hour)
+	if err := os.Chtimes(old, stale, stale); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pruneCache(dir, time.Now().Add(-cacheMaxAge)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Error("expected the stale entry to be pruned")
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Errorf("expected the recent entry to be kept, got %v", err)
+	}
+}
@@ -10,7 +10,9 @@ import (
 	"path/filepath"
 	"slices"
 	"sort"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/fredrikaverpil/pocket/internal/scaffold"
 	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
@@ -30,45 +32,130 @@ func RunMain(cfg *Config) {
 	printFinalStatus(tracker, nil)
 }
 
-func run(cfg *Config) (*executionTracker, error) {
+func run(cfg *Config) (tracker *executionTracker, err error) {
 	// Parse command-line flags
-	globalFlags := flag.NewFlagSet("pok", flag.ExitOnError)
-
-	var verbose, serial, gitDiff, commitsCheck, showHelp, showVersion, jsonOut bool
-	globalFlags.BoolVar(&verbose, "v", false, "verbose mode")
-	globalFlags.BoolVar(&verbose, "verbose", false, "verbose mode")
-	globalFlags.BoolVar(&serial, "s", false, "force serial execution (disables parallelism and output buffering)")
-	globalFlags.BoolVar(&serial, "serial", false, "force serial execution (disables parallelism and output buffering)")
-	globalFlags.BoolVar(&gitDiff, "g", false, "run git diff check after execution")
-	globalFlags.BoolVar(&gitDiff, "gitdiff", false, "run git diff check after execution")
-	globalFlags.BoolVar(&commitsCheck, "c", false, "validate conventional commits after execution")
-	globalFlags.BoolVar(&commitsCheck, "commits", false, "validate conventional commits after execution")
-	globalFlags.BoolVar(&showHelp, "h", false, "show help")
-	globalFlags.BoolVar(&showHelp, "help", false, "show help")
-	globalFlags.BoolVar(&showVersion, "version", false, "show version")
-	globalFlags.BoolVar(&jsonOut, "j", false, "emit task plan as JSON instead of executing")
-	globalFlags.BoolVar(&jsonOut, "json", false, "emit task plan as JSON instead of executing")
+	var opts globalOptions
+	globalFlags := newGlobalFlagSet(&opts)
 
 	// Parse flags
 	if err := globalFlags.Parse(os.Args[1:]); err != nil {
 		return nil, fmt.Errorf("parsing flags: %w", err)
 	}
 
+	if opts.jobs < 0 {
+		return nil, fmt.Errorf("invalid --jobs value %d: must be 0 (unlimited) or positive", opts.jobs)
+	}
+	if opts.timeout < 0 {
+		return nil, fmt.Errorf("invalid --timeout value %s: must be 0 (no limit) or positive", opts.timeout)
+	}
+	if !slices.Contains([]string{summaryText, summaryJSON, summaryOff}, opts.summary) {
+		return nil, fmt.Errorf("invalid --summary value %q: must be text, json, or off", opts.summary)
+	}
+	if !slices.Contains(outputModes, OutputMode(opts.output)) {
+		return nil, fmt.Errorf("invalid --output value %q: must be buffered or stream", opts.output)
+	}
+	if opts.summary == summaryJSON && opts.eventsTarget == "-" {
+		return nil, fmt.Errorf("--summary=json and --events - cannot both write to stdout")
+	}
+
 	// Set up base context with verbose and output
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	ctx, stop := withInterrupts(context.Background(), signals)
 	defer stop()
-	ctx = context.WithValue(ctx, ctxkey.Verbose{}, verbose)
-	ctx = context.WithValue(ctx, ctxkey.Serial{}, serial)
-	ctx = context.WithValue(ctx, ctxkey.GitDiff{}, gitDiff)
-	ctx = context.WithValue(ctx, ctxkey.CommitsCheck{}, commitsCheck)
+	ctx = context.WithValue(ctx, ctxkey.Verbose{}, opts.verbose)
+	ctx = context.WithValue(ctx, ctxkey.Serial{}, opts.serial)
+	ctx = context.WithValue(ctx, ctxkey.GitDiff{}, opts.gitDiff)
+	ctx = context.WithValue(ctx, ctxkey.CommitsCheck{}, opts.commitsCheck)
+	ctx = context.WithValue(ctx, ctxkey.NoCache{}, opts.noCache)
+	ctx = context.WithValue(ctx, ctxkey.KeepGoing{}, opts.keepGoing)
+	ctx = context.WithValue(ctx, ctxkey.DryRun{}, opts.dryRun)
+	ctx = context.WithValue(ctx, ctxkey.OutputMode{}, OutputMode(opts.output))
+	if opts.jobs > 0 {
+		ctx = withMaxParallel(ctx, opts.jobs)
+	}
+	if opts.timeout > 0 {
+		ctx = context.WithValue(ctx, ctxkey.Timeout{}, opts.timeout)
+	}
 	ctx = context.WithValue(ctx, ctxkey.Output{}, pkrun.StdOutput())
 
 	// Handle version flag
-	if showVersion {
+	if opts.showVersion {
 		pkrun.Printf(ctx, "pocket %s\n", version())
 		return nil, nil
 	}
 
+	// Write execution events as JSON lines. With "-", the events own stdout
+	// and human-readable output moves to stderr.
+	if opts.eventsTarget != "" {
+		events, err := openEventLog(opts.eventsTarget)
+		if err != nil {
+			return nil, err
+		}
+		if opts.eventsTarget == "-" {
+			ctx = context.WithValue(ctx, ctxkey.Output{}, &pkrun.Output{Stdout: os.Stderr, Stderr: os.Stderr})
+		}
+		ctx = withEventLog(ctx, events)
+		events.emit(event{Type: eventRunStart, Args: os.Args[1:], Version: version()})
+		defer func() {
+			if closeErr := events.runEnd(tracker, err); closeErr != nil && err == nil {
+				err = fmt.Errorf("closing events file: %w", closeErr)
+			}
+		}()
+	}
+
+	// Record a timeline of the run for chrome://tracing or Perfetto.
+	if opts.traceFile != "" {
+		trace, err := openTraceLog(opts.traceFile)
+		if err != nil {
+			return nil, err
+		}
+		ctx = withTraceLog(ctx, trace)
+		defer func() {
+			if traceErr := trace.finish(err); traceErr != nil && err == nil {
+				err = fmt.Errorf("writing trace file: %w", traceErr)
+			}
+		}()
+	}
+
+	// Summarize task outcomes once tasks have run. The JSON summary owns
+	// stdout, so human-readable output moves to stderr.
+	if opts.summary == summaryJSON {
+		ctx = context.WithValue(ctx, ctxkey.Output{}, &pkrun.Output{Stdout: os.Stderr, Stderr: os.Stderr})
+	}
+	if opts.summary != summaryOff {
+		start := time.Now()
+		defer func() {
+			if tracker == nil {
+				return
+			}
+			records := summaryRecords(tracker)
+			if opts.summary == summaryText {
+				writeSummaryText(os.Stderr, records, time.Since(start))
+				return
+			}
+			if jsonErr := writeSummaryJSON(os.Stdout, records, time.Since(start)); jsonErr != nil && err == nil {
+				err = fmt.Errorf("writing summary: %w", jsonErr)
+			}
+		}()
+	}
+
+	// Report task results as JUnit XML, including the output of failed tasks.
+	if opts.junitFile != "" {
+		ctx = context.WithValue(ctx, ctxkey.CaptureOutput{}, true)
+		start := time.Now()
+		defer func() {
+			if tracker == nil {
+				return
+			}
+			records := summaryRecords(tracker)
+			if junitErr := writeJUnitFile(opts.junitFile, records, time.Since(start)); junitErr != nil && err == nil {
+				err = junitErr
+			}
+		}()
+	}
+
 	// Ensure tools/go.mod exists to prevent go mod tidy from scanning downloaded tools.
 	gitRoot, err := repopath.GitRoot()
 	if err != nil {
@@ -86,8 +173,17 @@ func run(cfg *Config) (*executionTracker, error) {
 	}
 	ctx = context.WithValue(ctx, ctxkey.Plan{}, plan)
 
+	// Restrict execution to the task paths affected by changes since the ref.
+	if opts.since != "" {
+		files, err := changedFiles(ctx, gitRoot, opts.since)
+		if err != nil {
+			return nil, fmt.Errorf("--since: %w", err)
+		}
+		ctx = withAffected(ctx, plan.affectedPaths(files))
+	}
+
 	// Handle help flag
-	if showHelp {
+	if opts.showHelp {
 		printHelp(ctx, cfg, plan)
 		return nil, nil
 	}
@@ -96,7 +192,7 @@ func run(cfg *Config) (*executionTracker, error) {
 	remaining := globalFlags.Args()
 
 	// Handle -json: emit instead of executing.
-	if jsonOut {
+	if opts.jsonOut {
 		var taskName string
 		if len(remaining) > 0 {
 			taskName = remaining[0]
@@ -107,58 +203,243 @@ func run(cfg *Config) (*executionTracker, error) {
 		return nil, nil
 	}
 
+	// Completion receives the partial command line verbatim, flags included.
+	if len(remaining) > 0 && remaining[0] == completeCommand {
+		printCompletions(ctx, plan, remaining[1:])
+		return nil, nil
+	}
+
+	// On a terminal, show the progress of parallel branches while their output is buffered.
+	ctx, stopProgress := withTerminalProgress(ctx)
+	defer stopProgress()
+
 	// Handle task execution (builtins + user tasks)
+	var invocations []taskInvocation
 	if len(remaining) > 0 {
-		taskName := remaining[0]
-		taskArgs := remaining[1:]
-
-		instance := findTask(plan, taskName)
-		if instance == nil {
-			return nil, fmt.Errorf("unknown task %q\nRun 'pok -h' to see available tasks", taskName)
+		invocations, err = parseInvocations(plan, remaining)
+		if err != nil {
+			return nil, err
 		}
-
-		// Parse task flags (handles -h/--help via flag.ErrHelp).
-		if instance.task.flagSet != nil {
-			if err := instance.task.flagSet.Parse(taskArgs); err != nil {
-				if errors.Is(err, flag.ErrHelp) {
-					printTaskHelp(ctx, instance.task)
-					return nil, nil
-				}
-				return nil, fmt.Errorf("parsing flags for task %q: %w", taskName, err)
+		for _, inv := range invocations {
+			if inv.help {
+				printTaskHelp(ctx, inv.instance.task)
+				return nil, nil
 			}
-			ctx = context.WithValue(ctx, ctxkey.TaskArgs{}, instance.task.flagSet.Args())
-			// Extract only explicitly-set CLI flags (not defaults) for
-			// highest-priority override in task.run().
-			cliFlags := make(map[string]any)
-			instance.task.flagSet.Visit(func(f *flag.Flag) {
-				if getter, ok := f.Value.(flag.Getter); ok {
-					cliFlags[f.Name] = getter.Get()
+		}
+
+		// Builtins run on their own.
+		if len(invocations) > 1 {
+			for _, inv := range invocations {
+				if isBuiltinName(inv.instance.task.Name) {
+					return nil, fmt.Errorf("builtin task %q cannot be combined with other tasks", inv.instance.name)
 				}
-			})
-			if len(cliFlags) > 0 {
-				ctx = withCLIFlags(ctx, taskName, cliFlags)
 			}
 		}
 
 		// Check if this is a builtin task.
-		if isBuiltinName(instance.task.Name) {
-			// Builtins run directly without path context.
-			if err := instance.task.run(ctx); err != nil {
-				return nil, err
-			}
-			if instance.task.Name == execTask.Name {
-				return nil, nil
+		if inv := invocations[0]; isBuiltinName(inv.instance.task.Name) {
+			if inv.instance.task.Name == rerunTask.Name {
+				// Rerun the tasks of the last run, recorded as a run of its own.
+				if len(inv.args) > 0 {
+					return nil, fmt.Errorf("rerun accepts no arguments, got %q", inv.args)
+				}
+				failedOnly, _ := inv.cliFlags["failed"].(bool)
+				invocations, err = rerunInvocations(ctx, plan, failedOnly)
+				if err != nil || len(invocations) == 0 {
+					return nil, err
+				}
+			} else {
+				// Builtins run directly without path context.
+				if err := inv.instance.task.run(inv.context(ctx)); err != nil {
+					return nil, err
+				}
+				if inv.instance.task.Name == execTask.Name || inv.instance.task.Name == watchTask.Name {
+					return nil, nil
+				}
+				return nil, runPostActions(ctx)
 			}
-			return nil, runPostActions(ctx)
 		}
+	}
+
+	ctx, pruneLogs := withRunLogs(ctx, plan)
+	defer pruneLogs()
+
+	// Record the run in the history, for the history and rerun builtins.
+	start := time.Now()
+	defer func() {
+		entry := newHistoryEntry(start, os.Args[1:], invocations, tracker, err)
+		if historyErr := appendHistory(entry); historyErr != nil {
+			pkrun.Errorf(ctx, "pocket: recording run history: %v\n", historyErr)
+		}
+	}()
 
-		return executeTask(ctx, instance)
+	if len(invocations) > 0 {
+		return executeTasks(ctx, invocations, opts.parallel)
 	}
 
+	// Before executing the full configuration, generate shims.
+	if plan != nil && plan.tree != nil {
+		if err := shimsTask.run(ctx); err != nil {
+			return nil, err
+		}
+	}
 	// Execute the full configuration with pre-built Plan.
 	return executeAll(ctx, plan)
 }
 
+// globalOptions holds the values of the global command-line flags.
+type globalOptions struct {
+	verbose, serial, gitDiff, commitsCheck, showHelp, showVersion, jsonOut bool
+	noCache, keepGoing, dryRun, parallel                                   bool
+	jobs                                                                   int
+	timeout                                                                time.Duration
+	since, eventsTarget, junitFile, traceFile, summary, output             string
+}
+
+// withInterrupts returns a context that is cancelled by the first signal
+// received on signals, as registered with [signal.Notify]. The signal after
+// that cancels the cleanup of [Finally] too, which otherwise runs to
+// completion, so pressing Ctrl-C twice stops Pocket promptly. Signals are then
+// no longer relayed, so a third one gets their default handling. The returned
+// function releases the resources of both contexts.
+func withInterrupts(parent context.Context, signals chan os.Signal) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	forceCtx, force := context.WithCancel(parent)
+	done := make(chan struct{})
+	go func() {
+		for _, cancelNext := range []context.CancelFunc{cancel, force} {
+			select {
+			case <-signals:
+				cancelNext()
+			case <-done:
+				return
+			}
+		}
+		signal.Stop(signals)
+	}()
+	ctx = context.WithValue(ctx, ctxkey.ForceInterrupt{}, forceCtx)
+	return ctx, func() {
+		close(done)
+		cancel()
+		force()
+	}
+}
+
+// newGlobalFlagSet returns the flag set for the global flags, storing their
+// values in o. Shell completion uses it to list the flags.
+func newGlobalFlagSet(o *globalOptions) *flag.FlagSet {
+	fs := flag.NewFlagSet("pok", flag.ExitOnError)
+	fs.BoolVar(&o.verbose, "v", false, "verbose mode")
+	fs.BoolVar(&o.verbose, "verbose", false, "verbose mode")
+	fs.BoolVar(&o.serial, "s", false, "force serial execution (disables parallelism and output buffering)")
+	fs.BoolVar(&o.serial, "serial", false, "force serial execution (disables parallelism and output buffering)")
+	fs.BoolVar(&o.gitDiff, "g", false, "run git diff check after execution")
+	fs.BoolVar(&o.gitDiff, "gitdiff", false, "run git diff check after execution")
+	fs.BoolVar(&o.commitsCheck, "c", false, "validate conventional commits after execution")
+	fs.BoolVar(&o.commitsCheck, "commits", false, "validate conventional commits after execution")
+	fs.BoolVar(&o.showHelp, "h", false, "show help")
+	fs.BoolVar(&o.showHelp, "help", false, "show help")
+	fs.BoolVar(&o.showVersion, "version", false, "show version")
+	fs.BoolVar(&o.jsonOut, "j", false, "emit task plan as JSON instead of executing")
+	fs.BoolVar(&o.jsonOut, "json", false, "emit task plan as JSON instead of executing")
+	fs.BoolVar(&o.keepGoing, "k", false, "keep going after failures and report all of them")
+	fs.BoolVar(&o.keepGoing, "keep-going", false, "keep going after failures and report all of them")
+	fs.BoolVar(&o.dryRun, "n", false, "print commands instead of executing them")
+	fs.BoolVar(&o.dryRun, "dry-run", false, "print commands instead of executing them")
+	fs.BoolVar(&o.noCache, "no-cache", false, "ignore cached task results and run all tasks")
+	fs.StringVar(&o.output, "output", string(OutputBuffered), "write parallel output buffered per branch, or stream it")
+	fs.BoolVar(&o.parallel, "parallel", false, "run multiple named tasks concurrently")
+	// -j is taken by --json, so the short form of --jobs is -J.
+	fs.IntVar(&o.jobs, "J", 0, "run at most N tasks concurrently (0 = unlimited)")
+	fs.IntVar(&o.jobs, "jobs", 0, "run at most N tasks concurrently (0 = unlimited)")
+	fs.StringVar(&o.eventsTarget, "events", "", "write execution events as JSON lines to a file, or - for stdout")
+	fs.StringVar(&o.junitFile, "junit", "", "write task results as a JUnit XML report to a file")
+	fs.StringVar(&o.summary, "summary", summaryText, "print a run summary as text or json, or off")
+	fs.StringVar(&o.since, "since", "", "only run tasks in paths changed since the git ref")
+	fs.StringVar(&o.traceFile, "trace", "", "write a Chrome trace-event timeline of the run to a file")
+	fs.DurationVar(&o.timeout, "timeout", 0, "fail tasks that run longer than the duration (0 = no limit)")
+	return fs
+}
+
+// taskInvocation is a task named on the command line, with its flags.
+type taskInvocation struct {
+	instance *taskInstance
+	cliFlags map[string]any // Task flags set explicitly on the command line.
+	args     []string       // Positional args remaining after flag parsing (builtins only).
+	flagArgs []string       // Flag arguments as given on the command line, recorded in the run history.
+	help     bool           // The task's -h/--help flag was given.
+}
+
+// context returns ctx carrying the invocation's flags and positional args.
+func (inv taskInvocation) context(ctx context.Context) context.Context {
+	if inv.args != nil {
+		ctx = context.WithValue(ctx, ctxkey.TaskArgs{}, inv.args)
+	}
+	if len(inv.cliFlags) > 0 {
+		ctx = withCLIFlags(ctx, inv.instance.name, inv.cliFlags)
+	}
+	return ctx
+}
+
+// parseInvocations splits the positional command-line arguments into task
+// invocations. Each task name is followed by its flags, and the first argument
+// that is not a flag starts the next task, as in "pok go-lint -fix go-test".
+// A "--" separator also ends a task's arguments. Builtins take all arguments up
+// to the next separator as positional args, as in "pok watch go-test".
+func parseInvocations(plan *Plan, args []string) ([]taskInvocation, error) {
+	var invocations []taskInvocation
+	for len(args) > 0 {
+		name := args[0]
+		args = args[1:]
+		if name == "--" {
+			continue
+		}
+
+		instance := findTask(plan, name)
+		if instance == nil {
+			return nil, fmt.Errorf("unknown task %q\nRun 'pok -h' to see available tasks", name)
+		}
+
+		// Arguments up to the next separator belong to this task.
+		end := slices.Index(args, "--")
+		if end < 0 {
+			end = len(args)
+		}
+		taskArgs := args[:end]
+		args = args[end:]
+
+		// Parse with a fresh flag set, so a task named twice does not inherit
+		// the flags of its first invocation.
+		fs, err := buildFlagSetFromStruct(instance.task.Name, instance.task.Flags)
+		if err != nil {
+			return nil, err
+		}
+		inv := taskInvocation{instance: instance}
+		if err := fs.Parse(taskArgs); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				inv.help = true
+				return append(invocations, inv), nil
+			}
+			return nil, fmt.Errorf("parsing flags for task %q: %w", name, err)
+		}
+		// Extract only explicitly-set CLI flags (not defaults) for
+		// highest-priority override in task.run().
+		inv.cliFlags = explicitFlags(fs)
+		inv.flagArgs = taskArgs[:len(taskArgs)-len(fs.Args())]
+
+		if isBuiltinName(instance.task.Name) {
+			inv.args = fs.Args()
+		} else {
+			args = append(slices.Clone(fs.Args()), args...)
+		}
+		invocations = append(invocations, inv)
+	}
+	if len(invocations) == 0 {
+		return nil, fmt.Errorf("expected a task name around \"--\"")
+	}
+	return invocations, nil
+}
+
 // findTask looks up a task by name, checking builtins first then user tasks.
 func findTask(plan *Plan, name string) *taskInstance {
 	for _, t := range builtins {
@@ -183,6 +464,8 @@ func printFinalStatus(tracker *executionTracker, err error) {
 		emoji, message = "🧹", "Pocket detected uncommitted changes"
 	case errors.Is(err, errCommitsInvalid):
 		emoji, message = "📝", "Pocket detected invalid commit messages"
+	case err != nil && tracker != nil && len(tracker.failed()) > 1:
+		emoji, message = "💥", formatFailures(tracker.failed())
 	case err != nil:
 		emoji, message = "💥", fmt.Sprintf("Error: %v", err)
 	case tracker != nil && tracker.warnings():
@@ -197,7 +480,21 @@ func printFinalStatus(tracker *executionTracker, err error) {
 		message = emoji + " " + message
 	}
 
-	fmt.Fprintln(os.Stderr, message)
+	fmt.Fprintln(os.Stderr, pkrun.Redact(message))
+}
+
+// formatFailures lists each failed task and path, as collected in keep-going mode.
+func formatFailures(failures []*taskError) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Error: %d tasks failed:", len(failures))
+	for _, f := range failures {
+		label := f.name
+		if f.path != "." {
+			label += " [" + f.path + "]"
+		}
+		fmt.Fprintf(&b, "\n  - %s: %v", label, f.err)
+	}
+	return b.String()
 }
 
 // findTaskByName looks up a task instance by name in the Plan.
@@ -249,6 +546,35 @@ func ExecuteTask(ctx context.Context, name string, p *Plan) error {
 	return err
 }
 
+// Execute runs tasks from cfg in the repository at the git root, the way
+// ./pok does: with no names, the auto tasks run; otherwise, the named tasks
+// run in order, sharing deduplication. Unlike [RunMain], Execute does not
+// parse arguments, generate shims, or exit the process, which makes it
+// suitable for tests (see package pktest).
+func Execute(ctx context.Context, cfg *Config, names ...string) error {
+	plan, err := newPublicPlan(cfg)
+	if err != nil {
+		return fmt.Errorf("building plan: %w", err)
+	}
+	ctx = context.WithValue(ctx, ctxkey.Plan{}, plan)
+
+	if len(names) == 0 {
+		_, err := executeAll(ctx, plan)
+		return err
+	}
+
+	invocations := make([]taskInvocation, 0, len(names))
+	for _, name := range names {
+		instance := findTaskByName(plan, name)
+		if instance == nil {
+			return fmt.Errorf("task %q not found in plan", name)
+		}
+		invocations = append(invocations, taskInvocation{instance: instance})
+	}
+	_, err = executeTasks(ctx, invocations, false)
+	return err
+}
+
 // runPostActions runs post-execution checks (git diff, commits validation).
 func runPostActions(ctx context.Context) error {
 	if err := gitDiffTask.run(ctx); err != nil {
@@ -259,10 +585,39 @@ func runPostActions(ctx context.Context) error {
 
 // executeTask runs a single task with proper path context and returns the tracker.
 func executeTask(ctx context.Context, instance *taskInstance) (*executionTracker, error) {
+	return executeTasks(ctx, []taskInvocation{{instance: instance}}, false)
+}
+
+// executeTasks runs the invocations in order, or concurrently when parallel is
+// set, and returns the tracker. The invocations share one tracker, so a task
+// or dependency runs once per path however many invocations reach it, and the
+// post-execution checks run once at the end.
+func executeTasks(ctx context.Context, invocations []taskInvocation, parallel bool) (*executionTracker, error) {
 	tracker := newExecutionTracker()
 	ctx = withExecutionTracker(ctx, tracker)
 
-	if err := instance.execute(ctx); err != nil {
+	var err error
+	if parallel && len(invocations) > 1 && !serialFromContext(ctx) {
+		err = runConcurrently(ctx, len(invocations),
+			func(i int) string { return invocations[i].instance.name },
+			func(ctx context.Context, i int) error {
+				return invocations[i].instance.execute(invocations[i].context(ctx))
+			},
+		)
+	} else {
+		keepGoing := keepGoingFromContext(ctx)
+		var errs []error
+		for _, inv := range invocations {
+			if err := inv.instance.execute(inv.context(ctx)); err != nil {
+				if !keepGoing {
+					return tracker, err
+				}
+				errs = append(errs, err)
+			}
+		}
+		err = errors.Join(errs...)
+	}
+	if err != nil {
 		return tracker, err
 	}
 
@@ -278,6 +633,10 @@ func (inst *taskInstance) execute(ctx context.Context) error {
 		ctx = contextWithNameSuffix(ctx, suffix)
 	}
 
+	if inst.outputMode != "" {
+		ctx = context.WithValue(ctx, ctxkey.OutputMode{}, inst.outputMode)
+	}
+
 	// Determine execution paths.
 	paths := inst.resolvedPaths
 	if len(paths) == 0 {
@@ -292,31 +651,53 @@ func (inst *taskInstance) execute(ctx context.Context) error {
 		paths = []string{taskScope}
 	}
 
+	runPath := func(ctx context.Context, path string) error {
+		ctx, err := contextWithEnvSources(pkrun.ContextWithPath(ctx, path), path, inst.env)
+		if err != nil {
+			return fmt.Errorf("task %s in %s: %w", inst.name, path, err)
+		}
+		if err := inst.task.run(ctx); err != nil {
+			return fmt.Errorf("task %s in %s: %w", inst.name, path, err)
+		}
+		return nil
+	}
+
+	// With WithParallelPaths, fan out across the paths.
+	if inst.parallelPaths && len(paths) > 1 && !serialFromContext(ctx) {
+		return runConcurrently(ctx, len(paths),
+			func(i int) string { return "path " + paths[i] },
+			func(ctx context.Context, i int) error { return runPath(ctx, paths[i]) },
+		)
+	}
+
 	// Execute task for each path.
+	keepGoing := keepGoingFromContext(ctx)
+	var errs []error
 	for _, path := range paths {
-		pathCtx := pkrun.ContextWithPath(ctx, path)
-		if err := inst.task.run(pathCtx); err != nil {
-			return fmt.Errorf("task %s in %s: %w", inst.name, path, err)
+		if err := runPath(ctx, path); err != nil {
+			if !keepGoing {
+				return err
+			}
+			errs = append(errs, err)
 		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
+// executeAll runs the auto tasks of the plan and returns the tracker. Tasks
+// marked manual are recorded as skipped.
 func executeAll(ctx context.Context, p *Plan) (*executionTracker, error) {
 	if p == nil || p.tree == nil {
 		return nil, nil
 	}
 
-	// Before: generate shims
-	if err := shimsTask.run(ctx); err != nil {
-		return nil, err
-	}
-
 	// Execute with Plan and execution tracker in context.
 	tracker := newExecutionTracker()
 	ctx = withExecutionTracker(ctx, tracker)
 	ctx = context.WithValue(ctx, ctxkey.AutoExec{}, true)
-	if err := p.tree.run(ctx); err != nil {
+	err := p.tree.run(ctx)
+	recordManualTasks(tracker, p)
+	if err != nil {
 		return tracker, err
 	}
 
@@ -328,28 +709,24 @@ func printHelp(ctx context.Context, _ *Config, plan *Plan) {
 	pkrun.Printf(ctx, "pocket %s\n\n", version())
 	pkrun.Println(ctx, "Usage:")
 	pkrun.Println(ctx, "  pok [global-flags]")
-	pkrun.Println(ctx, "  pok [global-flags] <task> [task-flags]")
+	pkrun.Println(ctx, "  pok [global-flags] <task> [task-flags] [-- <task> [task-flags]]...")
 
 	var regularTasks []taskInstance
 	var manualTasks []taskInstance
 
-	if plan != nil && len(plan.taskInstances) > 0 {
-		taskScope := os.Getenv("TASK_SCOPE")
-		for _, instance := range plan.taskInstances {
-			if instance.task.Hidden || !plan.taskRunsInPath(instance.name, taskScope) {
-				continue
-			}
-			if instance.isManual {
-				manualTasks = append(manualTasks, instance)
-			} else {
-				regularTasks = append(regularTasks, instance)
-			}
+	for _, instance := range visibleTaskInstances(plan) {
+		if instance.isManual {
+			manualTasks = append(manualTasks, instance)
+		} else {
+			regularTasks = append(regularTasks, instance)
 		}
 	}
 
 	allNames := []string{
-		"-c, --commits", "-g, --gitdiff", "-h, --help", "-j, --json",
-		"-s, --serial", "-v, --verbose", "--version",
+		"-c, --commits", "--events FILE", "-g, --gitdiff", "-h, --help", "-j, --json",
+		"-J, --jobs N", "--junit FILE", "-k, --keep-going", "-n, --dry-run", "--no-cache", "--output MODE",
+		"--parallel", "-s, --serial", "--since REF", "--summary FMT", "--timeout D", "--trace FILE",
+		"-v, --verbose", "--version",
 	}
 	for _, t := range builtins {
 		if !t.Hidden {
@@ -373,9 +750,17 @@ func printHelp(ctx context.Context, _ *Config, plan *Plan) {
 	pkrun.Println(ctx)
 	pkrun.Println(ctx, "Global flags:")
 	pkrun.Printf(ctx, "  %-*s  %s\n", maxWidth, "-c, --commits", "validate conventional commits after execution")
+	pkrun.Printf(ctx, "  %-*s  %s\n", maxWidth, "--events FILE", "write execution events as JSON lines (- for stdout)")
 	pkrun.Printf(ctx, "  %-*s  %s\n", maxWidth, "-g, --gitdiff", "run git diff check after execution")
 	pkrun.Printf(ctx, "  %-*s  %s\n", maxWidth, "-h, --help", "show help")
 	pkrun.Printf(ctx, "  %-*s  %s\n", maxWidth, "-j, --json", "emit task plan as JSON instead of executing")
+	pkrun.Printf(ctx, "  %-*s  %s\n", maxWidth, "-J, --jobs N", "run at most N tasks concurrently (0 = unlimited)")
+	pkrun.Printf(ctx, "  %-*s  %s\n", maxWidth, "--junit FILE", "write task results as a JUnit XML report")
+	pkrun.Printf(ctx, "  %-*s  %s\n", maxWidth, "-k, --keep-going", "keep going after failures and report all of them")
+	pkrun.Printf(ctx, "  %-*s  %s\n", maxWidth, "-n, --dry-run", "print commands instead of executing them")
+	pkrun.Printf(ctx, "  %-*s  %s\n", maxWidth, "--no-cache", "ignore cached task results and run all tasks")
+	pkrun.Printf(ctx, "  %-*s  %s\n", maxWidth, "--output MODE", "write parallel output buffered (default) or stream")
+	pkrun.Printf(ctx, "  %-*s  %s\n", maxWidth, "--parallel", "run multiple named tasks concurrently")
 	pkrun.Printf(
 		ctx,
 		"  %-*s  %s\n",
@@ -383,6 +768,10 @@ func printHelp(ctx context.Context, _ *Config, plan *Plan) {
 		"-s, --serial",
 		"force serial execution (disables parallelism and output buffering)",
 	)
+	pkrun.Printf(ctx, "  %-*s  %s\n", maxWidth, "--since REF", "only run tasks in paths changed since the git ref")
+	pkrun.Printf(ctx, "  %-*s  %s\n", maxWidth, "--summary FMT", "print a run summary as text (default), json, or off")
+	pkrun.Printf(ctx, "  %-*s  %s\n", maxWidth, "--timeout D", "fail tasks that run longer than D (e.g. 10m)")
+	pkrun.Printf(ctx, "  %-*s  %s\n", maxWidth, "--trace FILE", "write a Chrome trace-event timeline of the run")
 	pkrun.Printf(ctx, "  %-*s  %s\n", maxWidth, "-v, --verbose", "verbose mode")
 	pkrun.Printf(ctx, "  %-*s  %s\n", maxWidth, "--version", "show version")
 
@@ -401,6 +790,22 @@ func printHelp(ctx context.Context, _ *Config, plan *Plan) {
 	pkrun.Println(ctx, "Run 'pok <task> -h' for task-specific flags.")
 }
 
+// visibleTaskInstances returns the task instances listed in help: those not
+// hidden and, within a shim's TASK_SCOPE, those that run in the scoped path.
+func visibleTaskInstances(plan *Plan) []taskInstance {
+	if plan == nil {
+		return nil
+	}
+	taskScope := os.Getenv("TASK_SCOPE")
+	var visible []taskInstance
+	for _, instance := range plan.taskInstances {
+		if !instance.task.Hidden && plan.taskRunsInPath(instance.name, taskScope) {
+			visible = append(visible, instance)
+		}
+	}
+	return visible
+}
+
 // printTaskSection prints a section of tasks with a header.
 func printTaskSection(ctx context.Context, header string, instances []taskInstance, width int) {
 	if len(instances) == 0 {
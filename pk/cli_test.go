@@ -4,9 +4,12 @@ import (
 	"context"
 	"errors"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
 	"github.com/fredrikaverpil/pocket/pk/repopath"
 )
 
@@ -34,6 +37,49 @@ func TestRun_VersionDoesNotRequireGitRepo(t *testing.T) {
 	}
 }
 
+func TestRun_NegativeJobsRejected(t *testing.T) {
+	for _, flagName := range []string{"--jobs", "-J"} {
+		withArgs(t, "pok", flagName, "-1")
+
+		_, err := run(&Config{})
+		if err == nil || !strings.Contains(err.Error(), "invalid --jobs value") {
+			t.Fatalf("%s: expected invalid --jobs error, got %v", flagName, err)
+		}
+	}
+}
+
+func TestRun_NegativeTimeoutRejected(t *testing.T) {
+	withArgs(t, "pok", "--timeout", "-1s")
+
+	_, err := run(&Config{})
+	if err == nil || !strings.Contains(err.Error(), "invalid --timeout value") {
+		t.Fatalf("expected invalid --timeout error, got %v", err)
+	}
+}
+
+func TestRun_InvalidOutputRejected(t *testing.T) {
+	withArgs(t, "pok", "--output", "interleaved")
+
+	_, err := run(&Config{})
+	if err == nil || !strings.Contains(err.Error(), "invalid --output value") {
+		t.Fatalf("expected invalid --output error, got %v", err)
+	}
+}
+
+func TestRun_InvalidSummaryRejected(t *testing.T) {
+	withArgs(t, "pok", "--summary", "xml")
+
+	_, err := run(&Config{})
+	if err == nil || !strings.Contains(err.Error(), "invalid --summary value") {
+		t.Fatalf("expected invalid --summary error, got %v", err)
+	}
+
+	withArgs(t, "pok", "--summary", "json", "--events", "-")
+	if _, err := run(&Config{}); err == nil || !strings.Contains(err.Error(), "cannot both write to stdout") {
+		t.Fatalf("expected stdout conflict error, got %v", err)
+	}
+}
+
 func TestFindTask_Builtin(t *testing.T) {
 	// findTask should return builtins even with nil plan.
 	instance := findTask(nil, "plan")
@@ -162,3 +208,234 @@ func withWorkingDir(t *testing.T, dir string) {
 		repopath.SetGitRootFunc(nil)
 	})
 }
+
+func TestFormatFailures(t *testing.T) {
+	got := formatFailures([]*taskError{
+		{name: "go-lint", path: "services/api", err: errors.New("exit status 1")},
+		{name: "go-test", path: ".", err: errors.New("exit status 2")},
+	})
+	want := "Error: 2 tasks failed:\n" +
+		"  - go-lint [services/api]: exit status 1\n" +
+		"  - go-test: exit status 2"
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestParseInvocations(t *testing.T) {
+	type lintFlags struct {
+		Fix bool `flag:"fix" usage:"apply fixes"`
+	}
+	type testFlags struct {
+		Race bool `flag:"race" usage:"enable race detector"`
+	}
+	noop := func(_ context.Context) error { return nil }
+	cfg := &Config{Auto: Serial(
+		&Task{Name: "format", Usage: "format", Do: noop},
+		&Task{Name: "lint", Usage: "lint", Flags: lintFlags{Fix: true}, Do: noop},
+		&Task{Name: "test", Usage: "test", Flags: testFlags{}, Do: noop},
+	)}
+	plan, err := newPlan(cfg, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type want struct {
+		name     string
+		cliFlags map[string]any
+		args     []string
+	}
+	tests := []struct {
+		name    string
+		args    []string
+		want    []want
+		wantErr string
+	}{
+		{
+			name: "Names",
+			args: []string{"format", "lint", "test"},
+			want: []want{{name: "format"}, {name: "lint"}, {name: "test"}},
+		},
+		{
+			name: "Separator",
+			args: []string{"lint", "-fix=false", "--", "test", "-race"},
+			want: []want{
+				{name: "lint", cliFlags: map[string]any{"fix": false}},
+				{name: "test", cliFlags: map[string]any{"race": true}},
+			},
+		},
+		{
+			name: "FlagsWithoutSeparator",
+			args: []string{"lint", "-fix=false", "test", "-race"},
+			want: []want{
+				{name: "lint", cliFlags: map[string]any{"fix": false}},
+				{name: "test", cliFlags: map[string]any{"race": true}},
+			},
+		},
+		{
+			name: "BuiltinTakesPositionalArgs",
+			args: []string{"watch", "lint", "test"},
+			want: []want{{name: "watch", args: []string{"lint", "test"}}},
+		},
+		{
+			name: "BuiltinArgsEndAtSeparator",
+			args: []string{"watch", "lint", "--", "test"},
+			want: []want{{name: "watch", args: []string{"lint"}}, {name: "test"}},
+		},
+		{
+			name:    "UnknownTask",
+			args:    []string{"lint", "bogus"},
+			wantErr: `unknown task "bogus"`,
+		},
+		{
+			name:    "UnknownFlag",
+			args:    []string{"lint", "-bogus"},
+			wantErr: `parsing flags for task "lint"`,
+		},
+		{
+			name:    "OnlySeparator",
+			args:    []string{"--"},
+			wantErr: "expected a task name",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invocations, err := parseInvocations(plan, tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []want
+			for _, inv := range invocations {
+				got = append(got, want{name: inv.instance.name, cliFlags: inv.cliFlags, args: inv.args})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInvocations_Help(t *testing.T) {
+	noop := func(_ context.Context) error { return nil }
+	plan, err := newPlan(&Config{Auto: &Task{Name: "lint", Usage: "lint", Do: noop}}, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	invocations, err := parseInvocations(plan, []string{"lint", "--", "lint", "-h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invocations) != 2 || !invocations[1].help {
+		t.Errorf("expected help on the second invocation, got %+v", invocations)
+	}
+}
+
+func TestExecuteTasks_SharesDedup(t *testing.T) {
+	var runs []string
+	record := func(name string) func(context.Context) error {
+		return func(_ context.Context) error {
+			runs = append(runs, name)
+			return nil
+		}
+	}
+	generate := &Task{Name: "generate", Usage: "generate", Do: record("generate")}
+	lint := &Task{Name: "lint", Usage: "lint", Deps: []Runnable{generate}, Do: record("lint")}
+	test := &Task{Name: "test", Usage: "test", Deps: []Runnable{generate}, Do: record("test")}
+	plan, err := newPlan(&Config{Auto: Serial(lint, test)}, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := integrationCtx(t, plan)
+	invocations, err := parseInvocations(plan, []string{"lint", "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := executeTasks(ctx, invocations, false); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"generate", "lint", "test"}; !reflect.DeepEqual(runs, want) {
+		t.Errorf("got runs %v, want %v", runs, want)
+	}
+}
+
+func TestExecuteTasks_StopsOnFirstError(t *testing.T) {
+	errBoom := errors.New("boom")
+	var ranTest bool
+	lint := &Task{Name: "lint", Usage: "lint", Do: func(_ context.Context) error { return errBoom }}
+	test := &Task{Name: "test", Usage: "test", Do: func(_ context.Context) error { ranTest = true; return nil }}
+	plan, err := newPlan(&Config{Auto: Serial(lint, test)}, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := integrationCtx(t, plan)
+	invocations, err := parseInvocations(plan, []string{"lint", "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := executeTasks(ctx, invocations, false); !errors.Is(err, errBoom) {
+		t.Fatalf("expected errBoom, got %v", err)
+	}
+	if ranTest {
+		t.Error("expected test not to run after lint failed")
+	}
+
+	ctx = context.WithValue(ctx, ctxkey.KeepGoing{}, true)
+	if _, err := executeTasks(ctx, invocations, false); !errors.Is(err, errBoom) {
+		t.Fatalf("expected errBoom, got %v", err)
+	}
+	if !ranTest {
+		t.Error("expected test to run after lint failed in keep-going mode")
+	}
+}
+
+func TestExecuteTasks_Parallel(t *testing.T) {
+	barrier := newPathBarrier(2)
+	lint := &Task{Name: "lint", Usage: "lint", HideHeader: true, Do: barrier.wait}
+	test := &Task{Name: "test", Usage: "test", HideHeader: true, Do: barrier.wait}
+	plan, err := newPlan(&Config{Auto: Serial(lint, test)}, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := integrationCtx(t, plan)
+	invocations, err := parseInvocations(plan, []string{"lint", "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := executeTasks(ctx, invocations, true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRun_BuiltinCannotBeCombined(t *testing.T) {
+	root := e2eSetup(t)
+	writeFile(t, filepath.Join(root, ".pocket", "go.mod"), "module pocket\n\ngo 1.26\n")
+	noop := func(_ context.Context) error { return nil }
+	withArgs(t, "pok", "--summary", "off", "plan", "--", "lint")
+	_, err := run(&Config{Auto: &Task{Name: "lint", Usage: "lint", Do: noop}})
+	if err == nil || !strings.Contains(err.Error(), `builtin task "plan" cannot be combined`) {
+		t.Fatalf("expected builtin combination error, got %v", err)
+	}
+
+	// A builtin after a task is rejected too, before the task runs.
+	var ran bool
+	withArgs(t, "pok", "--summary", "off", "lint", "rerun")
+	_, err = run(&Config{Auto: &Task{Name: "lint", Usage: "lint", Do: func(_ context.Context) error {
+		ran = true
+		return nil
+	}}})
+	if err == nil || !strings.Contains(err.Error(), `builtin task "rerun" cannot be combined`) {
+		t.Fatalf("expected builtin combination error, got %v", err)
+	}
+	if ran {
+		t.Error("expected no task to run")
+	}
+}
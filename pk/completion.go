@@ -0,0 +1,194 @@
+package pk
+
+import (
+	"context"
+	"embed"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/fredrikaverpil/pocket/pk/repopath"
+	pkrun "github.com/fredrikaverpil/pocket/pk/run"
+)
+
+// completionScripts holds the shell completion scripts, named pok.<shell>.
+// The scripts run "pok __complete" for candidates, so they follow changes to
+// the Plan without being regenerated.
+//
+//go:embed completions/pok.*
+var completionScripts embed.FS
+
+// completionShells lists the shells supported by the completion builtin.
+var completionShells = []string{"bash", "zsh", "fish", "powershell"}
+
+// completionTask prints a shell completion script.
+var completionTask = &Task{
+	Name:       "completion",
+	Usage:      "print a shell completion script (bash, zsh, fish, powershell)",
+	HideHeader: true,
+	Do: func(ctx context.Context) error {
+		args := taskArgsFromContext(ctx)
+		if len(args) != 1 {
+			return fmt.Errorf("completion requires a shell argument: %s", strings.Join(completionShells, ", "))
+		}
+		shell := args[0]
+		if !slices.Contains(completionShells, shell) {
+			return fmt.Errorf("unsupported shell %q: must be one of %s", shell, strings.Join(completionShells, ", "))
+		}
+		script, err := completionScripts.ReadFile("completions/pok." + shell)
+		if err != nil {
+			return fmt.Errorf("reading %s completion script: %w", shell, err)
+		}
+		pkrun.Printf(ctx, "%s", script)
+		return nil
+	},
+}
+
+// completeCommand is the hidden command the completion scripts run to get
+// candidates, as in "pok __complete go-test -". It is not a builtin task, so
+// its args are passed through verbatim instead of being parsed as task flags.
+const completeCommand = "__complete"
+
+// printCompletions prints the candidates for a partial command line, one per
+// line with a tab-separated description. words are the words after the program
+// name, the last being the word under the cursor.
+func printCompletions(ctx context.Context, p *Plan, words []string) {
+	for _, c := range complete(p, words) {
+		if c.description == "" {
+			pkrun.Printf(ctx, "%s\n", c.value)
+			continue
+		}
+		pkrun.Printf(ctx, "%s\t%s\n", c.value, c.description)
+	}
+}
+
+// completion is a completion candidate.
+type completion struct {
+	value       string
+	description string
+}
+
+// complete returns the candidates for the last of words, following the
+// argument grammar of run and parseInvocations: global flags, then task names,
+// each followed by its flags, with builtins taking positional args.
+func complete(p *Plan, words []string) []completion {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	cur := words[len(words)-1]
+
+	globalFlags := newGlobalFlagSet(&globalOptions{})
+	flags := globalFlags
+	var task *Task       // The task whose arguments are being completed, nil before the first task.
+	var valueFlag string // The flag awaiting a value in the current word, if any.
+	for _, w := range words[:len(words)-1] {
+		switch {
+		case valueFlag != "":
+			valueFlag = ""
+		case w == "--":
+			task, flags = nil, globalFlags
+		case strings.HasPrefix(w, "-"):
+			name, _, hasValue := strings.Cut(strings.TrimLeft(w, "-"), "=")
+			if f := flags.Lookup(name); f != nil && !hasValue && !isBoolFlag(f) {
+				valueFlag = name
+			}
+		case task != nil && isBuiltinName(task.Name):
+			// A positional arg of a builtin.
+		default:
+			instance := findTask(p, w)
+			if instance == nil {
+				return nil
+			}
+			fs, err := buildFlagSetFromStruct(instance.task.Name, instance.task.Flags)
+			if err != nil {
+				return nil
+			}
+			task, flags = instance.task, fs
+		}
+	}
+
+	var candidates []completion
+	switch {
+	case valueFlag != "":
+		if task == nil && valueFlag == "summary" {
+			for _, v := range []string{summaryText, summaryJSON, summaryOff} {
+				candidates = append(candidates, completion{value: v})
+			}
+		}
+		if task == nil && valueFlag == "output" {
+			for _, mode := range outputModes {
+				candidates = append(candidates, completion{value: string(mode)})
+			}
+		}
+	case strings.HasPrefix(cur, "-"):
+		flags.VisitAll(func(f *flag.Flag) {
+			name := "-" + f.Name
+			if task == nil && len(f.Name) > 1 {
+				name = "--" + f.Name
+			}
+			candidates = append(candidates, completion{value: name, description: f.Usage})
+		})
+	case task == watchTask:
+		candidates = taskCompletions(p, false)
+	case task == logsTask:
+		candidates = logCompletions()
+	case task != nil && isBuiltinName(task.Name):
+		if task == completionTask {
+			for _, shell := range completionShells {
+				candidates = append(candidates, completion{value: shell})
+			}
+		}
+	default:
+		candidates = taskCompletions(p, true)
+	}
+
+	var matches []completion
+	for _, c := range candidates {
+		if strings.HasPrefix(c.value, cur) {
+			matches = append(matches, c)
+		}
+	}
+	return matches
+}
+
+// taskCompletions returns the tasks listed in help, optionally including builtins.
+func taskCompletions(p *Plan, withBuiltins bool) []completion {
+	var candidates []completion
+	if withBuiltins {
+		for _, t := range builtins {
+			if !t.Hidden {
+				candidates = append(candidates, completion{value: t.Name, description: t.Usage})
+			}
+		}
+	}
+	for _, instance := range visibleTaskInstances(p) {
+		candidates = append(candidates, completion{value: instance.name, description: instance.task.Usage})
+	}
+	return candidates
+}
+
+// logCompletions returns the task logs of the latest run.
+func logCompletions() []completion {
+	dir := repopath.FromPocketDir(logsDirName)
+	runs, err := listRunLogs(dir)
+	if err != nil || len(runs) == 0 {
+		return nil
+	}
+	labels, err := listTaskLogs(filepath.Join(dir, runs[0]))
+	if err != nil {
+		return nil
+	}
+	candidates := make([]completion, 0, len(labels))
+	for _, label := range labels {
+		candidates = append(candidates, completion{value: label})
+	}
+	return candidates
+}
+
+// isBoolFlag reports whether f is a boolean flag, which takes no separate value.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
@@ -0,0 +1,123 @@
+package pk
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+)
+
+func completionPlan(t *testing.T) *Plan {
+	t.Helper()
+	type testFlags struct {
+		Race    bool   `flag:"race" usage:"enable race detector"`
+		Timeout string `flag:"timeout" usage:"test timeout"`
+	}
+	noop := func(_ context.Context) error { return nil }
+	cfg := &Config{
+		Auto: Serial(
+			&Task{Name: "go-lint", Usage: "lint Go code", Do: noop},
+			&Task{Name: "go-test", Usage: "run Go tests", Flags: testFlags{}, Do: noop},
+			&Task{Name: "internal", Usage: "hidden task", Hidden: true, Do: noop},
+			WithOptions(&Task{Name: "py-test", Usage: "run Python tests", Do: noop}, WithNameSuffix("3.9")),
+			WithOptions(&Task{Name: "api-build", Usage: "build the API", Do: noop}, WithPath("services/api")),
+		),
+	}
+	plan, err := newPlan(cfg, "/tmp", []string{".", "services/api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plan
+}
+
+func completionValues(cs []completion) []string {
+	var values []string
+	for _, c := range cs {
+		values = append(values, c.value)
+	}
+	return values
+}
+
+func TestComplete(t *testing.T) {
+	plan := completionPlan(t)
+
+	tests := []struct {
+		name  string
+		words []string
+		want  []string
+	}{
+		{"TaskNamesByPrefix", []string{"go-"}, []string{"go-lint", "go-test"}},
+		{"SuffixedTask", []string{"py"}, []string{"py-test:3.9"}},
+		{"HiddenTaskOmitted", []string{"int"}, nil},
+		{"Builtins", []string{"wa"}, []string{"watch"}},
+		{"GlobalFlags", []string{"--su"}, []string{"--summary"}},
+		{"ShortGlobalFlag", []string{"-v"}, []string{"-v"}},
+		{"GlobalFlagValue", []string{"--summary", "j"}, []string{"json"}},
+		{"OutputModeValue", []string{"--output", "s"}, []string{"stream"}},
+		{"GlobalFlagInlineValue", []string{"--summary=json", "go-l"}, []string{"go-lint"}},
+		{"TaskFlags", []string{"go-test", "-r"}, []string{"-race"}},
+		{"TaskFlagValue", []string{"go-test", "-timeout", ""}, nil},
+		{"NextTaskAfterFlags", []string{"go-test", "-race", "go-l"}, []string{"go-lint"}},
+		{"NextTaskAfterSeparator", []string{"go-test", "--", "go-l"}, []string{"go-lint"}},
+		{"WatchCompletesUserTasks", []string{"watch", "go-lint", "go-t"}, []string{"go-test"}},
+		{"WatchOmitsBuiltins", []string{"watch", "pl"}, nil},
+		{"CompletionShells", []string{"completion", ""}, completionShells},
+		{"PlanTakesFiles", []string{"plan", ""}, nil},
+		{"UnknownTask", []string{"bogus", ""}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := completionValues(complete(plan, tt.words))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("complete(%q) = %q, want %q", tt.words, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComplete_Descriptions(t *testing.T) {
+	plan := completionPlan(t)
+	got := complete(plan, []string{"go-t"})
+	want := []completion{{value: "go-test", description: "run Go tests"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestComplete_TaskScope(t *testing.T) {
+	plan := completionPlan(t)
+	t.Setenv("TASK_SCOPE", "services/api")
+
+	got := completionValues(complete(plan, []string{"api"}))
+	if want := []string{"api-build"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected scoped task, got %q", got)
+	}
+
+	t.Setenv("TASK_SCOPE", "services/web")
+	if got := completionValues(complete(plan, []string{"api"})); got != nil {
+		t.Errorf("expected task outside TASK_SCOPE to be omitted, got %q", got)
+	}
+}
+
+func TestCompletionTask(t *testing.T) {
+	for _, shell := range completionShells {
+		t.Run(shell, func(t *testing.T) {
+			ctx, out := integrationCtx(t, nil)
+			ctx = context.WithValue(ctx, ctxkey.TaskArgs{}, []string{shell})
+			if err := completionTask.run(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(out.String(), completeCommand) {
+				t.Errorf("expected %s script to call %s, got:\n%s", shell, completeCommand, out.String())
+			}
+		})
+	}
+
+	ctx, _ := integrationCtx(t, nil)
+	ctx = context.WithValue(ctx, ctxkey.TaskArgs{}, []string{"tcsh"})
+	if err := completionTask.run(ctx); err == nil || !strings.Contains(err.Error(), "unsupported shell") {
+		t.Errorf("expected unsupported shell error, got %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+# bash completion for pok.
+#
+# Load it in the current shell with:
+#   source <(./pok completion bash)
+
+_pok() {
+    # Split the line up to the cursor on whitespace only; COMP_WORDS also
+    # splits at ":" and "=", which breaks task names such as py-test:3.9.
+    local line=${COMP_LINE:0:COMP_POINT}
+    local -a words
+    read -ra words <<<"$line"
+    [[ $line =~ [[:space:]]$ ]] && words+=("")
+    local cur=${words[${#words[@]}-1]}
+
+    local IFS=$'\n'
+    local -a candidates
+    candidates=($("${words[0]}" __complete "${words[@]:1}" 2>/dev/null | cut -f1))
+
+    # Bash replaces only the text after the last ":" or "=" in the word.
+    local prefix=${cur%"${cur##*[:=]}"}
+    COMPREPLY=()
+    local c
+    for c in "${candidates[@]}"; do
+        COMPREPLY+=("${c#"$prefix"}")
+    done
+}
+
+complete -o default -F _pok pok
@@ -0,0 +1,11 @@
+# fish completion for pok.
+#
+# Load it in the current shell with:
+#   ./pok completion fish | source
+
+function __pok_complete
+    set -l tokens (commandline -opc) (commandline -ct)
+    $tokens[1] __complete $tokens[2..-1] 2>/dev/null
+end
+
+complete -c pok -f -a '(__pok_complete)'
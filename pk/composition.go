@@ -2,7 +2,10 @@ package pk
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"time"
 
 	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
 	pkrun "github.com/fredrikaverpil/pocket/pk/run"
@@ -20,7 +23,9 @@ type Runnable interface {
 }
 
 // Serial composes multiple runnables to execute sequentially.
-// Execution stops on the first error.
+// Execution stops on the first error, unless keep-going mode is enabled
+// (see [WithContinueOnError]), in which case all runnables execute and
+// their errors are joined.
 func Serial(runnables ...Runnable) Runnable {
 	return &serial{runnables: runnables}
 }
@@ -28,23 +33,90 @@ func Serial(runnables ...Runnable) Runnable {
 // Parallel composes multiple runnables to execute concurrently with buffered output.
 // Each runnable's output is captured and flushed atomically on completion to prevent
 // interleaving. If any runnable fails, the shared context is cancelled and remaining
-// runnables exit early. A single runnable runs without buffering for real-time output.
+// runnables exit early, unless keep-going mode is enabled (see [WithContinueOnError]),
+// in which case all runnables complete and their errors are joined.
+// A single runnable runs without buffering for real-time output.
 func Parallel(runnables ...Runnable) Runnable {
 	return &parallel{runnables: runnables}
 }
 
+// Finally composes body with a cleanup that runs after it, whether body
+// succeeds, fails, or is cancelled, e.g. by Ctrl-C or a failing parallel
+// sibling. Use it to tear down what body sets up, such as a local database or
+// temporary files:
+//
+//	pk.Finally(pk.Serial(StartDB, Test), StopDB)
+//
+// The cleanup runs with a context that is not cancelled along with the run,
+// so its commands are not interrupted, and that instead times out after
+// 5 minutes. Interrupting Pocket a second time, such as pressing Ctrl-C twice,
+// cancels the cleanup as well. The errors of body and cleanup are joined.
+func Finally(body, cleanup Runnable) Runnable {
+	return &finally{body: body, cleanup: cleanup}
+}
+
 // serial is the internal implementation of sequential composition.
 type serial struct {
 	runnables []Runnable
 }
 
 func (s *serial) run(ctx context.Context) error {
-	for _, r := range s.runnables {
+	end := startSpan(ctx, "serial", traceCatSerial)
+	err := runSequentially(ctx, s.runnables)
+	end(err)
+	return err
+}
+
+// runSequentially runs runnables in order, stopping at the first error
+// unless keep-going mode is enabled.
+func runSequentially(ctx context.Context, runnables []Runnable) error {
+	keepGoing := keepGoingFromContext(ctx)
+	var errs []error
+	for _, r := range runnables {
 		if err := r.run(ctx); err != nil {
-			return err
+			if !keepGoing {
+				return err
+			}
+			errs = append(errs, err)
 		}
 	}
-	return nil
+	return errors.Join(errs...)
+}
+
+// cleanupTimeout bounds the cleanup of [Finally], which runs without the
+// run's cancellation until a second interrupt.
+const cleanupTimeout = 5 * time.Minute
+
+// finally is the internal implementation of [Finally].
+type finally struct {
+	body    Runnable
+	cleanup Runnable
+}
+
+func (f *finally) run(ctx context.Context) error {
+	end := startSpan(ctx, "finally", traceCatFinally)
+	bodyErr := f.body.run(ctx)
+	cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cleanupTimeout)
+	defer cancel()
+	// A second interrupt cancels the cleanup too (see withInterrupts).
+	if forceCtx, ok := ctx.Value(ctxkey.ForceInterrupt{}).(context.Context); ok {
+		stop := context.AfterFunc(forceCtx, cancel)
+		defer stop()
+	}
+	cleanupErr := f.cleanup.run(cleanupCtx)
+	switch {
+	case cleanupErr == nil:
+	case errors.Is(cleanupCtx.Err(), context.DeadlineExceeded):
+		cleanupErr = fmt.Errorf("cleanup timed out after %s: %w", cleanupTimeout, cleanupErr)
+	case errors.Is(cleanupCtx.Err(), context.Canceled):
+		cleanupErr = fmt.Errorf("cleanup interrupted: %w", cleanupErr)
+	}
+	err := bodyErr
+	if cleanupErr != nil {
+		err = errors.Join(bodyErr, cleanupErr)
+	}
+	end(err)
+	return err
 }
 
 // parallel is the internal implementation of concurrent composition.
@@ -64,40 +136,102 @@ func (p *parallel) run(ctx context.Context) error {
 	default:
 	}
 
+	end := startSpan(ctx, "parallel", traceCatParallel)
+
 	// Single item or serial mode: run sequentially without buffering.
 	if len(p.runnables) == 1 || serialFromContext(ctx) {
-		for _, r := range p.runnables {
-			if err := r.run(ctx); err != nil {
-				return err
-			}
-		}
-		return nil
+		err := runSequentially(ctx, p.runnables)
+		end(err)
+		return err
 	}
 
-	// Multiple items: use errgroup and buffered output.
+	n := len(p.runnables)
+	err := runConcurrently(ctx, n,
+		func(i int) string { return fmt.Sprintf("parallel branch %d/%d", i+1, n) },
+		func(ctx context.Context, i int) error { return p.runnables[i].run(ctx) },
+	)
+	end(err)
+	return err
+}
+
+// runConcurrently runs n branches concurrently with buffered output. Each
+// branch's output is flushed atomically when it completes. In stream mode (see
+// [WithOutputMode]), branches instead write each line as it is produced,
+// prefixed with the running task and path. If a branch fails, the shared
+// context is cancelled and the first error is returned, unless keep-going mode
+// is enabled, in which case all branches complete and their errors are joined.
+// lane names each branch's lane in the --trace timeline, and prefixes its
+// streamed output outside of a task.
+func runConcurrently(
+	ctx context.Context,
+	n int,
+	lane func(i int) string,
+	branch func(ctx context.Context, i int) error,
+) error {
 	parentOut := pkrun.OutputFromContext(ctx)
 	if parentOut == nil {
 		parentOut = pkrun.StdOutput()
 	}
-	buffers := make([]*bufferedOutput, len(p.runnables))
-	for i := range p.runnables {
-		buffers[i] = newBufferedOutput(parentOut)
+	stream := outputModeFromContext(ctx) == OutputStream
+	var sink *streamSink
+	if stream {
+		// Nested streaming branches share the outermost sink, so their lines
+		// carry a single prefix.
+		if sink = streamSinkFromContext(ctx); sink == nil {
+			sink = newStreamSink(parentOut)
+		}
+	}
+	buffers := make([]*bufferedOutput, n)
+	if !stream {
+		for i := range n {
+			buffers[i] = newBufferedOutput(parentOut)
+		}
 	}
 	var flushMu sync.Mutex
 
+	// In keep-going mode, a failure must not cancel siblings, so branches share
+	// the parent context and every error is collected.
+	keepGoing := keepGoingFromContext(ctx)
+	errs := make([]error, n)
 	g, gCtx := errgroup.WithContext(ctx)
-	for i, r := range p.runnables {
+	if keepGoing {
+		gCtx = ctx
+	}
+	for i := range n {
 		g.Go(func() error {
-			childCtx := context.WithValue(gCtx, ctxkey.Output{}, buffers[i].output())
-			err := r.run(childCtx)
+			childCtx := withTraceLane(gCtx, lane(i))
+			var err error
+			if stream {
+				out, flush := sink.output(lane(i))
+				childCtx = context.WithValue(childCtx, ctxkey.Output{}, out)
+				childCtx = context.WithValue(childCtx, ctxkey.StreamOutput{}, sink)
+				err = branch(childCtx, i)
+				flush()
+			} else {
+				// Tasks in a buffered branch write to its buffer, even when a
+				// nested scope streams.
+				childCtx = context.WithValue(childCtx, ctxkey.Output{}, buffers[i].output())
+				childCtx = context.WithValue(childCtx, ctxkey.StreamOutput{}, nil)
+				var endProgress func()
+				childCtx, endProgress = withProgressBranch(childCtx, lane(i))
+				err = branch(childCtx, i)
+				endProgress()
 
-			// Flush immediately on completion (first-to-complete flushes first).
-			flushMu.Lock()
-			buffers[i].flush()
-			flushMu.Unlock()
+				// Flush immediately on completion (first-to-complete flushes first).
+				flushMu.Lock()
+				buffers[i].flush()
+				flushMu.Unlock()
+			}
 
+			if keepGoing {
+				errs[i] = err
+				return nil
+			}
 			return err
 		})
 	}
-	return g.Wait()
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"os"
 	"strings"
 	"sync/atomic"
 	"testing"
@@ -264,3 +265,161 @@ func testOutput() *pkrun.Output {
 		Stderr: &bytes.Buffer{},
 	}
 }
+
+func TestSerial_KeepGoing_RunsAllAndJoinsErrors(t *testing.T) {
+	var ran []int
+	errA := errors.New("a failed")
+	errB := errors.New("b failed")
+
+	s := Serial(
+		Do(func(_ context.Context) error { ran = append(ran, 1); return errA }),
+		Do(func(_ context.Context) error { ran = append(ran, 2); return nil }),
+		Do(func(_ context.Context) error { ran = append(ran, 3); return errB }),
+	)
+
+	ctx := context.WithValue(context.Background(), ctxkey.KeepGoing{}, true)
+	err := s.run(ctx)
+	if !errors.Is(err, errA) || !errors.Is(err, errB) {
+		t.Errorf("expected joined errA and errB, got %v", err)
+	}
+	if len(ran) != 3 {
+		t.Errorf("expected all 3 to run, got %v", ran)
+	}
+}
+
+func TestParallel_KeepGoing_DoesNotCancelSiblings(t *testing.T) {
+	errBoom := errors.New("boom")
+	var completed atomic.Bool
+
+	p := Parallel(
+		Do(func(_ context.Context) error { return errBoom }),
+		Do(func(ctx context.Context) error {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(20 * time.Millisecond):
+				completed.Store(true)
+				return nil
+			}
+		}),
+	)
+
+	ctx := context.WithValue(context.Background(), ctxkey.Output{}, testOutput())
+	ctx = context.WithValue(ctx, ctxkey.KeepGoing{}, true)
+	err := p.run(ctx)
+	if !errors.Is(err, errBoom) {
+		t.Errorf("expected errBoom, got %v", err)
+	}
+	if !completed.Load() {
+		t.Error("expected sibling to complete without cancellation")
+	}
+}
+
+func TestFinally_RunsCleanupAfterBody(t *testing.T) {
+	errBody := errors.New("body failed")
+	for _, bodyErr := range []error{nil, errBody} {
+		var order []string
+		f := Finally(
+			Do(func(_ context.Context) error { order = append(order, "body"); return bodyErr }),
+			Do(func(_ context.Context) error { order = append(order, "cleanup"); return nil }),
+		)
+		err := f.run(context.Background())
+		if !errors.Is(err, bodyErr) || (bodyErr == nil && err != nil) {
+			t.Errorf("expected %v, got %v", bodyErr, err)
+		}
+		if strings.Join(order, ",") != "body,cleanup" {
+			t.Errorf("expected body then cleanup, got %v", order)
+		}
+	}
+}
+
+func TestFinally_JoinsErrors(t *testing.T) {
+	errBody := errors.New("body failed")
+	errCleanup := errors.New("cleanup failed")
+	f := Finally(
+		Do(func(_ context.Context) error { return errBody }),
+		Do(func(_ context.Context) error { return errCleanup }),
+	)
+	err := f.run(context.Background())
+	if !errors.Is(err, errBody) || !errors.Is(err, errCleanup) {
+		t.Errorf("expected joined body and cleanup errors, got %v", err)
+	}
+}
+
+func TestFinally_CleanupRunsWithoutCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var cleanupErr error
+	var hasDeadline bool
+	f := Finally(
+		Do(func(ctx context.Context) error {
+			cancel() // Simulate Ctrl-C during the body.
+			<-ctx.Done()
+			return ctx.Err()
+		}),
+		Do(func(ctx context.Context) error {
+			cleanupErr = ctx.Err()
+			_, hasDeadline = ctx.Deadline()
+			return nil
+		}),
+	)
+
+	if err := f.run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if cleanupErr != nil {
+		t.Errorf("expected the cleanup context not to be cancelled, got %v", cleanupErr)
+	}
+	if !hasDeadline {
+		t.Error("expected the cleanup context to have a deadline")
+	}
+}
+
+func TestFinally_SecondInterruptCancelsCleanup(t *testing.T) {
+	signals := make(chan os.Signal, 2)
+	ctx, stop := withInterrupts(context.Background(), signals)
+	defer stop()
+	f := Finally(
+		Do(func(ctx context.Context) error {
+			signals <- os.Interrupt // First Ctrl-C cancels the body.
+			<-ctx.Done()
+			return ctx.Err()
+		}),
+		Do(func(ctx context.Context) error {
+			signals <- os.Interrupt // Second Ctrl-C cancels the cleanup.
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(5 * time.Second):
+				return errors.New("cleanup not cancelled")
+			}
+		}),
+	)
+
+	err := f.run(ctx)
+	if err == nil || !strings.Contains(err.Error(), "cleanup interrupted") {
+		t.Errorf("expected an interrupted cleanup, got %v", err)
+	}
+}
+
+func TestFinally_CleansUpWhenParallelSiblingFails(t *testing.T) {
+	errBoom := errors.New("boom")
+	var cleanedUp atomic.Bool
+	p := Parallel(
+		Do(func(_ context.Context) error { return errBoom }),
+		Finally(
+			Do(func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			}),
+			Do(func(_ context.Context) error { cleanedUp.Store(true); return nil }),
+		),
+	)
+
+	ctx := context.WithValue(context.Background(), ctxkey.Output{}, testOutput())
+	if err := p.run(ctx); !errors.Is(err, errBoom) {
+		t.Errorf("expected errBoom, got %v", err)
+	}
+	if !cleanedUp.Load() {
+		t.Error("expected the cleanup of the cancelled branch to run")
+	}
+}
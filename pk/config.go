@@ -71,6 +71,12 @@ type PlanConfig struct {
 	// Example to generate all shims:
 	//   Shims: &pk.ShimConfig{Posix: true, Windows: true, PowerShell: true}
 	Shims *ShimConfig
+
+	// LogRuns is the number of runs whose task logs are kept in .pocket/logs.
+	//
+	// Default (0): keeps the last 10 runs
+	// Negative: task logs are not written
+	LogRuns int
 }
 
 // ShimConfig controls which shim scripts are generated.
@@ -3,6 +3,7 @@ package pk
 import (
 	"context"
 	"os"
+	"time"
 
 	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
 )
@@ -32,6 +33,42 @@ func forceRunFromContext(ctx context.Context) bool {
 	return v
 }
 
+func awaitingDepsFromContext(ctx context.Context) bool {
+	v, _ := ctx.Value(ctxkey.AwaitDeps{}).(bool)
+	return v
+}
+
+func noCacheFromContext(ctx context.Context) bool {
+	v, _ := ctx.Value(ctxkey.NoCache{}).(bool)
+	return v
+}
+
+func keepGoingFromContext(ctx context.Context) bool {
+	v, _ := ctx.Value(ctxkey.KeepGoing{}).(bool)
+	return v
+}
+
+func retryPendingFromContext(ctx context.Context) bool {
+	v, _ := ctx.Value(ctxkey.RetryPending{}).(bool)
+	return v
+}
+
+func captureOutputFromContext(ctx context.Context) bool {
+	v, _ := ctx.Value(ctxkey.CaptureOutput{}).(bool)
+	return v
+}
+
+// skipTasksFromContext returns the names of tasks that must not execute.
+func skipTasksFromContext(ctx context.Context) []string {
+	v, _ := ctx.Value(ctxkey.SkipTasks{}).([]string)
+	return v
+}
+
+func timeoutFromContext(ctx context.Context) time.Duration {
+	v, _ := ctx.Value(ctxkey.Timeout{}).(time.Duration)
+	return v
+}
+
 func serialFromContext(ctx context.Context) bool {
 	v, _ := ctx.Value(ctxkey.Serial{}).(bool)
 	return v
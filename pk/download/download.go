@@ -100,7 +100,7 @@ func download(ctx context.Context, url string, opts ...Opt) error {
 	// Check if we can skip.
 	if cfg.skipIfExists != "" {
 		if _, err := os.Stat(cfg.skipIfExists); err == nil {
-			if cfg.symlink {
+			if cfg.symlink && !run.DryRun(ctx) {
 				if _, err := CreateSymlink(cfg.skipIfExists); err != nil {
 					return err
 				}
@@ -109,6 +109,11 @@ func download(ctx context.Context, url string, opts ...Opt) error {
 		}
 	}
 
+	if run.DryRun(ctx) {
+		run.Printf(ctx, "  [dry-run] download %s\n", url)
+		return nil
+	}
+
 	// Create destination directory.
 	if cfg.destDir != "" {
 		if err := os.MkdirAll(cfg.destDir, 0o755); err != nil {
@@ -0,0 +1,43 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+	"github.com/fredrikaverpil/pocket/pk/run"
+)
+
+func TestDownload_DryRun(t *testing.T) {
+	var out bytes.Buffer
+	ctx := context.WithValue(context.Background(), ctxkey.DryRun{}, true)
+	ctx = context.WithValue(ctx, ctxkey.Output{}, &run.Output{Stdout: &out, Stderr: &out})
+	existing := filepath.Join(t.TempDir(), "tool")
+	if err := os.WriteFile(existing, []byte("binary"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("skipped when already downloaded", func(t *testing.T) {
+		out.Reset()
+		if err := download(ctx, "https://example.com/tool.tar.gz", WithSkipIfExists(existing)); err != nil {
+			t.Fatal(err)
+		}
+		if out.Len() != 0 {
+			t.Errorf("expected no dry-run output for a download a real run would skip, got %q", out.String())
+		}
+	})
+
+	t.Run("printed when missing", func(t *testing.T) {
+		out.Reset()
+		missing := filepath.Join(t.TempDir(), "missing")
+		if err := download(ctx, "https://example.com/tool.tar.gz", WithSkipIfExists(missing)); err != nil {
+			t.Fatal(err)
+		}
+		if want := "  [dry-run] download https://example.com/tool.tar.gz\n"; out.String() != want {
+			t.Errorf("got %q, want %q", out.String(), want)
+		}
+	})
+}
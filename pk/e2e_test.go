@@ -1,7 +1,9 @@
 package pk
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"maps"
 	"os"
@@ -368,6 +370,26 @@ func TestE2E_ExecuteTask_NotFound(t *testing.T) {
 	}
 }
 
+func TestE2E_Execute_NamedTasksKeepGoing(t *testing.T) {
+	e2eSetup(t)
+	errBoom := errors.New("boom")
+	var ranTest bool
+	lint := &Task{Name: "lint", Usage: "lint", Do: func(context.Context) error { return errBoom }}
+	test := &Task{Name: "test", Usage: "test", Do: func(context.Context) error { ranTest = true; return nil }}
+
+	// Named tasks run like ./pok lint test, honoring --keep-going.
+	var out bytes.Buffer
+	ctx := context.WithValue(context.Background(), ctxkey.Output{}, &pkrun.Output{Stdout: &out, Stderr: &out})
+	ctx = context.WithValue(ctx, ctxkey.KeepGoing{}, true)
+	err := Execute(ctx, &Config{Auto: Serial(lint, test)}, "lint", "test")
+	if !errors.Is(err, errBoom) {
+		t.Fatalf("expected errBoom, got %v", err)
+	}
+	if !ranTest {
+		t.Error("expected test to run after lint failed in keep-going mode")
+	}
+}
+
 // --- Auto execution tests ---
 
 func TestE2E_AutoExec_SerialOrder(t *testing.T) {
@@ -0,0 +1,122 @@
+package pk
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+	"github.com/fredrikaverpil/pocket/pk/repopath"
+	pkrun "github.com/fredrikaverpil/pocket/pk/run"
+)
+
+// redactedValue replaces environment values in plan output and JSON exports.
+const redactedValue = "***"
+
+// envSource is an environment variable set with [WithEnv], or an env file
+// read with [WithEnvFile].
+type envSource struct {
+	key, value string // Variable set with WithEnv.
+	file       string // Env file relative to the execution path, set with WithEnvFile.
+}
+
+// String returns the source with its value redacted, "KEY=***", or the name
+// of the env file.
+func (s envSource) String() string {
+	if s.file != "" {
+		return s.file
+	}
+	return s.key + "=" + redactedValue
+}
+
+// contextWithEnvSources sets the variables of sources, in order, for [pkrun.Exec]
+// calls in ctx. Env files are read relative to path, the directory the task
+// executes in, so each path can have its own.
+func contextWithEnvSources(ctx context.Context, path string, sources []envSource) (context.Context, error) {
+	if len(sources) == 0 {
+		return ctx, nil
+	}
+	cfg := pkrun.EnvConfigFromContext(ctx)
+	if cfg.Set == nil {
+		cfg.Set = make(map[string]string)
+	}
+	for _, s := range sources {
+		if s.file == "" {
+			cfg.Set[s.key] = s.value
+			continue
+		}
+		file := s.file
+		if !filepath.IsAbs(file) {
+			file = repopath.FromGitRoot(path, file)
+		}
+		vars, err := readEnvFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("reading env file: %w", err)
+		}
+		for _, kv := range vars {
+			cfg.Set[kv[0]] = kv[1]
+		}
+	}
+	return context.WithValue(ctx, ctxkey.Env{}, cfg), nil
+}
+
+// readEnvFile parses a .env file into key-value pairs, in file order. Each
+// line is KEY=value, optionally preceded by "export". Blank lines and lines
+// starting with # are ignored. Values may be quoted: single quotes keep the
+// value as written, double quotes interpret Go escape sequences such as \n.
+// Unquoted values end at " #", which starts a comment. Variables are not
+// expanded.
+func readEnvFile(file string) ([][2]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var vars [][2]string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("%s:%d: expected KEY=value", file, n)
+		}
+		value, err := parseEnvValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, n, err)
+		}
+		vars = append(vars, [2]string{key, value})
+	}
+	return vars, scanner.Err()
+}
+
+// parseEnvValue returns the value of a trimmed env file value.
+func parseEnvValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "'"):
+		end := strings.Index(value[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated single-quoted value")
+		}
+		return value[1 : end+1], nil
+	case strings.HasPrefix(value, `"`):
+		quoted, err := strconv.QuotedPrefix(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid double-quoted value")
+		}
+		return strconv.Unquote(quoted)
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value, nil
+}
@@ -0,0 +1,220 @@
+package pk
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+	pkrun "github.com/fredrikaverpil/pocket/pk/run"
+)
+
+// envRecorder returns a task recording the environment overrides it sees at
+// each path.
+func envRecorder(name string) (*Task, map[string]map[string]string) {
+	var mu sync.Mutex
+	seen := make(map[string]map[string]string)
+	task := &Task{Name: name, Usage: name, HideHeader: true, Do: func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[pkrun.PathFromContext(ctx)] = pkrun.EnvConfigFromContext(ctx).Set
+		return nil
+	}}
+	return task, seen
+}
+
+func TestReadEnvFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), ".env")
+	content := strings.Join([]string{
+		"# comment",
+		"",
+		"PLAIN=value",
+		"export EXPORTED=yes",
+		"SPACED = padded  ",
+		"SINGLE='keep $HOME \\n'",
+		`DOUBLE="line\nbreak" # comment`,
+		"COMMENTED=value # comment",
+		"EMPTY=",
+		"EQUALS=a=b",
+	}, "\n")
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	vars, err := readEnvFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	for _, kv := range vars {
+		got[kv[0]] = kv[1]
+	}
+	want := map[string]string{
+		"PLAIN":     "value",
+		"EXPORTED":  "yes",
+		"SPACED":    "padded",
+		"SINGLE":    `keep $HOME \n`,
+		"DOUBLE":    "line\nbreak",
+		"COMMENTED": "value",
+		"EMPTY":     "",
+		"EQUALS":    "a=b",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestReadEnvFile_Errors(t *testing.T) {
+	tests := []struct {
+		name, content, want string
+	}{
+		{"MissingEquals", "A=1\nNOVALUE\n", ".env:2: expected KEY=value"},
+		{"SpaceInKey", "MY KEY=1\n", ".env:1: expected KEY=value"},
+		{"UnterminatedQuote", "A='open\n", ".env:1: unterminated single-quoted value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), ".env")
+			if err := os.WriteFile(file, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := readEnvFile(file)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestWithEnv_NestedScopesOverride(t *testing.T) {
+	task, seen := envRecorder("env")
+	cfg := &Config{Auto: WithOptions(
+		WithOptions(task, WithEnv("B=inner", "C=inner")),
+		WithEnv("A=outer", "B=outer"),
+	)}
+	plan, err := newPlan(cfg, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := integrationCtx(t, plan)
+	if err := plan.tree.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"A": "outer", "B": "inner", "C": "inner"}
+	if got := seen["."]; !maps.Equal(got, want) {
+		t.Errorf("got env %v, want %v", got, want)
+	}
+}
+
+func TestWithEnvFile_ResolvedPerPath(t *testing.T) {
+	root := e2eSetup(t)
+	writeFile(t, filepath.Join(root, "api", ".env.test"), "SERVICE=api\nSHARED=file\n")
+	writeFile(t, filepath.Join(root, "web", ".env.test"), "SERVICE=web\nSHARED=file\n")
+
+	task, seen := envRecorder("env")
+	cfg := &Config{Auto: WithOptions(task, WithPath("api", "web"), WithEnvFile(".env.test"), WithEnv("SHARED=option"))}
+	plan, err := newPlan(cfg, root, []string{".", "api", "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := integrationCtx(t, plan)
+	if err := plan.tree.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"api", "web"} {
+		want := map[string]string{"SERVICE": path, "SHARED": "option"}
+		if got := seen[path]; !maps.Equal(got, want) {
+			t.Errorf("%s: got env %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestWithEnvFile_MissingFileFailsTask(t *testing.T) {
+	root := e2eSetup(t)
+	task, seen := envRecorder("env")
+	plan, err := newPlan(&Config{Auto: WithOptions(task, WithEnvFile(".env.test"))}, root, []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := integrationCtx(t, plan)
+	if err := plan.tree.run(ctx); err == nil || !strings.Contains(err.Error(), "reading env file") {
+		t.Fatalf("expected an env file error, got %v", err)
+	}
+	if len(seen) != 0 {
+		t.Errorf("expected the task not to run, got %v", seen)
+	}
+}
+
+func TestWithEnv_DirectExecution(t *testing.T) {
+	root := e2eSetup(t)
+	writeFile(t, filepath.Join(root, "api", ".env"), "FROM_FILE=1\n")
+
+	task, seen := envRecorder("env")
+	cfg := &Config{Auto: WithOptions(task, WithPath("api"), WithEnv("FROM_OPTION=1"), WithEnvFile(".env"))}
+	plan, err := newPlan(cfg, root, []string{".", "api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := integrationCtx(t, plan)
+	if err := plan.taskInstanceByName("env").execute(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"FROM_OPTION": "1", "FROM_FILE": "1"}
+	if got := seen["api"]; !maps.Equal(got, want) {
+		t.Errorf("got env %v, want %v", got, want)
+	}
+}
+
+func TestWithEnv_ConflictingScopes(t *testing.T) {
+	task, _ := envRecorder("env")
+	cfg := &Config{Auto: Serial(
+		WithOptions(task, WithEnv("MODE=a")),
+		WithOptions(task, WithEnv("MODE=b")),
+	)}
+	if _, err := newPlan(cfg, "/tmp", []string{"."}); err == nil ||
+		!strings.Contains(err.Error(), "conflicting environments") {
+		t.Errorf("expected a conflicting environments error, got %v", err)
+	}
+}
+
+func TestWithEnv_InvalidPanics(t *testing.T) {
+	for _, kv := range []string{"NOVALUE", "=value"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected WithEnv(%q) to panic", kv)
+				}
+			}()
+			WithEnv(kv)
+		}()
+	}
+}
+
+func TestPrintTree_EnvRedacted(t *testing.T) {
+	task, _ := envRecorder("env")
+	cfg := &Config{Auto: WithOptions(task, WithEnvFile(".env.test"), WithEnv("API_TOKEN=secret"))}
+	plan, err := newPlan(cfg, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	ctx := context.WithValue(context.Background(), ctxkey.Output{}, &pkrun.Output{Stdout: &buf, Stderr: &buf})
+	printTree(ctx, plan.tree, "", true, "", nil, plan)
+
+	output := buf.String()
+	if !strings.Contains(output, "env: .env.test, API_TOKEN=***") {
+		t.Errorf("expected the environment in the tree, got:\n%s", output)
+	}
+	if strings.Contains(output, "secret") {
+		t.Errorf("expected the value to be redacted, got:\n%s", output)
+	}
+}
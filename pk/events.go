@@ -0,0 +1,146 @@
+package pk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+	pkrun "github.com/fredrikaverpil/pocket/pk/run"
+)
+
+// Event types written to the --events stream.
+const (
+	eventRunStart   = "run_start"
+	eventRunEnd     = "run_end"
+	eventTaskStart  = "task_start"
+	eventTaskFinish = "task_finish"
+	eventTaskSkip   = "task_skip"
+	eventWarning    = "warning"
+)
+
+// Skip reasons for task_skip events.
+const (
+	skipReasonDedup  = "dedup"
+	skipReasonCached = "cached"
+)
+
+// event is one line of the --events stream. Fields that do not apply to an
+// event type are omitted.
+type event struct {
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"`
+	Task       string    `json:"task,omitempty"`        // Effective task name.
+	Path       string    `json:"path,omitempty"`        // Execution path relative to git root.
+	Reason     string    `json:"reason,omitempty"`      // Why a task was skipped.
+	Status     string    `json:"status,omitempty"`      // "ok", "failed", "cancelled", or "warning".
+	DurationMS *int64    `json:"duration_ms,omitempty"` // Set on task_finish and run_end.
+	Error      string    `json:"error,omitempty"`
+	Args       []string  `json:"args,omitempty"`    // Command-line arguments (run_start).
+	Version    string    `json:"version,omitempty"` // Pocket version (run_start).
+}
+
+// eventLog writes execution events as newline-delimited JSON.
+// It is safe for concurrent use.
+type eventLog struct {
+	mu    sync.Mutex
+	w     io.Writer
+	close func() error
+	start time.Time
+}
+
+// openEventLog opens the --events target: "-" for stdout, otherwise a file
+// that is created or truncated.
+func openEventLog(target string) (*eventLog, error) {
+	if target == "-" {
+		return newEventLog(os.Stdout), nil
+	}
+	f, err := os.Create(target)
+	if err != nil {
+		return nil, fmt.Errorf("opening events file: %w", err)
+	}
+	l := newEventLog(f)
+	l.close = f.Close
+	return l, nil
+}
+
+// newEventLog returns an event log writing to w.
+func newEventLog(w io.Writer) *eventLog {
+	return &eventLog{w: w, close: func() error { return nil }, start: time.Now()}
+}
+
+// emit writes e, stamped with the current time. Write errors are ignored so
+// that a broken event consumer never fails the run.
+func (l *eventLog) emit(e event) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e.Time = time.Now().UTC()
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	_, _ = l.w.Write(append(line, '\n'))
+}
+
+// runEnd writes the run_end event summarizing the outcome and closes the log.
+func (l *eventLog) runEnd(tracker *executionTracker, err error) error {
+	e := event{Type: eventRunEnd, Status: "ok", DurationMS: durationMS(time.Since(l.start))}
+	switch {
+	case err != nil:
+		e.Status, e.Error = "failed", pkrun.Redact(err.Error())
+	case tracker != nil && tracker.warnings():
+		e.Status = "warning"
+	}
+	l.emit(e)
+	return l.close()
+}
+
+// taskFinishEvent returns the task_finish event for a task execution that
+// started at start and returned err.
+func taskFinishEvent(ctx context.Context, start time.Time, err error) event {
+	e := event{Type: eventTaskFinish, Status: string(statusForError(ctx, err)), DurationMS: durationMS(time.Since(start))}
+	if err != nil {
+		e.Error = pkrun.Redact(err.Error())
+	}
+	return e
+}
+
+// durationMS returns d in whole milliseconds, for event fields.
+func durationMS(d time.Duration) *int64 {
+	ms := d.Milliseconds()
+	return &ms
+}
+
+// withEventLog returns a context that reports execution events to l.
+func withEventLog(ctx context.Context, l *eventLog) context.Context {
+	return context.WithValue(ctx, ctxkey.Events{}, l)
+}
+
+func eventLogFromContext(ctx context.Context) *eventLog {
+	l, _ := ctx.Value(ctxkey.Events{}).(*eventLog)
+	return l
+}
+
+func taskNameFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(ctxkey.TaskName{}).(string)
+	return name
+}
+
+// emitTaskEvent reports an event for the named task at the current path.
+// It does nothing unless --events is enabled.
+func emitTaskEvent(ctx context.Context, e event, effectiveName string) {
+	l := eventLogFromContext(ctx)
+	if l == nil {
+		return
+	}
+	e.Task = effectiveName
+	e.Path = pkrun.PathFromContext(ctx)
+	l.emit(e)
+}
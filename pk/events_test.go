@@ -0,0 +1,177 @@
+package pk
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+	pkrun "github.com/fredrikaverpil/pocket/pk/run"
+)
+
+// readEvents decodes the JSON lines written to buf.
+func readEvents(t *testing.T, buf *bytes.Buffer) []event {
+	t.Helper()
+	var events []event
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var e event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("invalid event line %q: %v", scanner.Text(), err)
+		}
+		if e.Time.IsZero() {
+			t.Errorf("event without time: %q", scanner.Text())
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestEvents_TaskLifecycle(t *testing.T) {
+	noop := func(_ context.Context) error { return nil }
+	lint := &Task{Name: "lint", Usage: "lint", Do: noop}
+	test := &Task{Name: "test", Usage: "test", Deps: []Runnable{lint}, Do: func(_ context.Context) error {
+		return errors.New("boom")
+	}}
+	plan, err := newPlan(&Config{Auto: Serial(lint, test)}, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	ctx, _ := integrationCtx(t, plan)
+	ctx = withEventLog(ctx, newEventLog(&buf))
+	if err := plan.tree.run(ctx); err == nil {
+		t.Fatal("expected error")
+	}
+
+	events := readEvents(t, &buf)
+	want := []struct{ typ, task, status, reason string }{
+		{eventTaskStart, "lint", "", ""},
+		{eventTaskFinish, "lint", "ok", ""},
+		{eventTaskSkip, "lint", "", skipReasonDedup},
+		{eventTaskStart, "test", "", ""},
+		{eventTaskFinish, "test", "failed", ""},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, w := range want {
+		e := events[i]
+		if e.Type != w.typ || e.Task != w.task || e.Status != w.status || e.Reason != w.reason {
+			t.Errorf("event %d = %+v, want %+v", i, e, w)
+		}
+		if e.Path != "." {
+			t.Errorf("event %d path = %q, want .", i, e.Path)
+		}
+	}
+	if finish := events[4]; finish.DurationMS == nil || finish.Error != "boom" {
+		t.Errorf("expected finish event with duration and error, got %+v", finish)
+	}
+}
+
+func TestEvents_CachedSkip(t *testing.T) {
+	root := e2eSetup(t)
+	writeFile(t, filepath.Join(root, "main.go"), "package main")
+	task := &Task{Name: "lint", Usage: "lint", Inputs: []string{"*.go"}, Do: func(_ context.Context) error { return nil }}
+	plan, err := newPlan(&Config{Auto: task}, root, []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	for range 2 {
+		ctx, _ := integrationCtx(t, plan)
+		ctx = withEventLog(ctx, newEventLog(&buf))
+		if err := task.run(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events := readEvents(t, &buf)
+	last := events[len(events)-1]
+	if last.Type != eventTaskSkip || last.Reason != skipReasonCached {
+		t.Errorf("expected cached skip event, got %+v", last)
+	}
+}
+
+func TestEvents_Warning(t *testing.T) {
+	var buf bytes.Buffer
+	tracker := newExecutionTracker()
+	ctx := withEventLog(context.Background(), newEventLog(&buf))
+	ctx = context.WithValue(ctx, ctxkey.TaskName{}, "go-lint")
+	ctx = pkrun.ContextWithPath(ctx, "services/api")
+
+	tracker.MarkTaskWarning(ctx)
+
+	if !tracker.warnings() {
+		t.Error("expected tracker to record the warning")
+	}
+	events := readEvents(t, &buf)
+	if len(events) != 1 || events[0].Type != eventWarning || events[0].Task != "go-lint" ||
+		events[0].Path != "services/api" {
+		t.Errorf("unexpected events: %+v", events)
+	}
+}
+
+func TestEvents_WarningFromExec(t *testing.T) {
+	e2eSetup(t)
+	var buf bytes.Buffer
+	tracker := newExecutionTracker()
+	ctx := withEventLog(context.Background(), newEventLog(&buf))
+	ctx = withExecutionTracker(ctx, tracker)
+	ctx = context.WithValue(ctx, ctxkey.Output{}, testOutput())
+	ctx = context.WithValue(ctx, ctxkey.TaskName{}, "go-lint")
+
+	if err := pkrun.Exec(ctx, "sh", "-c", "echo 'warning: deprecated'"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !tracker.warnings() {
+		t.Error("expected the notice to be recorded as a warning")
+	}
+	events := readEvents(t, &buf)
+	if len(events) != 1 || events[0].Type != eventWarning || events[0].Task != "go-lint" {
+		t.Errorf("expected a warning event for the task, got %+v", events)
+	}
+}
+
+func TestEvents_RunEnd(t *testing.T) {
+	warned := newExecutionTracker()
+	warned.MarkWarning()
+	tests := []struct {
+		name    string
+		tracker *executionTracker
+		err     error
+		status  string
+	}{
+		{"Success", newExecutionTracker(), nil, "ok"},
+		{"Warning", warned, nil, "warning"},
+		{"Failure", newExecutionTracker(), errors.New("boom"), "failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := newEventLog(&buf).runEnd(tt.tracker, tt.err); err != nil {
+				t.Fatal(err)
+			}
+			events := readEvents(t, &buf)
+			if len(events) != 1 || events[0].Type != eventRunEnd || events[0].Status != tt.status {
+				t.Fatalf("unexpected events: %+v", events)
+			}
+			if events[0].DurationMS == nil {
+				t.Error("expected run_end duration")
+			}
+		})
+	}
+}
+
+func TestEvents_Disabled(t *testing.T) {
+	// Without --events, emitting is a no-op.
+	emitTaskEvent(context.Background(), event{Type: eventTaskStart}, "lint")
+	var l *eventLog
+	l.emit(event{Type: eventRunStart})
+}
@@ -17,5 +17,5 @@ type doRunnable struct {
 }
 
 func (d *doRunnable) run(ctx context.Context) error {
-	return d.fn(ctx)
+	return runLimited(ctx, d.fn)
 }
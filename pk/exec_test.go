@@ -1,12 +1,16 @@
 package pk
 
 import (
+	"bytes"
+	"context"
 	"os"
 	"path/filepath"
 	"runtime"
 	"slices"
+	"strings"
 	"testing"
 
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
 	"github.com/fredrikaverpil/pocket/pk/repopath"
 	pkrun "github.com/fredrikaverpil/pocket/pk/run"
 )
@@ -234,3 +238,77 @@ func pathEnvKeyForTest() string {
 	}
 	return "PATH"
 }
+
+func TestExec_DryRun(t *testing.T) {
+	root := e2eSetup(t)
+	marker := filepath.Join(root, "ran")
+
+	var out bytes.Buffer
+	ctx := context.WithValue(context.Background(), ctxkey.DryRun{}, true)
+	ctx = context.WithValue(ctx, ctxkey.Output{}, &pkrun.Output{Stdout: &out, Stderr: &out})
+	ctx = pkrun.ContextWithPath(ctx, "svc")
+	ctx = pkrun.ContextWithEnv(ctx, "GOFLAGS=-mod=mod -v")
+	ctx = pkrun.ContextWithoutEnv(ctx, "VIRTUAL_ENV")
+
+	if err := pkrun.Exec(ctx, "touch", marker, "it's here"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Fatalf("expected dry run not to execute the command, stat: %v", err)
+	}
+
+	got := out.String()
+	for _, want := range []string{
+		"[dry-run] ",
+		"touch " + marker + ` 'it'\''s here'`,
+		"dir: " + filepath.Join(root, "svc"),
+		"env: GOFLAGS='-mod=mod -v'",
+		"env: unset VIRTUAL_ENV*",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in output, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestTask_Run_DryRunResolvesFlags(t *testing.T) {
+	root := e2eSetup(t)
+	writeFile(t, filepath.Join(root, "main.go"), "package main")
+
+	type lintFlags struct {
+		Fix bool `flag:"fix" usage:"apply fixes"`
+	}
+	lint := &Task{
+		Name:   "lint",
+		Usage:  "lint",
+		Flags:  lintFlags{},
+		Inputs: []string{"*.go"},
+		Do: func(ctx context.Context) error {
+			args := []string{"run"}
+			if pkrun.GetFlags[lintFlags](ctx).Fix {
+				args = append(args, "--fix")
+			}
+			return pkrun.Exec(ctx, "golangci-lint", append(args, "./...")...)
+		},
+	}
+	cfg := &Config{Auto: WithOptions(lint, WithPath("a", "b"), WithFlags(lintFlags{Fix: true}))}
+	plan, err := newPlan(cfg, root, []string{".", "a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		ctx, out := integrationCtx(t, plan)
+		ctx = context.WithValue(ctx, ctxkey.DryRun{}, true)
+		if err := plan.tree.run(ctx); err != nil {
+			t.Fatal(err)
+		}
+		got := out.String()
+		if n := strings.Count(got, "[dry-run] golangci-lint run --fix ./..."); n != 2 {
+			t.Errorf("expected a command per path, got %d in:\n%s", n, got)
+		}
+		if strings.Contains(got, "[cached]") {
+			t.Errorf("expected dry run not to store or use the cache, got:\n%s", got)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"os"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
 	pkrun "github.com/fredrikaverpil/pocket/pk/run"
@@ -23,6 +24,7 @@ const (
 	jsonNodeTypeCommand  = "command"
 	jsonNodeTypeSerial   = "serial"
 	jsonNodeTypeParallel = "parallel"
+	jsonNodeTypeFinally  = "finally"
 )
 
 // jsonRoot is the root document for JSON-driven task execution.
@@ -46,9 +48,58 @@ type jsonNode struct {
 	Name     string      `json:"name,omitempty"`
 	Argv     []string    `json:"argv,omitempty"`
 	Paths    []string    `json:"paths,omitempty"`
+	Retry    *jsonRetry  `json:"retry,omitempty"`
+	Timeout  string      `json:"timeout,omitempty"` // Go duration, e.g. "10m".
+	Locks    []string    `json:"locks,omitempty"`   // Names of locks held while running (see WithLock).
+	Env      []jsonEnv   `json:"env,omitempty"`     // On input, must match the plan's environment of the task.
 	Children []*jsonNode `json:"children,omitempty"`
 }
 
+// jsonEnv is an environment variable, with its value redacted, or an env file
+// of a task node (see [WithEnv] and [WithEnvFile]).
+type jsonEnv struct {
+	Name  string `json:"name,omitempty"`
+	Value string `json:"value,omitempty"`
+	File  string `json:"file,omitempty"`
+}
+
+// jsonEnvFromSources converts environment sources to their JSON form, with
+// values redacted.
+func jsonEnvFromSources(sources []envSource) []jsonEnv {
+	env := make([]jsonEnv, 0, len(sources))
+	for _, s := range sources {
+		if s.file != "" {
+			env = append(env, jsonEnv{File: s.file})
+			continue
+		}
+		env = append(env, jsonEnv{Name: s.key, Value: redactedValue})
+	}
+	return env
+}
+
+// jsonRetry is the retry policy of a task or command node (see [WithRetry]).
+type jsonRetry struct {
+	Attempts int    `json:"attempts"`
+	Backoff  string `json:"backoff,omitempty"` // Go duration, e.g. "2s".
+}
+
+// policy converts r to a retryPolicy. r must have been validated.
+func (r *jsonRetry) policy() *retryPolicy {
+	if r == nil {
+		return nil
+	}
+	backoff, _ := time.ParseDuration(r.Backoff) // Zero when omitted.
+	return &retryPolicy{attempts: r.Attempts, backoff: backoff}
+}
+
+// jsonRetryFromPolicy converts a retry policy to its JSON form.
+func jsonRetryFromPolicy(p *retryPolicy) *jsonRetry {
+	if p == nil {
+		return nil
+	}
+	return &jsonRetry{Attempts: p.attempts, Backoff: p.backoff.String()}
+}
+
 // parseExecJSON reads and validates a JSON execution document from r.
 // Unknown fields and malformed shapes return an error.
 func parseExecJSON(r io.Reader) (*jsonRoot, error) {
@@ -103,15 +154,17 @@ func expectedSchemaType(field string) string {
 		last = field[idx+1:]
 	}
 	switch last {
-	case "version":
+	case "version", "attempts":
 		return "integer"
-	case "tree", "options":
+	case "tree", "options", "retry":
 		return "object"
+	case "env":
+		return "array of objects"
 	case "verbose", "serial", "gitdiff", "commits":
 		return "boolean"
-	case "type", "name":
+	case "type", "name", "backoff", "timeout", "value", "file":
 		return "string"
-	case "argv", "paths":
+	case "argv", "paths", "locks":
 		return "array of strings"
 	case "children":
 		return "array of nodes"
@@ -140,6 +193,18 @@ func validateNode(n *jsonNode, path string) error {
 		if n.Children != nil {
 			return fmt.Errorf("%s.children: not allowed on task nodes", path)
 		}
+		if err := validateRetry(n.Retry, path); err != nil {
+			return err
+		}
+		if err := validateTimeout(n.Timeout, path); err != nil {
+			return err
+		}
+		if err := validateEnv(n.Env, path); err != nil {
+			return err
+		}
+		if err := validateLocks(n.Locks, path); err != nil {
+			return err
+		}
 		return validatePaths(n.Paths, path)
 
 	case jsonNodeTypeCommand:
@@ -152,9 +217,21 @@ func validateNode(n *jsonNode, path string) error {
 		if n.Children != nil {
 			return fmt.Errorf("%s.children: not allowed on command nodes", path)
 		}
+		if n.Env != nil {
+			return fmt.Errorf("%s.env: not allowed on command nodes", path)
+		}
+		if err := validateRetry(n.Retry, path); err != nil {
+			return err
+		}
+		if err := validateTimeout(n.Timeout, path); err != nil {
+			return err
+		}
+		if err := validateLocks(n.Locks, path); err != nil {
+			return err
+		}
 		return validatePaths(n.Paths, path)
 
-	case jsonNodeTypeSerial, jsonNodeTypeParallel:
+	case jsonNodeTypeSerial, jsonNodeTypeParallel, jsonNodeTypeFinally:
 		if n.Name != "" {
 			return fmt.Errorf("%s.name: not allowed on %s nodes", path, n.Type)
 		}
@@ -164,9 +241,25 @@ func validateNode(n *jsonNode, path string) error {
 		if n.Paths != nil {
 			return fmt.Errorf("%s.paths: not allowed on %s nodes", path, n.Type)
 		}
+		if n.Retry != nil {
+			return fmt.Errorf("%s.retry: not allowed on %s nodes", path, n.Type)
+		}
+		if n.Timeout != "" {
+			return fmt.Errorf("%s.timeout: not allowed on %s nodes", path, n.Type)
+		}
+		if n.Env != nil {
+			return fmt.Errorf("%s.env: not allowed on %s nodes", path, n.Type)
+		}
+		if n.Locks != nil {
+			return fmt.Errorf("%s.locks: not allowed on %s nodes", path, n.Type)
+		}
 		if len(n.Children) == 0 {
 			return fmt.Errorf("%s.children: empty array", path)
 		}
+		if n.Type == jsonNodeTypeFinally && len(n.Children) != 2 {
+			return fmt.Errorf("%s.children: finally nodes take a body and a cleanup, got %d children",
+				path, len(n.Children))
+		}
 		for i, child := range n.Children {
 			if err := validateNode(child, fmt.Sprintf("%s.children[%d]", path, i)); err != nil {
 				return err
@@ -176,13 +269,95 @@ func validateNode(n *jsonNode, path string) error {
 
 	default:
 		return fmt.Errorf(
-			"%s.type: unsupported value %q (expected task, command, serial, parallel)",
+			"%s.type: unsupported value %q (expected task, command, serial, parallel, finally)",
 			path,
 			n.Type,
 		)
 	}
 }
 
+// validateRetry validates an optional task or command retry policy.
+func validateRetry(r *jsonRetry, path string) error {
+	if r == nil {
+		return nil
+	}
+	if r.Attempts < 1 {
+		return fmt.Errorf("%s.retry.attempts: must be at least 1, got %d", path, r.Attempts)
+	}
+	if r.Backoff == "" {
+		return nil
+	}
+	backoff, err := time.ParseDuration(r.Backoff)
+	if err != nil {
+		return fmt.Errorf("%s.retry.backoff: invalid duration %q", path, r.Backoff)
+	}
+	if backoff < 0 {
+		return fmt.Errorf("%s.retry.backoff: must not be negative, got %q", path, r.Backoff)
+	}
+	return nil
+}
+
+// validateTimeout validates an optional task or command timeout.
+func validateTimeout(timeout, path string) error {
+	if timeout == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return fmt.Errorf("%s.timeout: invalid duration %q", path, timeout)
+	}
+	if d <= 0 {
+		return fmt.Errorf("%s.timeout: must be positive, got %q", path, timeout)
+	}
+	return nil
+}
+
+// validateLocks validates the optional lock names of a task or command node.
+func validateLocks(locks []string, path string) error {
+	if locks == nil {
+		return nil
+	}
+	if len(locks) == 0 {
+		return fmt.Errorf("%s.locks: empty array", path)
+	}
+	for i, name := range locks {
+		if name == "" {
+			return fmt.Errorf("%s.locks[%d]: empty lock name", path, i)
+		}
+	}
+	return nil
+}
+
+// validateEnv validates the optional environment of a task node. Each entry
+// is either a variable or a file.
+func validateEnv(env []jsonEnv, path string) error {
+	for i, e := range env {
+		if (e.Name == "") == (e.File == "") {
+			return fmt.Errorf("%s.env[%d]: expected either name or file", path, i)
+		}
+		if e.File != "" && e.Value != "" {
+			return fmt.Errorf("%s.env[%d].value: not allowed with file", path, i)
+		}
+	}
+	return nil
+}
+
+// jsonTimeout returns the duration of a validated timeout field, or zero when
+// the field is omitted.
+func jsonTimeout(timeout string) time.Duration {
+	d, _ := time.ParseDuration(timeout)
+	return d
+}
+
+// jsonLocks returns the sorted, unique names of a validated locks field, or
+// nil when the field is omitted.
+func jsonLocks(locks []string) []string {
+	if locks == nil {
+		return nil
+	}
+	return slices.Compact(slices.Sorted(slices.Values(locks)))
+}
+
 // validatePaths validates optional task or command paths.
 func validatePaths(paths []string, path string) error {
 	if paths == nil {
@@ -207,6 +382,9 @@ type taskNodeInfo struct {
 	flags         map[string]any
 	isManual      bool
 	verbose       bool
+	retry         *retryPolicy
+	timeout       time.Duration
+	locks         []string
 }
 
 // jsonTaskRef executes an existing Pocket task reference from JSON.
@@ -214,6 +392,7 @@ type jsonTaskRef struct {
 	task  *Task
 	name  string
 	paths []string
+	env   []envSource
 }
 
 // run implements Runnable for task references in JSON documents.
@@ -222,13 +401,22 @@ func (r *jsonTaskRef) run(ctx context.Context) error {
 	if len(r.name) > len(baseName) && r.name[:len(baseName)] == baseName && r.name[len(baseName)] == ':' {
 		ctx = contextWithNameSuffix(ctx, r.name[len(baseName)+1:])
 	}
+	keepGoing := keepGoingFromContext(ctx)
+	var errs []error
 	for _, path := range r.paths {
-		pathCtx := pkrun.ContextWithPath(ctx, path)
-		if err := r.task.run(pathCtx); err != nil {
-			return fmt.Errorf("task %s in %s: %w", r.name, path, err)
+		pathCtx, err := contextWithEnvSources(pkrun.ContextWithPath(ctx, path), path, r.env)
+		if err == nil {
+			err = r.task.run(pathCtx)
+		}
+		if err != nil {
+			err = fmt.Errorf("task %s in %s: %w", r.name, path, err)
+			if !keepGoing {
+				return err
+			}
+			errs = append(errs, err)
 		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
 // buildRunnable converts a validated jsonNode tree to a Runnable.
@@ -251,7 +439,14 @@ func buildRunnable(n *jsonNode, taskNodes *[]taskNodeInfo, basePlan *Plan) (Runn
 		if err := t.buildFlagSet(); err != nil {
 			return nil, fmt.Errorf("command %q: %w", n.Name, err)
 		}
-		*taskNodes = append(*taskNodes, taskNodeInfo{task: t, name: n.Name, resolvedPaths: paths})
+		*taskNodes = append(*taskNodes, taskNodeInfo{
+			task:          t,
+			name:          n.Name,
+			resolvedPaths: paths,
+			retry:         n.Retry.policy(),
+			timeout:       jsonTimeout(n.Timeout),
+			locks:         jsonLocks(n.Locks),
+		})
 		if len(paths) == 1 && paths[0] == "." {
 			return t, nil
 		}
@@ -265,7 +460,25 @@ func buildRunnable(n *jsonNode, taskNodes *[]taskNodeInfo, basePlan *Plan) (Runn
 		if inst == nil {
 			return nil, fmt.Errorf("task %q: not found in Pocket plan", n.Name)
 		}
+		// Env values are redacted in --json output, so they cannot be applied.
+		// The field is accepted so that output can be fed back unchanged.
+		if n.Env != nil && !slices.Equal(n.Env, jsonEnvFromSources(inst.env)) {
+			return nil, fmt.Errorf("task %q: env differs from the plan; "+
+				"set the environment with WithEnv or WithEnvFile", n.Name)
+		}
 		paths := resolvedJSONPaths(n.Paths, inst.resolvedPaths)
+		retry := inst.retry
+		if n.Retry != nil {
+			retry = n.Retry.policy()
+		}
+		timeout := inst.timeout
+		if n.Timeout != "" {
+			timeout = jsonTimeout(n.Timeout)
+		}
+		locks := inst.locks
+		if n.Locks != nil {
+			locks = jsonLocks(n.Locks)
+		}
 		*taskNodes = append(*taskNodes, taskNodeInfo{
 			task:          inst.task,
 			name:          inst.name,
@@ -273,8 +486,11 @@ func buildRunnable(n *jsonNode, taskNodes *[]taskNodeInfo, basePlan *Plan) (Runn
 			flags:         inst.flags,
 			isManual:      inst.isManual,
 			verbose:       inst.verbose,
+			retry:         retry,
+			timeout:       timeout,
+			locks:         locks,
 		})
-		return &jsonTaskRef{task: inst.task, name: inst.name, paths: paths}, nil
+		return &jsonTaskRef{task: inst.task, name: inst.name, paths: paths, env: inst.env}, nil
 
 	case jsonNodeTypeSerial:
 		children := make([]Runnable, len(n.Children))
@@ -297,6 +513,17 @@ func buildRunnable(n *jsonNode, taskNodes *[]taskNodeInfo, basePlan *Plan) (Runn
 			children[i] = runnable
 		}
 		return Parallel(children...), nil
+
+	case jsonNodeTypeFinally:
+		body, err := buildRunnable(n.Children[0], taskNodes, basePlan)
+		if err != nil {
+			return nil, err
+		}
+		cleanup, err := buildRunnable(n.Children[1], taskNodes, basePlan)
+		if err != nil {
+			return nil, err
+		}
+		return Finally(body, cleanup), nil
 	}
 	return nil, fmt.Errorf("invalid node type %q", n.Type)
 }
@@ -347,6 +574,9 @@ func buildPlanFromJSON(tree Runnable, taskNodes []taskNodeInfo, basePlan *Plan)
 			}
 			pathMappings[info.name] = pathInfo{resolvedPaths: paths, includePaths: paths}
 			taskInstances[idx].resolvedPaths = paths
+			taskInstances[idx].retry = info.retry
+			taskInstances[idx].timeout = info.timeout
+			taskInstances[idx].locks = info.locks
 			seenJSON[info.name] = true
 			continue
 		}
@@ -361,6 +591,9 @@ func buildPlanFromJSON(tree Runnable, taskNodes []taskNodeInfo, basePlan *Plan)
 			flags:         info.flags,
 			isManual:      info.isManual,
 			verbose:       info.verbose,
+			retry:         info.retry,
+			timeout:       info.timeout,
+			locks:         info.locks,
 		})
 	}
 
@@ -407,10 +640,10 @@ func runExecJSON(ctx context.Context, r io.Reader) error {
 // emitJSONError writes a JSON error object to stderr.
 func emitJSONError(ctx context.Context, err error) {
 	w := stderrFromContext(ctx)
-	obj := map[string]string{"error": err.Error()}
+	obj := map[string]string{"error": pkrun.Redact(err.Error())}
 	data, mErr := json.Marshal(obj)
 	if mErr != nil {
-		fmt.Fprintf(w, "{\"error\":%q}\n", err.Error())
+		fmt.Fprintf(w, "{\"error\":%q}\n", pkrun.Redact(err.Error()))
 		return
 	}
 	fmt.Fprintln(w, string(data))
@@ -497,6 +730,18 @@ func emitInvocationJSON(ctx context.Context, p *Plan, taskName string, w io.Writ
 			"name":  inst.name,
 			"paths": paths,
 		}
+		if inst.retry != nil {
+			tree["retry"] = jsonRetryFromPolicy(inst.retry)
+		}
+		if inst.timeout > 0 {
+			tree["timeout"] = inst.timeout.String()
+		}
+		if len(inst.locks) > 0 {
+			tree["locks"] = inst.locks
+		}
+		if len(inst.env) > 0 {
+			tree["env"] = jsonEnvFromSources(inst.env)
+		}
 	}
 	doc := map[string]any{
 		"version": execJSONVersion,
@@ -525,11 +770,26 @@ func emitJSONNode(r Runnable, nameSuffix string, activePaths []string, p *Plan)
 		if info, ok := p.pathMappings[effectiveName]; ok && len(info.resolvedPaths) > 0 {
 			paths = intersectPaths(paths, info.resolvedPaths)
 		}
-		return map[string]any{
+		node := map[string]any{
 			"type":  jsonNodeTypeTask,
 			"name":  effectiveName,
 			"paths": paths,
 		}
+		if inst := p.taskInstanceByName(effectiveName); inst != nil {
+			if inst.retry != nil {
+				node["retry"] = jsonRetryFromPolicy(inst.retry)
+			}
+			if inst.timeout > 0 {
+				node["timeout"] = inst.timeout.String()
+			}
+			if len(inst.locks) > 0 {
+				node["locks"] = inst.locks
+			}
+			if len(inst.env) > 0 {
+				node["env"] = jsonEnvFromSources(inst.env)
+			}
+		}
+		return node
 	case *serial:
 		children := make([]map[string]any, 0, len(v.runnables))
 		for _, child := range v.runnables {
@@ -542,6 +802,12 @@ func emitJSONNode(r Runnable, nameSuffix string, activePaths []string, p *Plan)
 			children = append(children, emitJSONNode(child, nameSuffix, activePaths, p))
 		}
 		return map[string]any{"type": jsonNodeTypeParallel, "children": children}
+	case *finally:
+		children := []map[string]any{
+			emitJSONNode(v.body, nameSuffix, activePaths, p),
+			emitJSONNode(v.cleanup, nameSuffix, activePaths, p),
+		}
+		return map[string]any{"type": jsonNodeTypeFinally, "children": children}
 	case *pathFilter:
 		childSuffix := nameSuffix
 		if v.nameSuffix != "" {
@@ -588,7 +854,7 @@ const execJSONSchema = `{
       "additionalProperties": false,
       "required": ["type"],
       "properties": {
-        "type": {"type": "string", "enum": ["task", "command", "serial", "parallel"]},
+        "type": {"type": "string", "enum": ["task", "command", "serial", "parallel", "finally"]},
         "name": {"type": "string", "minLength": 1},
         "argv": {
           "type": "array",
@@ -600,6 +866,39 @@ const execJSONSchema = `{
           "items": {"type": "string", "minLength": 1},
           "minItems": 1
         },
+        "retry": {
+          "type": "object",
+          "additionalProperties": false,
+          "required": ["attempts"],
+          "properties": {
+            "attempts": {"type": "integer", "minimum": 1},
+            "backoff": {
+              "type": "string",
+              "description": "Go duration such as \"2s\"; doubles after each attempt, up to 5m"
+            }
+          }
+        },
+        "timeout": {"type": "string", "description": "Go duration such as \"10m\", per attempt"},
+        "locks": {
+          "type": "array",
+          "description": "Named locks held while the task runs; tasks sharing a lock never run concurrently",
+          "items": {"type": "string", "minLength": 1},
+          "minItems": 1
+        },
+        "env": {
+          "type": "array",
+          "description": "Environment of a task, values redacted; on input, must match the plan's environment",
+          "items": {
+            "type": "object",
+            "additionalProperties": false,
+            "properties": {
+              "name": {"type": "string", "minLength": 1},
+              "value": {"type": "string"},
+              "file": {"type": "string", "minLength": 1}
+            },
+            "oneOf": [{"required": ["name"]}, {"required": ["file"]}]
+          }
+        },
         "children": {
           "type": "array",
           "items": {"$ref": "#/definitions/node"},
@@ -615,17 +914,41 @@ const execJSONSchema = `{
         {
           "properties": {"type": {"const": "command"}},
           "required": ["type", "name", "argv"],
-          "not": {"required": ["children"]}
+          "not": {"anyOf": [{"required": ["children"]}, {"required": ["env"]}]}
         },
         {
           "properties": {"type": {"const": "serial"}},
           "required": ["type", "children"],
-          "not": {"anyOf": [{"required": ["name"]}, {"required": ["argv"]}, {"required": ["paths"]}]}
+          "not": {"anyOf": [
+            {"required": ["name"]}, {"required": ["argv"]}, {"required": ["paths"]},
+            {"required": ["retry"]}, {"required": ["timeout"]}, {"required": ["env"]},
+            {"required": ["locks"]}
+          ]}
         },
         {
           "properties": {"type": {"const": "parallel"}},
           "required": ["type", "children"],
-          "not": {"anyOf": [{"required": ["name"]}, {"required": ["argv"]}, {"required": ["paths"]}]}
+          "not": {"anyOf": [
+            {"required": ["name"]}, {"required": ["argv"]}, {"required": ["paths"]},
+            {"required": ["retry"]}, {"required": ["timeout"]}, {"required": ["env"]},
+            {"required": ["locks"]}
+          ]}
+        },
+        {
+          "properties": {
+            "type": {"const": "finally"},
+            "children": {
+              "description": "The body, then the cleanup that always runs after it",
+              "minItems": 2,
+              "maxItems": 2
+            }
+          },
+          "required": ["type", "children"],
+          "not": {"anyOf": [
+            {"required": ["name"]}, {"required": ["argv"]}, {"required": ["paths"]},
+            {"required": ["retry"]}, {"required": ["timeout"]}, {"required": ["env"]},
+            {"required": ["locks"]}
+          ]}
         }
       ]
     }
@@ -12,6 +12,7 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
 	pkrun "github.com/fredrikaverpil/pocket/pk/run"
@@ -65,6 +66,21 @@ func TestParseExecJSON_Errors(t *testing.T) {
 			doc:  `{"version":1,"tree":{"type":"command","argv":["x"],"name":"x"},"extra":1}`,
 			want: `unknown field "extra"`,
 		},
+		{
+			name: "finally without cleanup",
+			doc:  `{"version":1,"tree":{"type":"finally","children":[{"type":"command","argv":["x"],"name":"x"}]}}`,
+			want: "tree.children: finally nodes take a body and a cleanup, got 1 children",
+		},
+		{
+			name: "empty lock name",
+			doc:  `{"version":1,"tree":{"type":"command","argv":["x"],"name":"x","locks":[""]}}`,
+			want: "tree.locks[0]: empty lock name",
+		},
+		{
+			name: "locks on serial node",
+			doc:  `{"version":1,"tree":{"type":"serial","locks":["a"],"children":[{"type":"task","name":"x"}]}}`,
+			want: "tree.locks: not allowed on serial nodes",
+		},
 		{
 			name: "unknown nested field",
 			doc:  `{"version":1,"tree":{"type":"command","argv":["x"],"name":"x","bogus":1}}`,
@@ -176,6 +192,48 @@ func TestParseExecJSON_Errors(t *testing.T) {
 			doc:  `{"version":1,"tree":{"type":"serial","children":{}}}`,
 			want: "tree.children: expected array of nodes, got object",
 		},
+		{
+			name: "retry attempts zero",
+			doc:  `{"version":1,"tree":{"type":"command","argv":["x"],"name":"x","retry":{"attempts":0}}}`,
+			want: "retry.attempts: must be at least 1",
+		},
+		{
+			name: "retry invalid backoff",
+			doc:  `{"version":1,"tree":{"type":"command","argv":["x"],"name":"x","retry":{"attempts":2,"backoff":"soon"}}}`,
+			want: "retry.backoff: invalid duration",
+		},
+		{
+			name: "retry on composition",
+			doc: `{"version":1,"tree":{"type":"serial",` +
+				`"children":[{"type":"command","argv":["x"],"name":"x"}],"retry":{"attempts":2}}}`,
+			want: "retry: not allowed on serial nodes",
+		},
+		{
+			name: "timeout invalid",
+			doc:  `{"version":1,"tree":{"type":"command","argv":["x"],"name":"x","timeout":"forever"}}`,
+			want: "timeout: invalid duration",
+		},
+		{
+			name: "timeout not positive",
+			doc:  `{"version":1,"tree":{"type":"task","name":"x","timeout":"0s"}}`,
+			want: "timeout: must be positive",
+		},
+		{
+			name: "timeout on composition",
+			doc: `{"version":1,"tree":{"type":"parallel",` +
+				`"children":[{"type":"command","argv":["x"],"name":"x"}],"timeout":"1m"}}`,
+			want: "timeout: not allowed on parallel nodes",
+		},
+		{
+			name: "env on command",
+			doc:  `{"version":1,"tree":{"type":"command","argv":["x"],"name":"x","env":[{"file":".env"}]}}`,
+			want: "env: not allowed on command nodes",
+		},
+		{
+			name: "env entry without name or file",
+			doc:  `{"version":1,"tree":{"type":"task","name":"x","env":[{"value":"1"}]}}`,
+			want: "env[0]: expected either name or file",
+		},
 		{
 			name: "syntax error",
 			doc:  `{"version":1,"tree":{"type":"command","argv":["x"]`,
@@ -262,6 +320,30 @@ func TestRunExecJSON_SerialOrder(t *testing.T) {
 	}
 }
 
+func TestRunExecJSON_FinallyRunsCleanupAfterFailure(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "out.txt")
+
+	doc := fmt.Sprintf(`{
+		"version": 1,
+		"tree": {
+			"type": "finally",
+			"children": [
+				{"type": "command", "argv": ["sh", "-c", "exit 1"], "name": "body"},
+				{"type": "command", "argv": %s, "name": "cleanup"}
+			]
+		}
+	}`, mustJSON(t, markerScript(t, marker, "cleanup")))
+
+	ctx, stdout, _ := execJSONTestCtx(t)
+	if err := runExecJSON(ctx, strings.NewReader(doc)); err == nil {
+		t.Fatalf("expected the body failure\nstdout: %s", stdout.String())
+	}
+	if got := readMarkers(t, marker); !slices.Equal(got, []string{"cleanup"}) {
+		t.Errorf("markers = %v, want [cleanup]\nstdout: %s", got, stdout.String())
+	}
+}
+
 func TestRunExecJSON_ParallelRunsAll(t *testing.T) {
 	dir := t.TempDir()
 	markerA := filepath.Join(dir, "a.txt")
@@ -451,6 +533,45 @@ func TestBuildPlanFromJSON_TaskReferencePathsOverrideBasePlan(t *testing.T) {
 	}
 }
 
+func TestBuildPlanFromJSON_TaskRetry(t *testing.T) {
+	task := &Task{Name: "lint", Usage: "lint", Do: func(_ context.Context) error { return nil }}
+	basePlan, err := newPlan(&Config{Auto: Serial(task)}, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := `{"version":1,"tree":{"type":"task","name":"lint","retry":{"attempts":3,"backoff":"2s"}}}`
+	root, err := parseExecJSON(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nodes []taskNodeInfo
+	tree, err := buildRunnable(root.Tree, &nodes, basePlan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := buildPlanFromJSON(tree, nodes, basePlan)
+	retry := plan.taskInstanceByName("lint").retry
+	if retry == nil || retry.attempts != 3 || retry.backoff != 2*time.Second {
+		t.Errorf("retry = %v, want 3 attempts with 2s backoff", retry)
+	}
+}
+
+func TestRunExecJSON_CommandTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sleep")
+	}
+	doc := `{"version":1,"tree":{"type":"command","argv":["sleep","30"],"name":"hang","timeout":"100ms"}}`
+	ctx, _, _ := execJSONTestCtx(t)
+	start := time.Now()
+	err := runExecJSON(ctx, strings.NewReader(doc))
+	if err == nil || !strings.Contains(err.Error(), `task "hang" timed out after 100ms`) {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected the command to be interrupted, took %s", elapsed)
+	}
+}
+
 func TestBuildPlanFromJSON_PopulatesPathMappings(t *testing.T) {
 	doc := `{"version":1,"tree":{"type":"serial","children":[
 		{"type":"command","argv":["echo","a"],"name":"a","paths":["x"]},
@@ -510,6 +631,28 @@ func TestEmitInvocationJSON_FullTree(t *testing.T) {
 	}
 }
 
+func TestEmitInvocationJSON_Finally(t *testing.T) {
+	noop := func(_ context.Context) error { return nil }
+	test := &Task{Name: "test", Usage: "test", Do: noop}
+	down := &Task{Name: "db-down", Usage: "db-down", Do: noop}
+	plan, err := newPlan(&Config{Auto: Finally(test, down)}, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := emitInvocationJSON(context.Background(), plan, "", &buf); err != nil {
+		t.Fatal(err)
+	}
+	root, err := parseExecJSON(&buf)
+	if err != nil {
+		t.Fatalf("emitted JSON does not validate: %v", err)
+	}
+	if root.Tree.Type != "finally" || len(root.Tree.Children) != 2 || root.Tree.Children[1].Name != "db-down" {
+		t.Errorf("expected a finally node with the cleanup last, got %+v", root.Tree)
+	}
+}
+
 func TestEmitInvocationJSON_ReusedScopeUsesOccurrencePaths(t *testing.T) {
 	test := &Task{Name: "test", Usage: "test", Do: func(_ context.Context) error { return nil }}
 	install := &Task{Name: "install", Usage: "install", Do: func(_ context.Context) error { return nil }}
@@ -595,6 +738,122 @@ func TestEmitInvocationJSON_SingleTask(t *testing.T) {
 	}
 }
 
+func TestEmitInvocationJSON_IncludesRetry(t *testing.T) {
+	task := &Task{Name: "lint", Usage: "lint", Do: func(_ context.Context) error { return nil }}
+	plan, err := newPlan(&Config{Auto: WithOptions(task, WithRetry(3, time.Second))}, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := emitInvocationJSON(context.Background(), plan, "lint", &buf); err != nil {
+		t.Fatal(err)
+	}
+	root, err := parseExecJSON(&buf)
+	if err != nil {
+		t.Fatalf("emitted document does not parse: %v", err)
+	}
+	if r := root.Tree.Retry; r == nil || r.Attempts != 3 || r.Backoff != "1s" {
+		t.Errorf("retry = %+v, want attempts 3 and backoff 1s", r)
+	}
+}
+
+func TestEmitInvocationJSON_IncludesTimeout(t *testing.T) {
+	task := &Task{Name: "test", Usage: "test", Do: func(_ context.Context) error { return nil }}
+	cfg := &Config{Auto: Serial(WithOptions(task, WithTimeout(10*time.Minute)))}
+	plan, err := newPlan(cfg, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := emitInvocationJSON(context.Background(), plan, "", &buf); err != nil {
+		t.Fatal(err)
+	}
+	root, err := parseExecJSON(&buf)
+	if err != nil {
+		t.Fatalf("emitted document does not parse: %v", err)
+	}
+	if got := root.Tree.Children[0].Timeout; got != "10m0s" {
+		t.Errorf("timeout = %q, want 10m0s", got)
+	}
+}
+
+func TestEmitInvocationJSON_IncludesLocks(t *testing.T) {
+	task := &Task{Name: "test", Usage: "test", Do: func(_ context.Context) error { return nil }}
+	cfg := &Config{Auto: Serial(WithOptions(task, WithLock("port", "coverage")))}
+	plan, err := newPlan(cfg, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := emitInvocationJSON(context.Background(), plan, "", &buf); err != nil {
+		t.Fatal(err)
+	}
+	root, err := parseExecJSON(&buf)
+	if err != nil {
+		t.Fatalf("emitted document does not parse: %v", err)
+	}
+	if got := root.Tree.Children[0].Locks; !slices.Equal(got, []string{"coverage", "port"}) {
+		t.Errorf("locks = %v, want [coverage port]", got)
+	}
+}
+
+func TestBuildPlanFromJSON_CommandLocks(t *testing.T) {
+	root, err := parseExecJSON(strings.NewReader(
+		`{"version":1,"tree":{"type":"command","name":"cov","argv":["true"],"locks":["coverage","coverage"]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var taskNodes []taskNodeInfo
+	tree, err := buildRunnable(root.Tree, &taskNodes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := buildPlanFromJSON(tree, taskNodes, nil)
+	if got := plan.taskInstanceByName("cov").locks; !slices.Equal(got, []string{"coverage"}) {
+		t.Errorf("locks = %v, want [coverage]", got)
+	}
+}
+
+func TestEmitInvocationJSON_IncludesRedactedEnv(t *testing.T) {
+	task := &Task{Name: "test", Usage: "test", Do: func(_ context.Context) error { return nil }}
+	cfg := &Config{Auto: WithOptions(task, WithEnvFile(".env.test"), WithEnv("API_TOKEN=secret"))}
+	plan, err := newPlan(cfg, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := emitInvocationJSON(context.Background(), plan, "test", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "secret") {
+		t.Fatalf("expected the env value to be redacted, got:\n%s", buf.String())
+	}
+	root, err := parseExecJSON(&buf)
+	if err != nil {
+		t.Fatalf("emitted document does not parse: %v", err)
+	}
+	want := []jsonEnv{{File: ".env.test"}, {Name: "API_TOKEN", Value: redactedValue}}
+	if !slices.Equal(root.Tree.Env, want) {
+		t.Errorf("env = %+v, want %+v", root.Tree.Env, want)
+	}
+
+	// The emitted env can be passed back unchanged, but not modified.
+	var nodes []taskNodeInfo
+	if _, err := buildRunnable(root.Tree, &nodes, plan); err != nil {
+		t.Fatalf("expected the emitted env to be accepted, got %v", err)
+	}
+	root.Tree.Env = []jsonEnv{{Name: "API_TOKEN", Value: "other"}}
+	nodes = nil
+	if _, err := buildRunnable(root.Tree, &nodes, plan); err == nil ||
+		!strings.Contains(err.Error(), `task "test": env differs from the plan`) {
+		t.Errorf("expected a changed env to be rejected, got %v", err)
+	}
+}
+
 func TestEmitInvocationJSON_UnknownTask(t *testing.T) {
 	plan, err := newPlan(&Config{Auto: nil}, "/tmp", []string{"."})
 	if err != nil {
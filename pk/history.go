@@ -0,0 +1,344 @@
+package pk
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fredrikaverpil/pocket/pk/repopath"
+	pkrun "github.com/fredrikaverpil/pocket/pk/run"
+)
+
+const (
+	// historyFileName is the file under .pocket where runs are recorded.
+	historyFileName = "history.jsonl"
+	// maxHistoryEntries caps the history file; older runs are dropped.
+	maxHistoryEntries = 100
+)
+
+// historyEntry records one ./pok invocation that ran tasks, as a line of
+// .pocket/history.jsonl.
+type historyEntry struct {
+	Time        time.Time           `json:"time"`
+	DurationMS  int64               `json:"duration_ms"`
+	Args        []string            `json:"args"`   // Command-line arguments, without the program name.
+	Status      string              `json:"status"` // ok, warning, or failed.
+	Error       string              `json:"error,omitempty"`
+	TaskFlags   map[string][]string `json:"task_flags,omitempty"`   // Flags given to each named task.
+	SecretFlags []string            `json:"secret_flags,omitempty"` // Named tasks whose flags held secrets, not recorded.
+	Tasks       []historyRecord     `json:"tasks"`
+}
+
+// historyRecord is the outcome of a task at a path in a recorded run.
+type historyRecord struct {
+	Task       string     `json:"task"`
+	Path       string     `json:"path"`
+	Status     taskStatus `json:"status"`
+	DurationMS int64      `json:"duration_ms"`
+}
+
+// newHistoryEntry returns the entry of a run that started at start, named
+// the given invocations (none for bare ./pok), and ended with err. Secrets are
+// masked in the arguments, and the flags of a task are not recorded when they
+// hold a secret, since a masked value cannot be replayed by rerun.
+func newHistoryEntry(
+	start time.Time,
+	args []string,
+	invocations []taskInvocation,
+	tracker *executionTracker,
+	err error,
+) historyEntry {
+	e := historyEntry{
+		Time:       start.UTC(),
+		DurationMS: time.Since(start).Milliseconds(),
+		Args:       make([]string, 0, len(args)),
+		Status:     "ok",
+		Tasks:      []historyRecord{},
+	}
+	for _, arg := range args {
+		e.Args = append(e.Args, pkrun.Redact(arg))
+	}
+	switch {
+	case err != nil:
+		e.Status, e.Error = "failed", pkrun.Redact(err.Error())
+	case tracker != nil && tracker.warnings():
+		e.Status = "warning"
+	}
+	for _, inv := range invocations {
+		if len(inv.flagArgs) == 0 {
+			continue
+		}
+		if slices.ContainsFunc(inv.flagArgs, func(arg string) bool { return pkrun.Redact(arg) != arg }) {
+			e.SecretFlags = append(e.SecretFlags, inv.instance.name)
+			continue
+		}
+		if e.TaskFlags == nil {
+			e.TaskFlags = make(map[string][]string)
+		}
+		e.TaskFlags[inv.instance.name] = inv.flagArgs
+	}
+	if tracker != nil {
+		for _, r := range summaryRecords(tracker) {
+			e.Tasks = append(e.Tasks, historyRecord{
+				Task:       r.Task,
+				Path:       r.Path,
+				Status:     r.Status,
+				DurationMS: r.Duration.Milliseconds(),
+			})
+		}
+	}
+	return e
+}
+
+// appendHistory adds e to the history file in the .pocket directory, keeping
+// the newest maxHistoryEntries entries. History is best effort: the file is
+// read, trimmed, and replaced, so when two runs finish at the same moment the
+// entry of one of them can be lost.
+func appendHistory(e historyEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	file := repopath.FromPocketDir(historyFileName)
+	data, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	lines := slices.DeleteFunc(bytes.Split(data, []byte("\n")), func(l []byte) bool { return len(l) == 0 })
+	lines = append(lines, line)
+	if len(lines) > maxHistoryEntries {
+		lines = lines[len(lines)-maxHistoryEntries:]
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	// Replace the file in one step, so a concurrent reader never sees it partly written.
+	tmp, err := os.CreateTemp(filepath.Dir(file), historyFileName+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(bytes.Join(lines, []byte("\n")), '\n')); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// readHistory returns the recorded runs, oldest first. Lines that cannot be
+// parsed, such as those written by another version, are skipped.
+func readHistory() ([]historyEntry, error) {
+	f, err := os.Open(repopath.FromPocketDir(historyFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []historyEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		var e historyEntry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// historyFlags defines flags for the history task.
+type historyFlags struct {
+	Limit int `flag:"limit" usage:"number of runs to show"`
+}
+
+// historyTask lists recent runs.
+var historyTask = &Task{
+	Name:       "history",
+	Usage:      "list recent runs with their outcome and failed tasks",
+	HideHeader: true,
+	Flags:      historyFlags{Limit: 10},
+	Do: func(ctx context.Context) error {
+		limit := pkrun.GetFlags[historyFlags](ctx).Limit
+		if limit < 1 {
+			return fmt.Errorf("invalid -limit value %d: must be positive", limit)
+		}
+		entries, err := readHistory()
+		if err != nil {
+			return fmt.Errorf("reading history: %w", err)
+		}
+		if len(entries) == 0 {
+			pkrun.Printf(ctx, "no runs recorded in %s\n", repopath.FromPocketDir(historyFileName))
+			return nil
+		}
+		if len(entries) > limit {
+			entries = entries[len(entries)-limit:]
+		}
+		for _, e := range entries {
+			command := strings.TrimSpace("pok " + strings.Join(e.Args, " "))
+			duration := formatDuration(time.Duration(e.DurationMS) * time.Millisecond)
+			pkrun.Printf(ctx, "%s  %-7s  %8s  %s\n", e.Time.Local().Format(time.DateTime), e.Status, duration, command)
+			for _, t := range e.Tasks {
+				if t.Status == statusFailed {
+					pkrun.Printf(ctx, "    failed: %s\n", formatTaskPath(t.Task, t.Path))
+				}
+			}
+		}
+		return nil
+	},
+}
+
+// rerunFlags defines flags for the rerun task.
+type rerunFlags struct {
+	Failed bool `flag:"failed" usage:"only rerun the task paths that failed or were cancelled"`
+}
+
+// rerunTask runs the task paths of the previous run again. It is handled by
+// the CLI, which turns it into invocations of those tasks; see
+// [rerunInvocations].
+var rerunTask = &Task{
+	Name:       "rerun",
+	Usage:      "rerun the task paths of the last run with its task flags, not its global flags",
+	HideHeader: true,
+	Flags:      rerunFlags{},
+	Do: func(_ context.Context) error {
+		return fmt.Errorf("rerun must be run from the command line, as in 'pok rerun -failed'")
+	},
+}
+
+// rerunInvocations returns the invocations that repeat the task paths of the
+// last recorded run, or only its failed and cancelled ones when failedOnly is
+// set. Each task runs only in those paths, with the flags it was given on the
+// command line. Global flags are not recorded, so they are not repeated. It
+// returns no invocations when there is nothing to rerun.
+func rerunInvocations(ctx context.Context, p *Plan, failedOnly bool) ([]taskInvocation, error) {
+	entries, err := readHistory()
+	if err != nil {
+		return nil, fmt.Errorf("reading history: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no runs recorded in %s", repopath.FromPocketDir(historyFileName))
+	}
+	last := entries[len(entries)-1]
+
+	var names []string
+	paths := make(map[string][]string)
+	for _, t := range last.Tasks {
+		rerun := !t.Status.skipped()
+		if failedOnly {
+			rerun = t.Status == statusFailed || t.Status == statusCancelled
+		}
+		if !rerun || slices.Contains(paths[t.Task], t.Path) {
+			continue
+		}
+		if _, seen := paths[t.Task]; !seen {
+			names = append(names, t.Task)
+		}
+		paths[t.Task] = append(paths[t.Task], t.Path)
+	}
+	if len(names) == 0 {
+		if failedOnly {
+			pkrun.Printf(ctx, ":: rerun: no failed tasks in the last run\n")
+		} else {
+			pkrun.Printf(ctx, ":: rerun: no tasks ran in the last run\n")
+		}
+		return nil, nil
+	}
+
+	var invocations []taskInvocation
+	for _, name := range names {
+		// Dependencies and subtasks that are not in the plan rerun through the
+		// tasks that reached them.
+		planned := findTaskByName(p, name)
+		if planned == nil {
+			pkrun.Printf(ctx, ":: rerun: skipping %s, which cannot be run by name\n", name)
+			continue
+		}
+		if slices.Contains(last.SecretFlags, name) {
+			pkrun.Printf(ctx, ":: rerun: skipping %s, whose flags held secrets and were not recorded\n", name)
+			continue
+		}
+		planPaths := planned.resolvedPaths
+		if len(planPaths) == 0 {
+			planPaths = []string{"."}
+		}
+		instance := *planned
+		instance.resolvedPaths = slices.DeleteFunc(paths[name], func(path string) bool {
+			return !slices.Contains(planPaths, path)
+		})
+		if len(instance.resolvedPaths) == 0 {
+			pkrun.Printf(ctx, ":: rerun: skipping %s, whose paths are no longer in the plan\n", name)
+			continue
+		}
+
+		inv := taskInvocation{instance: &instance}
+		if flagArgs := last.TaskFlags[name]; len(flagArgs) > 0 {
+			fs, err := buildFlagSetFromStruct(instance.task.Name, instance.task.Flags)
+			if err != nil {
+				return nil, err
+			}
+			if err := fs.Parse(flagArgs); err != nil {
+				return nil, fmt.Errorf("parsing recorded flags for task %q: %w", name, err)
+			}
+			inv.flagArgs = flagArgs
+			inv.cliFlags = explicitFlags(fs)
+		}
+		pkrun.Printf(ctx, ":: rerun: %s\n", formatTaskPaths(name, instance.resolvedPaths))
+		invocations = append(invocations, inv)
+	}
+	if len(invocations) == 0 {
+		return nil, fmt.Errorf("none of the tasks to rerun can be run by name")
+	}
+	return invocations, nil
+}
+
+// explicitFlags returns the values of the flags set explicitly in fs.
+func explicitFlags(fs *flag.FlagSet) map[string]any {
+	var flags map[string]any
+	fs.Visit(func(f *flag.Flag) {
+		if getter, ok := f.Value.(flag.Getter); ok {
+			if flags == nil {
+				flags = make(map[string]any)
+			}
+			flags[f.Name] = getter.Get()
+		}
+	})
+	return flags
+}
+
+// formatTaskPath formats a task at a path as in task headers, "name [path]",
+// or "name" at the root.
+func formatTaskPath(name, path string) string {
+	if path == "" || path == "." {
+		return name
+	}
+	return name + " [" + path + "]"
+}
+
+// formatTaskPaths formats a task at several paths, "name [a, b]".
+func formatTaskPaths(name string, paths []string) string {
+	if len(paths) == 1 {
+		return formatTaskPath(name, paths[0])
+	}
+	return name + " [" + strings.Join(paths, ", ") + "]"
+}
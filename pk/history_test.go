@@ -0,0 +1,238 @@
+package pk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+	pkrun "github.com/fredrikaverpil/pocket/pk/run"
+)
+
+func TestAppendHistory_KeepsNewestEntries(t *testing.T) {
+	root := e2eSetup(t)
+	for i := range maxHistoryEntries + 5 {
+		if err := appendHistory(historyEntry{Args: []string{fmt.Sprint(i)}, Status: "ok"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := readHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != maxHistoryEntries {
+		t.Fatalf("expected %d entries, got %d", maxHistoryEntries, len(entries))
+	}
+	if first, last := entries[0].Args[0], entries[len(entries)-1].Args[0]; first != "5" || last != "104" {
+		t.Errorf("expected entries 5 to 104, got %s to %s", first, last)
+	}
+	tmps, err := filepath.Glob(filepath.Join(root, ".pocket", historyFileName+".*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tmps) > 0 {
+		t.Errorf("expected no temporary file left behind, got %v", tmps)
+	}
+}
+
+func TestReadHistory_SkipsInvalidLines(t *testing.T) {
+	root := e2eSetup(t)
+	writeFile(t, filepath.Join(root, ".pocket", historyFileName),
+		`{"args":["lint"],"status":"ok"}`+"\nnot json\n"+`{"args":["test"],"status":"failed"}`+"\n")
+	entries, err := readHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[1].Status != "failed" {
+		t.Errorf("expected the two valid entries, got %+v", entries)
+	}
+}
+
+func TestNewHistoryEntry(t *testing.T) {
+	tracker := newExecutionTracker()
+	tracker.record(taskRecord{Task: "lint", Path: ".", Status: statusOK, Duration: 1500 * time.Millisecond})
+	tracker.record(taskRecord{Task: "test", Path: "api", Status: statusFailed})
+	tracker.record(taskRecord{Task: "plan", Path: ".", Status: statusOK})
+	invocations := []taskInvocation{
+		{instance: &taskInstance{name: "lint"}},
+		{instance: &taskInstance{name: "test"}, flagArgs: []string{"-race"}},
+	}
+
+	e := newHistoryEntry(time.Now(), []string{"lint", "--", "test", "-race"}, invocations, tracker, errors.New("boom"))
+	if e.Status != "failed" || e.Error != "boom" {
+		t.Errorf("expected failed status with error, got %q %q", e.Status, e.Error)
+	}
+	if len(e.TaskFlags) != 1 || !slices.Equal(e.TaskFlags["test"], []string{"-race"}) {
+		t.Errorf("expected flags of test only, got %v", e.TaskFlags)
+	}
+	want := []historyRecord{
+		{Task: "lint", Path: ".", Status: statusOK, DurationMS: 1500},
+		{Task: "test", Path: "api", Status: statusFailed},
+	}
+	if !slices.Equal(e.Tasks, want) {
+		t.Errorf("got tasks %+v, want %+v", e.Tasks, want)
+	}
+}
+
+func TestNewHistoryEntry_MasksSecrets(t *testing.T) {
+	const secret = "history-test-secret"
+	pkrun.RegisterSecret(secret)
+	invocations := []taskInvocation{
+		{instance: &taskInstance{name: "deploy"}, flagArgs: []string{"-token", secret}},
+		{instance: &taskInstance{name: "test"}, flagArgs: []string{"-race"}},
+	}
+
+	e := newHistoryEntry(time.Now(), []string{"deploy", "-token", secret, "--", "test", "-race"}, invocations, nil, nil)
+	if want := []string{"deploy", "-token", "***", "--", "test", "-race"}; !slices.Equal(e.Args, want) {
+		t.Errorf("got args %q, want %q", e.Args, want)
+	}
+	if _, ok := e.TaskFlags["deploy"]; ok || !slices.Equal(e.TaskFlags["test"], []string{"-race"}) {
+		t.Errorf("expected only the flags without secrets recorded, got %v", e.TaskFlags)
+	}
+	if !slices.Equal(e.SecretFlags, []string{"deploy"}) {
+		t.Errorf("expected deploy marked as having secret flags, got %v", e.SecretFlags)
+	}
+}
+
+func TestRerunInvocations_SkipsSecretFlags(t *testing.T) {
+	e2eSetup(t)
+	deploy := &Task{Name: "deploy", Usage: "deploy", Do: func(_ context.Context) error { return nil }}
+	test := &Task{Name: "test", Usage: "test", Do: func(_ context.Context) error { return nil }}
+	plan, err := newPlan(&Config{Auto: Serial(deploy, test)}, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := appendHistory(historyEntry{
+		SecretFlags: []string{"deploy"},
+		Tasks: []historyRecord{
+			{Task: "deploy", Path: ".", Status: statusOK},
+			{Task: "test", Path: ".", Status: statusOK},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, out := integrationCtx(t, plan)
+	invocations, err := rerunInvocations(ctx, plan, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invocations) != 1 || invocations[0].instance.name != "test" {
+		t.Errorf("expected only test to rerun, got %v", invocations)
+	}
+	if !strings.Contains(out.String(), "skipping deploy, whose flags held secrets") {
+		t.Errorf("expected the skip to be reported, got:\n%s", out.String())
+	}
+}
+
+func TestHistoryTask(t *testing.T) {
+	e2eSetup(t)
+	entries := []historyEntry{
+		{Args: []string{"lint"}, Status: "ok", Tasks: []historyRecord{{Task: "lint", Path: ".", Status: statusOK}}},
+		{
+			Args:       []string{"test", "-race"},
+			Status:     "failed",
+			DurationMS: 41200,
+			Tasks: []historyRecord{
+				{Task: "test", Path: "api", Status: statusFailed},
+				{Task: "test", Path: "web", Status: statusOK},
+			},
+		},
+	}
+	for _, e := range entries {
+		if err := appendHistory(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	ctx := context.WithValue(context.Background(), ctxkey.Output{}, &pkrun.Output{Stdout: &out, Stderr: &out})
+	ctx = withCLIFlags(ctx, historyTask.Name, map[string]any{"limit": 1})
+	if err := historyTask.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	if strings.Contains(got, "pok lint") {
+		t.Errorf("expected -limit 1 to show only the last run, got %q", got)
+	}
+	if !strings.Contains(got, "failed      41.2s  pok test -race\n    failed: test [api]\n") {
+		t.Errorf("expected the failed run and its failed task path, got %q", got)
+	}
+}
+
+func TestRun_RerunFailed(t *testing.T) {
+	root := e2eSetup(t)
+	writeFile(t, filepath.Join(root, ".pocket", "go.mod"), "module pocket\n\ngo 1.26\n")
+	for _, dir := range []string{"api", "web"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type testFlags struct {
+		Race bool `flag:"race" usage:"enable the race detector"`
+	}
+	var ran []string
+	failing := map[string]bool{"api": true}
+	test := &Task{Name: "test", Usage: "test", HideHeader: true, Flags: testFlags{}, Do: func(ctx context.Context) error {
+		path := pkrun.PathFromContext(ctx)
+		ran = append(ran, fmt.Sprintf("%s race=%v", path, pkrun.GetFlags[testFlags](ctx).Race))
+		if failing[path] {
+			return errors.New("tests failed")
+		}
+		return nil
+	}}
+	lint := &Task{Name: "lint", Usage: "lint", HideHeader: true, Do: func(_ context.Context) error {
+		ran = append(ran, "lint")
+		return nil
+	}}
+	cfg := &Config{Auto: Serial(lint, WithOptions(test, WithPath("^api$", "^web$")))}
+
+	withArgs(t, "pok", "--summary", "off", "-k", "lint", "--", "test", "-race")
+	if _, err := run(cfg); err == nil {
+		t.Fatal("expected the first run to fail")
+	}
+	if want := []string{"lint", "api race=true", "web race=true"}; !slices.Equal(ran, want) {
+		t.Fatalf("first run: got %v, want %v", ran, want)
+	}
+
+	ran, failing = nil, nil
+	withArgs(t, "pok", "--summary", "off", "rerun", "-failed")
+	if _, err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"api race=true"}; !slices.Equal(ran, want) {
+		t.Errorf("rerun: got %v, want %v", ran, want)
+	}
+
+	// The rerun is recorded, so nothing is left to rerun.
+	ran = nil
+	if _, err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != 0 {
+		t.Errorf("expected nothing to rerun, got %v", ran)
+	}
+	entries, err := readHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantArgs := []string{"--summary", "off", "rerun", "-failed"}
+	if len(entries) != 2 || entries[1].Status != "ok" || !slices.Equal(entries[1].Args, wantArgs) {
+		t.Errorf("expected the failed run and the rerun recorded, got %+v", entries)
+	}
+}
+
+func TestRerunTask_RequiresCLI(t *testing.T) {
+	ctx := context.WithValue(context.Background(), ctxkey.Output{}, testOutput())
+	if err := rerunTask.run(ctx); err == nil {
+		t.Error("expected rerun to fail outside the command line")
+	}
+}
@@ -3,8 +3,11 @@ package pk
 import (
 	"bytes"
 	"context"
+	"errors"
 	"os"
 	"path/filepath"
+	"slices"
+	"sync"
 	"sync/atomic"
 	"testing"
 
@@ -375,3 +378,89 @@ func TestIntegration_FlagOverrideViaWithFlag(t *testing.T) {
 		t.Errorf("expected %q, got %q", "overridden", captured)
 	}
 }
+
+func TestIntegration_KeepGoing_AllPathsAndFailuresRecorded(t *testing.T) {
+	var ran []string
+	var mu sync.Mutex
+	lint := &Task{Name: "lint", Usage: "lint", Do: func(ctx context.Context) error {
+		mu.Lock()
+		ran = append(ran, "lint@"+pkrun.PathFromContext(ctx))
+		mu.Unlock()
+		if pkrun.PathFromContext(ctx) == "a" {
+			return errors.New("lint issues")
+		}
+		return nil
+	}}
+	test := &Task{Name: "test", Usage: "test", Do: func(ctx context.Context) error {
+		mu.Lock()
+		ran = append(ran, "test@"+pkrun.PathFromContext(ctx))
+		mu.Unlock()
+		return errors.New("tests failed")
+	}}
+	outer := &Task{Name: "check", Usage: "check", Body: Serial(test)}
+
+	cfg := &Config{Auto: Serial(
+		WithOptions(lint, WithPath("a", "b"), WithContinueOnError()),
+		outer,
+	)}
+	plan, err := newPlan(cfg, "/tmp", []string{".", "a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The scoped option keeps lint running in b; the global flag (-k) keeps
+	// the top-level Serial running after lint fails.
+	ctx, _ := integrationCtx(t, plan)
+	ctx = context.WithValue(ctx, ctxkey.KeepGoing{}, true)
+	err = plan.tree.run(ctx)
+	if err == nil {
+		t.Fatal("expected joined error")
+	}
+	for _, want := range []string{"lint@a", "lint@b", "test@."} {
+		if !slices.Contains(ran, want) {
+			t.Errorf("expected %s to run, got %v", want, ran)
+		}
+	}
+
+	// Only the innermost failing tasks are recorded, not the composing "check" task.
+	failures := executionTrackerFromContext(ctx).failed()
+	var got []string
+	for _, f := range failures {
+		got = append(got, f.name+"@"+f.path)
+	}
+	if want := []string{"lint@a", "test@."}; !slices.Equal(got, want) {
+		t.Errorf("expected failures %v, got %v", want, got)
+	}
+}
+
+func TestIntegration_FinallyCleansUpAfterFailedTask(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	record := func(name string, err error) *Task {
+		return &Task{Name: name, Usage: name, Do: func(ctx context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, name+"@"+pkrun.PathFromContext(ctx))
+			return err
+		}}
+	}
+	up, test, down := record("db-up", nil), record("test", errors.New("tests failed")), record("db-down", nil)
+
+	cfg := &Config{Auto: WithOptions(Finally(Serial(up, test), down), WithPath("svc"))}
+	plan, err := newPlan(cfg, "/tmp", []string{".", "svc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := integrationCtx(t, plan)
+	if err := plan.tree.run(ctx); err == nil {
+		t.Fatal("expected the test failure")
+	}
+	want := []string{"db-up@svc", "test@svc", "db-down@svc"}
+	if !slices.Equal(order, want) {
+		t.Errorf("expected %v, got %v", want, order)
+	}
+	if got := executionTrackerFromContext(ctx).failed(); len(got) != 1 || got[0].name != "test" {
+		t.Errorf("expected only test to be recorded as failed, got %v", got)
+	}
+}
@@ -18,4 +18,28 @@ type (
 	Plan           struct{} // Execution plan.
 	Tracker        struct{} // Execution tracker.
 	Output         struct{} // Output writers.
+	AwaitDeps      struct{} // Resolving task dependencies.
+	NoCache        struct{} // Bypass the task input cache.
+	Limiter        struct{} // Concurrency limit for leaf functions.
+	KeepGoing      struct{} // Continue executing after errors.
+	RetryPending   struct{} // A failure will be retried by an enclosing task.
+	Timeout        struct{} // Default per-task execution timeout.
+	Affected       struct{} // Task paths affected by changed files (--since).
+	Events         struct{} // Execution event log (--events).
+	TaskName       struct{} // Effective name of the running task.
+	CaptureOutput  struct{} // Capture task output for reports (--junit).
+	Trace          struct{} // Execution trace log (--trace).
+	TraceLane      struct{} // Trace lane of the current parallel branch.
+	DryRun         struct{} // Print commands instead of executing them.
+	ExecHook       struct{} // Runs commands in place of os/exec (pktest).
+	SkipTasks      struct{} // Names of tasks not to execute (pktest).
+	Progress       struct{} // Live progress display for parallel branches.
+	ProgressLine   struct{} // Progress display line of the current parallel branch.
+	LiveOutput     struct{} // Receives command output as it is produced (progress display).
+	OutputMode     struct{} // Output mode of parallel branches (--output).
+	StreamOutput   struct{} // Shared output of streaming parallel branches.
+	RunLogs        struct{} // Log directory of the current run.
+	TaskLog        struct{} // Log file of the running task.
+	Locks          struct{} // Names of the locks held by the running task (WithLock).
+	ForceInterrupt struct{} // Context cancelled by a second interrupt; ends Finally cleanups.
 )
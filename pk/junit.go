@@ -0,0 +1,163 @@
+package pk
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+	pkrun "github.com/fredrikaverpil/pocket/pk/run"
+)
+
+// capturedOutput collects a copy of everything a task writes, for reports.
+// Commands write stdout and stderr concurrently, so writes are serialized.
+type capturedOutput struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (c *capturedOutput) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.Write(p)
+}
+
+func (c *capturedOutput) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.String()
+}
+
+// withCapturedOutput returns a context whose output is also written to the
+// returned capturedOutput. Output keeps flowing to the original writers, so
+// buffering and streaming behave as without capture.
+func withCapturedOutput(ctx context.Context) (context.Context, *capturedOutput) {
+	out := pkrun.OutputFromContext(ctx)
+	if out == nil {
+		out = pkrun.StdOutput()
+	}
+	c := &capturedOutput{}
+	return context.WithValue(ctx, ctxkey.Output{}, &pkrun.Output{
+		Stdout: io.MultiWriter(out.Stdout, c),
+		Stderr: io.MultiWriter(out.Stderr, c),
+	}), c
+}
+
+// JUnit XML elements. Each task instance and path is a testcase, with the
+// path as its classname so CI systems group results by directory.
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut *junitText    `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",cdata"`
+}
+
+type junitText struct {
+	Text string `xml:",cdata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// writeJUnitFile writes the records as a JUnit XML report to name.
+func writeJUnitFile(name string, records []taskRecord, total time.Duration) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("creating JUnit report: %w", err)
+	}
+	if err := writeJUnit(f, records, total); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("writing JUnit report: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("writing JUnit report: %w", err)
+	}
+	return nil
+}
+
+// writeJUnit writes the records as a JUnit XML report. Failed tasks carry
+// their error text and captured output. Tasks that did not execute, including
+// cached and cancelled ones, are reported as skipped with the status as
+// message.
+func writeJUnit(w io.Writer, records []taskRecord, total time.Duration) error {
+	suite := junitTestSuite{Name: "pocket", Tests: len(records), Time: junitSeconds(total)}
+	for _, r := range records {
+		tc := junitTestCase{Name: r.Task, Classname: r.Path, Time: junitSeconds(r.Duration)}
+		switch r.Status {
+		case statusOK:
+		case statusFailed:
+			suite.Failures++
+			text := junitSanitize(r.Error)
+			message, _, _ := strings.Cut(text, "\n")
+			tc.Failure = &junitFailure{Message: message, Text: text}
+			if r.Output != "" {
+				tc.SystemOut = &junitText{Text: junitSanitize(r.Output)}
+			}
+		default:
+			suite.Skipped++
+			tc.Skipped = &junitSkipped{Message: string(r.Status)}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// ansiEscape matches terminal escape sequences such as color codes.
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]`)
+
+// junitSanitize removes color codes and other control characters, which are
+// not allowed in XML, from command output.
+func junitSanitize(s string) string {
+	s = ansiEscape.ReplaceAllString(s, "")
+	return strings.Map(func(r rune) rune {
+		if r < ' ' && r != '\t' && r != '\n' && r != '\r' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// junitSeconds formats d in seconds, as JUnit time attributes expect.
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
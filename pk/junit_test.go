@@ -0,0 +1,149 @@
+package pk
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+	pkrun "github.com/fredrikaverpil/pocket/pk/run"
+)
+
+func TestWriteJUnit(t *testing.T) {
+	records := []taskRecord{
+		{Task: "go-lint", Path: "services/api", Status: statusOK, Duration: 1500 * time.Millisecond},
+		{
+			Task:     "go-test",
+			Path:     "services/api",
+			Status:   statusFailed,
+			Duration: 2 * time.Second,
+			Error:    "go test ./...: exit status 1\n--- FAIL: TestHandler",
+			Output:   ":: go-test services/api\n",
+		},
+		{Task: "go-test", Path: "services/web", Status: statusCached},
+	}
+	var buf bytes.Buffer
+	if err := writeJUnit(&buf, records, 4*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "<?xml") {
+		t.Errorf("expected XML header, got:\n%s", buf.String())
+	}
+
+	var doc junitTestSuites
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid XML: %v\n%s", err, buf.String())
+	}
+	if len(doc.Suites) != 1 {
+		t.Fatalf("expected one testsuite, got %d", len(doc.Suites))
+	}
+	suite := doc.Suites[0]
+	if suite.Tests != 3 || suite.Failures != 1 || suite.Skipped != 1 || suite.Time != "4.000" {
+		t.Errorf("unexpected suite counts: %+v", suite)
+	}
+	if len(suite.Cases) != 3 {
+		t.Fatalf("expected 3 testcases, got %d", len(suite.Cases))
+	}
+
+	passed := suite.Cases[0]
+	if passed.Name != "go-lint" || passed.Classname != "services/api" || passed.Time != "1.500" {
+		t.Errorf("unexpected passing testcase: %+v", passed)
+	}
+	if passed.Failure != nil || passed.Skipped != nil {
+		t.Errorf("expected passing testcase without failure or skip, got %+v", passed)
+	}
+
+	failed := suite.Cases[1]
+	if failed.Failure == nil {
+		t.Fatalf("expected failure, got %+v", failed)
+	}
+	if failed.Failure.Message != "go test ./...: exit status 1" {
+		t.Errorf("expected first error line as message, got %q", failed.Failure.Message)
+	}
+	if !strings.Contains(failed.Failure.Text, "--- FAIL: TestHandler") {
+		t.Errorf("expected full error text, got %q", failed.Failure.Text)
+	}
+	if failed.SystemOut == nil || failed.SystemOut.Text != ":: go-test services/api\n" {
+		t.Errorf("expected captured output, got %+v", failed.SystemOut)
+	}
+
+	skipped := suite.Cases[2]
+	if skipped.Skipped == nil || skipped.Skipped.Message != "cached" {
+		t.Errorf("expected cached skip, got %+v", skipped)
+	}
+}
+
+func TestJUnitSanitize(t *testing.T) {
+	got := junitSanitize("\x1b[31mFAIL\x1b[0m\tpkg\x00\r\n")
+	if want := "FAIL\tpkg\r\n"; got != want {
+		t.Errorf("junitSanitize() = %q, want %q", got, want)
+	}
+}
+
+func TestTask_Run_CapturesFailureOutput(t *testing.T) {
+	ok := &Task{Name: "ok", Usage: "ok", Do: func(ctx context.Context) error {
+		pkrun.Printf(ctx, "all good\n")
+		return nil
+	}}
+	fail := &Task{Name: "fail", Usage: "fail", Do: func(ctx context.Context) error {
+		pkrun.Printf(ctx, "checking...\n")
+		pkrun.Errorf(ctx, "bad thing\n")
+		return errors.New("boom")
+	}}
+	plan, err := newPlan(&Config{Auto: Parallel(ok, fail)}, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, out := integrationCtx(t, plan)
+	ctx = context.WithValue(ctx, ctxkey.CaptureOutput{}, true)
+	if err := plan.tree.run(ctx); err == nil {
+		t.Fatal("expected error")
+	}
+	if !strings.Contains(out.String(), "bad thing") {
+		t.Errorf("expected output to still reach the parent, got %q", out.String())
+	}
+
+	records := executionTrackerFromContext(ctx).taskRecords()
+	byName := make(map[string]taskRecord)
+	for _, r := range records {
+		byName[r.Task] = r
+	}
+	if r := byName["ok"]; r.Output != "" || r.Error != "" {
+		t.Errorf("expected no output kept for passing task, got %+v", r)
+	}
+	r := byName["fail"]
+	if r.Error != "boom" {
+		t.Errorf("expected error text, got %q", r.Error)
+	}
+	for _, want := range []string{":: fail", "checking...", "bad thing"} {
+		if !strings.Contains(r.Output, want) {
+			t.Errorf("expected %q in captured output, got %q", want, r.Output)
+		}
+	}
+}
+
+func TestWriteJUnitFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "junit.xml")
+	if err := writeJUnitFile(name, []taskRecord{{Task: "lint", Path: ".", Status: statusOK}}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `<testcase name="lint" classname="."`) {
+		t.Errorf("expected testcase in report, got:\n%s", data)
+	}
+
+	err = writeJUnitFile(filepath.Join(t.TempDir(), "missing", "junit.xml"), nil, 0)
+	if err == nil || !strings.Contains(err.Error(), "creating JUnit report") {
+		t.Errorf("expected creation error, got %v", err)
+	}
+}
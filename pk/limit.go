@@ -0,0 +1,75 @@
+package pk
+
+import (
+	"context"
+
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+)
+
+// limiter caps how many leaf functions (Task.Do and pk.Do) run at once.
+// Limiters form a chain from the innermost [WithMaxParallel] scope up to the
+// global --jobs limit. Running a leaf takes a slot from every limiter in the
+// chain, so nested Parallels share their ancestors' budget instead of each
+// level getting its own.
+//
+// Only leaves take slots. Parallel branches and composed task bodies do not,
+// which keeps a parent from holding a slot while waiting for its children.
+type limiter struct {
+	slots  chan struct{}
+	parent *limiter
+}
+
+// newLimiter returns a limiter allowing n concurrent leaves within parent's budget.
+func newLimiter(n int, parent *limiter) *limiter {
+	return &limiter{slots: make(chan struct{}, n), parent: parent}
+}
+
+// acquire takes a slot from l and each of its ancestors, innermost first.
+// Acquiring in a consistent order prevents deadlock between sibling scopes.
+// On cancellation, any slots already taken are released.
+func (l *limiter) acquire(ctx context.Context) (release func(), err error) {
+	var held []*limiter
+	release = func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			<-held[i].slots
+		}
+	}
+	for cur := l; cur != nil; cur = cur.parent {
+		select {
+		case cur.slots <- struct{}{}:
+			held = append(held, cur)
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
+
+// withMaxParallel returns a context whose leaves run at most n at a time,
+// within any limit already present in ctx.
+func withMaxParallel(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, ctxkey.Limiter{}, newLimiter(n, limiterFromContext(ctx)))
+}
+
+func limiterFromContext(ctx context.Context) *limiter {
+	l, _ := ctx.Value(ctxkey.Limiter{}).(*limiter)
+	return l
+}
+
+// runLimited runs fn once a slot is available in every limiter in ctx.
+// While fn runs, the context carries no limiter, so runnables that fn starts
+// itself (e.g., a builtin running another task) use the slot already held
+// instead of waiting for a second one.
+func runLimited(ctx context.Context, fn func(context.Context) error) error {
+	l := limiterFromContext(ctx)
+	if l == nil {
+		return fn(ctx)
+	}
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return fn(context.WithValue(ctx, ctxkey.Limiter{}, (*limiter)(nil)))
+}
@@ -0,0 +1,147 @@
+package pk
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+)
+
+// concurrencyProbe records the peak number of leaves running at once.
+type concurrencyProbe struct {
+	running atomic.Int32
+	peak    atomic.Int32
+	total   atomic.Int32
+}
+
+func (p *concurrencyProbe) leaf() Runnable {
+	return Do(p.do)
+}
+
+func (p *concurrencyProbe) task(name string) *Task {
+	return &Task{Name: name, Usage: name, HideHeader: true, Do: p.do}
+}
+
+func (p *concurrencyProbe) do(_ context.Context) error {
+	n := p.running.Add(1)
+	for {
+		peak := p.peak.Load()
+		if n <= peak || p.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	p.running.Add(-1)
+	p.total.Add(1)
+	return nil
+}
+
+func TestMaxParallel_SharedAcrossNestedParallels(t *testing.T) {
+	probe := &concurrencyProbe{}
+	tree := Parallel(
+		Parallel(probe.leaf(), probe.leaf(), probe.leaf()),
+		Parallel(probe.leaf(), probe.leaf(), probe.leaf()),
+		probe.leaf(),
+	)
+
+	ctx := context.WithValue(context.Background(), ctxkey.Output{}, testOutput())
+	ctx = withMaxParallel(ctx, 2)
+	if err := tree.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := probe.total.Load(); got != 7 {
+		t.Errorf("expected 7 leaves to run, got %d", got)
+	}
+	if got := probe.peak.Load(); got > 2 {
+		t.Errorf("expected at most 2 concurrent leaves, got %d", got)
+	}
+}
+
+func TestMaxParallel_NestedScopeWithinGlobalLimit(t *testing.T) {
+	inner := &concurrencyProbe{}
+	all := &concurrencyProbe{}
+	tree := Parallel(
+		WithOptions(
+			Parallel(inner.task("i1"), inner.task("i2"), inner.task("i3"), inner.task("i4")),
+			WithMaxParallel(1),
+		),
+		all.task("a1"), all.task("a2"),
+	)
+
+	plan, err := newPlan(&Config{Auto: tree}, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.WithValue(context.Background(), ctxkey.Output{}, testOutput())
+	ctx = withMaxParallel(ctx, 3)
+	if err := plan.tree.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := inner.peak.Load(); got != 1 {
+		t.Errorf("expected inner scope to run one leaf at a time, peak was %d", got)
+	}
+	if got := inner.total.Load() + all.total.Load(); got != 6 {
+		t.Errorf("expected 6 leaves to run, got %d", got)
+	}
+}
+
+func TestMaxParallel_NestedRunHoldsSingleSlot(t *testing.T) {
+	// A leaf that runs another runnable must not wait for a second slot,
+	// which would deadlock with a limit of 1.
+	var ran bool
+	inner := &Task{Name: "inner", Do: func(_ context.Context) error { ran = true; return nil }}
+	outer := &Task{Name: "outer", Do: func(ctx context.Context) error { return inner.run(ctx) }}
+
+	ctx := context.WithValue(context.Background(), ctxkey.Output{}, testOutput())
+	ctx = withMaxParallel(ctx, 1)
+
+	done := make(chan error, 1)
+	go func() { done <- outer.run(ctx) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nested run deadlocked waiting for a slot")
+	}
+	if !ran {
+		t.Error("expected inner task to run")
+	}
+}
+
+func TestMaxParallel_CancelWhileWaiting(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = withMaxParallel(ctx, 1)
+
+	l := limiterFromContext(ctx)
+	release, err := l.acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	cancel()
+	err = Do(func(_ context.Context) error {
+		t.Error("should not run without a slot")
+		return nil
+	}).run(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestWithMaxParallel_InvalidPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for WithMaxParallel(0)")
+		}
+	}()
+	WithMaxParallel(0)
+}
@@ -0,0 +1,111 @@
+package pk
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+)
+
+// taskLocks holds the named locks of [WithLock]. They are shared by all runs
+// in the process, so concurrent JSON executions and watch runs respect them
+// too.
+var taskLocks = &lockRegistry{locks: make(map[string]chan struct{})}
+
+// lockRegistry maps lock names to their locks. A lock is a channel with a
+// single slot, so waiting for it can be cancelled.
+type lockRegistry struct {
+	mu    sync.Mutex
+	locks map[string]chan struct{}
+}
+
+// lock returns the lock with the given name, creating it on first use.
+func (r *lockRegistry) lock(name string) chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l, ok := r.locks[name]
+	if !ok {
+		l = make(chan struct{}, 1)
+		r.locks[name] = l
+	}
+	return l
+}
+
+// lockHold is the set of locks taken by a task execution.
+type lockHold struct {
+	taken     []chan struct{}
+	waited    time.Duration // Time spent waiting for busy locks.
+	contended []string      // Names of the locks that were busy.
+}
+
+// release releases the locks in the reverse order they were taken.
+func (h *lockHold) release() {
+	for i := len(h.taken) - 1; i >= 0; i-- {
+		<-h.taken[i]
+	}
+	h.taken = nil
+}
+
+// status returns the wait for busy locks, for the task header, or "" if the
+// locks were free.
+func (h *lockHold) status() string {
+	switch len(h.contended) {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf(" (waited %s for lock %s)", formatDuration(h.waited), h.contended[0])
+	}
+	return fmt.Sprintf(" (waited %s for locks %s)", formatDuration(h.waited), strings.Join(h.contended, ", "))
+}
+
+// acquireLocks takes the named locks for a task and returns a context marking
+// them as held, along with the hold to release when the task is done.
+//
+// Locks are taken in sorted order, which prevents deadlock between tasks that
+// hold several. Locks already held by an enclosing task are not taken again,
+// so a task composed by the holder runs under its lock. On cancellation, any
+// locks already taken are released.
+func acquireLocks(ctx context.Context, names []string) (context.Context, *lockHold, error) {
+	hold := &lockHold{}
+	held := heldLocksFromContext(ctx)
+	for _, name := range slices.Sorted(slices.Values(names)) {
+		if slices.Contains(held, name) {
+			continue
+		}
+		l := taskLocks.lock(name)
+		select {
+		case l <- struct{}{}:
+		default:
+			// The lock is busy: wait for it, showing the wait in the trace.
+			start := time.Now()
+			end := startSpan(ctx, "lock "+name, traceCatLock)
+			select {
+			case l <- struct{}{}:
+				end(nil)
+			case <-ctx.Done():
+				end(ctx.Err())
+				hold.release()
+				return nil, nil, ctx.Err()
+			}
+			hold.waited += time.Since(start)
+			hold.contended = append(hold.contended, name)
+		}
+		hold.taken = append(hold.taken, l)
+		held = append(slices.Clip(held), name)
+	}
+	if len(hold.taken) > 0 {
+		ctx = context.WithValue(ctx, ctxkey.Locks{}, held)
+	}
+	return ctx, hold, nil
+}
+
+// heldLocksFromContext returns the names of the locks held by the running
+// task and the tasks enclosing it.
+func heldLocksFromContext(ctx context.Context) []string {
+	v, _ := ctx.Value(ctxkey.Locks{}).([]string)
+	return v
+}
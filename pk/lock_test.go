@@ -0,0 +1,202 @@
+package pk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+	pkrun "github.com/fredrikaverpil/pocket/pk/run"
+)
+
+func TestWithLock_SerializesParallelTasks(t *testing.T) {
+	probe := &concurrencyProbe{}
+	a, b, c := probe.task("a"), probe.task("b"), probe.task("c")
+	a.HideHeader, b.HideHeader, c.HideHeader = false, false, false
+	cfg := &Config{Auto: WithOptions(Parallel(a, b, c), WithLock("shared"))}
+	plan, err := newPlan(cfg, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, out := integrationCtx(t, plan)
+	if err := plan.tree.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := probe.peak.Load(); got != 1 {
+		t.Errorf("expected tasks holding the lock to run one at a time, got %d at once", got)
+	}
+	if !strings.Contains(out.String(), "for lock shared)") {
+		t.Errorf("expected headers showing the wait for the lock, got:\n%s", out.String())
+	}
+}
+
+func TestWithLock_SerializesParallelPaths(t *testing.T) {
+	probe := &concurrencyProbe{}
+	task := probe.task("probe")
+	cfg := &Config{Auto: WithOptions(task, WithPath("a", "b", "c"), WithParallelPaths(), WithLock("shared"))}
+	plan, err := newPlan(cfg, "/tmp", []string{".", "a", "b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := integrationCtx(t, plan)
+	if err := plan.tree.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := probe.peak.Load(); got != 1 {
+		t.Errorf("expected paths holding the lock to run one at a time, got %d at once", got)
+	}
+}
+
+func TestWithLock_DifferentLocksRunConcurrently(t *testing.T) {
+	started := make(chan struct{}, 2)
+	meet := func(name string) *Task {
+		return &Task{Name: name, Usage: name, Do: func(_ context.Context) error {
+			started <- struct{}{}
+			// Wait until the other task has started as well.
+			deadline := time.After(time.Second)
+			for len(started) < 2 {
+				select {
+				case <-deadline:
+					return errors.New("tasks did not run concurrently")
+				case <-time.After(time.Millisecond):
+				}
+			}
+			return nil
+		}}
+	}
+	cfg := &Config{Auto: Parallel(
+		WithOptions(meet("a"), WithLock("one")),
+		WithOptions(meet("b"), WithLock("two")),
+	)}
+	plan, err := newPlan(cfg, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := integrationCtx(t, plan)
+	if err := plan.tree.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcquireLocks_HeldByEnclosingTask(t *testing.T) {
+	ctx, outer, err := acquireLocks(context.Background(), []string{"test-reentrant"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outer.release()
+
+	// A composed task taking the same lock must not wait for its parent.
+	done := make(chan error, 1)
+	go func() {
+		_, inner, err := acquireLocks(ctx, []string{"test-reentrant"})
+		if err == nil {
+			inner.release()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a lock held by the enclosing task not to be taken again")
+	}
+}
+
+func TestAcquireLocks_CancelledWhileWaiting(t *testing.T) {
+	_, holder, err := acquireLocks(context.Background(), []string{"test-cancel-a", "test-cancel-b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, _, err := acquireLocks(ctx, []string{"test-cancel-b"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the wait to be cancelled, got %v", err)
+	}
+
+	holder.release()
+	_, hold, err := acquireLocks(context.Background(), []string{"test-cancel-a", "test-cancel-b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hold.status() != "" {
+		t.Errorf("expected released locks to be free, got status %q", hold.status())
+	}
+	hold.release()
+}
+
+func TestLockHold_Status(t *testing.T) {
+	tests := []struct {
+		hold lockHold
+		want string
+	}{
+		{lockHold{}, ""},
+		{lockHold{waited: 1500 * time.Millisecond, contended: []string{"db"}}, " (waited 1.5s for lock db)"},
+		{lockHold{waited: 2 * time.Second, contended: []string{"db", "port"}}, " (waited 2s for locks db, port)"},
+	}
+	for _, tt := range tests {
+		if got := tt.hold.status(); got != tt.want {
+			t.Errorf("status() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestWithLock_NestedScopesCombine(t *testing.T) {
+	task := &Task{Name: "probe", Usage: "probe", Do: func(_ context.Context) error { return nil }}
+	cfg := &Config{Auto: WithOptions(
+		WithOptions(task, WithLock("port", "coverage")),
+		WithLock("coverage"),
+	)}
+	plan, err := newPlan(cfg, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(plan.taskInstanceByName("probe").locks, ","); got != "coverage,port" {
+		t.Errorf("expected sorted unique locks, got %q", got)
+	}
+}
+
+func TestWithLock_ConflictingScopes(t *testing.T) {
+	task := &Task{Name: "probe", Usage: "probe", Do: func(_ context.Context) error { return nil }}
+	cfg := &Config{Auto: Serial(
+		WithOptions(task, WithLock("a")),
+		WithOptions(task, WithLock("b")),
+	)}
+	if _, err := newPlan(cfg, "/tmp", []string{"."}); err == nil ||
+		!strings.Contains(err.Error(), "conflicting locks") {
+		t.Errorf("expected a conflicting locks error, got %v", err)
+	}
+}
+
+func TestWithLock_EmptyNamePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected WithLock(\"\") to panic")
+		}
+	}()
+	WithLock("")
+}
+
+func TestPrintTree_Locks(t *testing.T) {
+	task := &Task{Name: "probe", Usage: "probe", Do: func(_ context.Context) error { return nil }}
+	plan, err := newPlan(&Config{Auto: WithOptions(task, WithLock("port", "coverage"))}, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	ctx := context.WithValue(context.Background(), ctxkey.Output{}, &pkrun.Output{Stdout: &buf, Stderr: &buf})
+	printTree(ctx, plan.tree, "", true, "", nil, plan)
+
+	if output := buf.String(); !strings.Contains(output, "locks: coverage, port") {
+		t.Errorf("expected the locks in the tree, got:\n%s", output)
+	}
+}
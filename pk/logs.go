@@ -0,0 +1,342 @@
+package pk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+	"github.com/fredrikaverpil/pocket/pk/repopath"
+	pkrun "github.com/fredrikaverpil/pocket/pk/run"
+)
+
+const (
+	// logsDirName is the directory under .pocket where task logs are stored.
+	logsDirName = "logs"
+	// defaultLogRuns is how many runs keep their logs unless PlanConfig.LogRuns is set.
+	defaultLogRuns = 10
+	// runIDFormat is the time part of a run ID, which names each run's log
+	// directory. It sorts chronologically and is a valid file name on all
+	// platforms.
+	runIDFormat = "2006-01-02T15-04-05.000"
+)
+
+// newRunID returns the ID of a run started at now: the time followed by the
+// process ID, so runs started in the same millisecond get their own logs.
+func newRunID(now time.Time) string {
+	return now.UTC().Format(runIDFormat) + "-" + strconv.Itoa(os.Getpid())
+}
+
+// isRunID reports whether name is a run ID. IDs without the process ID, as
+// written by earlier versions, are recognized too.
+func isRunID(name string) bool {
+	if len(name) < len(runIDFormat) {
+		return false
+	}
+	if _, err := time.Parse(runIDFormat, name[:len(runIDFormat)]); err != nil {
+		return false
+	}
+	suffix := name[len(runIDFormat):]
+	if suffix == "" {
+		return true
+	}
+	pid, ok := strings.CutPrefix(suffix, "-")
+	_, err := strconv.ParseUint(pid, 10, 64)
+	return ok && err == nil
+}
+
+// runLogs writes the log files of one run to .pocket/logs/<run-id>. Each task
+// instance that executes gets a file with its full output, including command
+// output that is otherwise only shown on failure or notice.
+type runLogs struct {
+	dir  string // Directory of this run's logs.
+	keep int    // Number of runs whose logs are kept.
+}
+
+// withRunLogs starts a run whose task logs are written below the .pocket
+// directory, unless the plan disables logs. The returned function removes the
+// logs of older runs beyond the plan's limit; call it when the run ends.
+func withRunLogs(ctx context.Context, p *Plan) (context.Context, func()) {
+	keep := defaultLogRuns
+	if p != nil && p.logRuns != 0 {
+		keep = p.logRuns
+	}
+	if keep < 0 {
+		return ctx, func() {}
+	}
+	l := &runLogs{
+		dir:  repopath.FromPocketDir(logsDirName, newRunID(time.Now())),
+		keep: keep,
+	}
+	ctx = context.WithValue(ctx, ctxkey.RunLogs{}, l)
+	return ctx, func() {
+		if err := pruneRunLogs(filepath.Dir(l.dir), l.keep); err != nil {
+			pkrun.Errorf(ctx, "pocket: removing old logs: %v\n", err)
+		}
+	}
+}
+
+func runLogsFromContext(ctx context.Context) *runLogs {
+	l, _ := ctx.Value(ctxkey.RunLogs{}).(*runLogs)
+	return l
+}
+
+// withTaskLog writes the output of the task effectiveName at the current path
+// to its log file, if ctx belongs to a run with logs. Output keeps flowing to
+// the original writers, and a log that cannot be written does not fail the
+// task. The returned function closes the file and reports such errors.
+func withTaskLog(ctx context.Context, effectiveName string) (context.Context, func()) {
+	l := runLogsFromContext(ctx)
+	if l == nil {
+		return ctx, func() {}
+	}
+	w := &taskLogWriter{path: filepath.Join(l.dir, logFileName(effectiveName, pkrun.PathFromContext(ctx)))}
+
+	out := pkrun.OutputFromContext(ctx)
+	if out == nil {
+		out = pkrun.StdOutput()
+	}
+	ctx = context.WithValue(ctx, ctxkey.Output{}, &pkrun.Output{
+		Stdout: io.MultiWriter(out.Stdout, w),
+		Stderr: io.MultiWriter(out.Stderr, w),
+	})
+	// Command output that is not printed goes to this log directly, and to the
+	// logs of enclosing tasks, which receive everything else through Output.
+	var log io.Writer = w
+	if parent, ok := ctx.Value(ctxkey.TaskLog{}).(io.Writer); ok {
+		log = io.MultiWriter(w, parent)
+	}
+	ctx = context.WithValue(ctx, ctxkey.TaskLog{}, log)
+	return ctx, func() {
+		if err := w.close(); err != nil {
+			pkrun.Errorf(ctx, "pocket: writing log of %s: %v\n", effectiveName, err)
+		}
+	}
+}
+
+// taskLogWriter writes to a task's log file, which is created on the first
+// write, so tasks that print nothing leave no empty logs behind. Commands
+// write stdout and stderr concurrently, so writes are serialized. Errors are
+// kept for close instead of failing the writes, which would fail commands
+// whose output is copied to the log.
+type taskLogWriter struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	err  error // First error creating or writing the file.
+}
+
+func (w *taskLogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return len(p), nil
+	}
+	if w.f == nil {
+		if w.err = os.MkdirAll(filepath.Dir(w.path), 0o755); w.err != nil {
+			return len(p), nil
+		}
+		if w.f, w.err = os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644); w.err != nil {
+			return len(p), nil
+		}
+	}
+	_, w.err = w.f.Write(p)
+	return len(p), nil
+}
+
+// close closes the file and returns the first error creating or writing it.
+func (w *taskLogWriter) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f != nil {
+		if err := w.f.Close(); w.err == nil {
+			w.err = err
+		}
+	}
+	return w.err
+}
+
+// logFileName returns the log file name of a task at path, "<task>@<path>.log"
+// or "<task>.log" at the root, with characters that are not valid in file
+// names escaped. [logLabel] reverses it.
+func logFileName(effectiveName, path string) string {
+	label := effectiveName
+	if path != "" && path != "." {
+		label += "@" + path
+	}
+	escaped := strings.NewReplacer("%", "%25", "/", "%2F", "\\", "%5C", ":", "%3A").Replace(label)
+	return escaped + ".log"
+}
+
+// logLabel returns the "<task>@<path>" label of a log file name.
+func logLabel(fileName string) string {
+	label := strings.TrimSuffix(fileName, ".log")
+	if unescaped, err := url.PathUnescape(label); err == nil {
+		return unescaped
+	}
+	return label
+}
+
+// pruneRunLogs removes all but the newest keep run directories in dir.
+func pruneRunLogs(dir string, keep int) error {
+	runs, err := listRunLogs(dir)
+	if err != nil || len(runs) <= keep {
+		return err
+	}
+	for _, run := range runs[keep:] {
+		if err := os.RemoveAll(filepath.Join(dir, run)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listRunLogs returns the run IDs with logs in dir, newest first.
+func listRunLogs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var runs []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if isRunID(e.Name()) {
+			runs = append(runs, e.Name())
+		}
+	}
+	slices.Sort(runs)
+	slices.Reverse(runs)
+	return runs, nil
+}
+
+// listTaskLogs returns the labels of the task logs in a run directory, sorted.
+func listTaskLogs(runDir string) ([]string, error) {
+	entries, err := os.ReadDir(runDir)
+	if err != nil {
+		return nil, err
+	}
+	var labels []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".log") {
+			labels = append(labels, logLabel(e.Name()))
+		}
+	}
+	slices.Sort(labels)
+	return labels, nil
+}
+
+// logsFlags defines flags for the logs task.
+type logsFlags struct {
+	Run string `flag:"run" usage:"run ID to read, as listed by logs (default: latest run)"`
+}
+
+// logsTask lists recorded runs and prints task logs.
+var logsTask = &Task{
+	Name:       "logs",
+	Usage:      "list recorded runs, or print a task's log (logs <task>[@<path>])",
+	HideHeader: true,
+	Flags:      logsFlags{},
+	Do: func(ctx context.Context) error {
+		dir := repopath.FromPocketDir(logsDirName)
+		runs, err := listRunLogs(dir)
+		if err != nil {
+			return fmt.Errorf("reading logs: %w", err)
+		}
+		args := taskArgsFromContext(ctx)
+		run := pkrun.GetFlags[logsFlags](ctx).Run
+		switch {
+		case len(args) > 1:
+			return fmt.Errorf("logs accepts at most one task, got %d", len(args))
+		case run == "" && len(args) == 0:
+			printRunLogs(ctx, dir, runs)
+			return nil
+		case len(runs) == 0:
+			return fmt.Errorf("no logs found in %s", dir)
+		case run == "":
+			run = runs[0]
+		case !slices.Contains(runs, run):
+			return fmt.Errorf("no logs for run %q\nRun 'pok logs' to list runs", run)
+		}
+
+		runDir := filepath.Join(dir, run)
+		labels, err := listTaskLogs(runDir)
+		if err != nil {
+			return fmt.Errorf("reading logs: %w", err)
+		}
+		if len(args) == 0 {
+			for _, label := range labels {
+				pkrun.Printf(ctx, "%s\n", label)
+			}
+			return nil
+		}
+		label, err := matchTaskLog(labels, args[0])
+		if err != nil {
+			return fmt.Errorf("%w in run %s", err, run)
+		}
+		name, path, _ := strings.Cut(label, "@")
+		data, err := os.ReadFile(filepath.Join(runDir, logFileName(name, path)))
+		if err != nil {
+			return fmt.Errorf("reading log: %w", err)
+		}
+		pkrun.Printf(ctx, "%s", data)
+		return nil
+	},
+}
+
+// printRunLogs lists runs newest first, with the number of task logs in each.
+func printRunLogs(ctx context.Context, dir string, runs []string) {
+	if len(runs) == 0 {
+		pkrun.Printf(ctx, "no logs found in %s\n", dir)
+		return
+	}
+	for _, run := range runs {
+		labels, err := listTaskLogs(filepath.Join(dir, run))
+		if err != nil {
+			continue
+		}
+		if len(labels) == 1 {
+			pkrun.Printf(ctx, "%s  1 task log\n", run)
+			continue
+		}
+		pkrun.Printf(ctx, "%s  %d task logs\n", run, len(labels))
+	}
+}
+
+// matchTaskLog returns the label matching arg, either exactly or, for a task
+// name without a path, the only log of that task.
+func matchTaskLog(labels []string, arg string) (string, error) {
+	if slices.Contains(labels, arg) {
+		return arg, nil
+	}
+	var matches []string
+	if !strings.Contains(arg, "@") {
+		for _, label := range labels {
+			if strings.HasPrefix(label, arg+"@") {
+				matches = append(matches, label)
+			}
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no log for %q", arg)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("%q ran in several paths, choose one of: %s", arg, strings.Join(matches, ", "))
+	}
+}
@@ -0,0 +1,306 @@
+package pk
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+	"github.com/fredrikaverpil/pocket/pk/repopath"
+	pkrun "github.com/fredrikaverpil/pocket/pk/run"
+)
+
+func TestLogFileName(t *testing.T) {
+	tests := []struct {
+		name, path, want string
+	}{
+		{"go-test", ".", "go-test.log"},
+		{"go-test", "", "go-test.log"},
+		{"go-test", "services/api", "go-test@services%2Fapi.log"},
+		{"py-test:3.9", "lib", "py-test%3A3.9@lib.log"},
+		{"odd%name", ".", "odd%25name.log"},
+	}
+	for _, tt := range tests {
+		got := logFileName(tt.name, tt.path)
+		if got != tt.want {
+			t.Errorf("logFileName(%q, %q) = %q, want %q", tt.name, tt.path, got, tt.want)
+		}
+		label := tt.name
+		if tt.path != "" && tt.path != "." {
+			label += "@" + tt.path
+		}
+		if back := logLabel(got); back != label {
+			t.Errorf("logLabel(%q) = %q, want %q", got, back, label)
+		}
+	}
+}
+
+func TestWithTaskLog_WritesFullOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	root := e2eSetup(t)
+	if err := os.MkdirAll(filepath.Join(root, "services", "api"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	task := &Task{Name: "logged", Usage: "test task", Do: func(ctx context.Context) error {
+		pkrun.Printf(ctx, "printed\n")
+		return pkrun.Exec(ctx, "sh", "-c", "echo quiet command output")
+	}}
+
+	var out bytes.Buffer
+	ctx := context.WithValue(context.Background(), ctxkey.Output{}, &pkrun.Output{Stdout: &out, Stderr: &out})
+	ctx, pruneLogs := withRunLogs(ctx, nil)
+	if err := task.run(pkrun.ContextWithPath(ctx, "services/api")); err != nil {
+		t.Fatal(err)
+	}
+	pruneLogs()
+
+	if strings.Contains(out.String(), "quiet command output") {
+		t.Errorf("expected non-verbose command output to stay hidden, got %q", out.String())
+	}
+	runs, err := listRunLogs(filepath.Join(root, ".pocket", logsDirName))
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("expected one run, got %v (err %v)", runs, err)
+	}
+	data, err := os.ReadFile(filepath.Join(root, ".pocket", logsDirName, runs[0], "logged@services%2Fapi.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ":: logged [services/api]\nprinted\nquiet command output\n"
+	if string(data) != want {
+		t.Errorf("got log %q, want %q", data, want)
+	}
+}
+
+func TestWithTaskLog_NoticeOutputLoggedOnce(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	e2eSetup(t)
+	task := &Task{Name: "noisy", Usage: "test task", HideHeader: true, Do: func(ctx context.Context) error {
+		return pkrun.Exec(ctx, "sh", "-c", "echo warning: deprecated")
+	}}
+
+	ctx := context.WithValue(context.Background(), ctxkey.Output{}, testOutput())
+	ctx, _ = withRunLogs(ctx, nil)
+	if err := task.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(runLogsFromContext(ctx).dir, "noisy.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "warning: deprecated\n" {
+		t.Errorf("expected notice output logged once, got %q", data)
+	}
+}
+
+func TestWithTaskLog_SubtaskOutputInParentLog(t *testing.T) {
+	e2eSetup(t)
+	child := &Task{Name: "child", Usage: "test task", HideHeader: true, Do: func(ctx context.Context) error {
+		pkrun.Printf(ctx, "from child\n")
+		return nil
+	}}
+	parent := &Task{Name: "parent", Usage: "test task", HideHeader: true, Body: child}
+
+	ctx := context.WithValue(context.Background(), ctxkey.Output{}, testOutput())
+	ctx = withExecutionTracker(ctx, newExecutionTracker())
+	ctx, _ = withRunLogs(ctx, nil)
+	if err := parent.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	dir := runLogsFromContext(ctx).dir
+	for _, name := range []string{"parent.log", "child.log"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "from child\n" {
+			t.Errorf("%s: got %q, want %q", name, data, "from child\n")
+		}
+	}
+}
+
+func TestWithTaskLog_SilentTaskHasNoLog(t *testing.T) {
+	e2eSetup(t)
+	task := &Task{Name: "silent", Usage: "test task", HideHeader: true, Do: func(_ context.Context) error { return nil }}
+
+	ctx := context.WithValue(context.Background(), ctxkey.Output{}, testOutput())
+	ctx, _ = withRunLogs(ctx, nil)
+	if err := task.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(runLogsFromContext(ctx).dir, "silent.log")); !os.IsNotExist(err) {
+		t.Errorf("expected no log for a task without output, got err %v", err)
+	}
+}
+
+func TestWithRunLogs_Disabled(t *testing.T) {
+	root := e2eSetup(t)
+	task := &Task{Name: "unlogged", Usage: "test task", Do: func(_ context.Context) error { return nil }}
+
+	ctx := context.WithValue(context.Background(), ctxkey.Output{}, testOutput())
+	ctx, pruneLogs := withRunLogs(ctx, &Plan{logRuns: -1})
+	if err := task.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	pruneLogs()
+	if _, err := os.Stat(filepath.Join(root, ".pocket", logsDirName)); !os.IsNotExist(err) {
+		t.Errorf("expected no logs directory, got err %v", err)
+	}
+}
+
+func TestPruneRunLogs(t *testing.T) {
+	dir := t.TempDir()
+	runs := []string{
+		"2026-01-01T10-00-00.000",
+		"2026-01-02T10-00-00.000",
+		"2026-01-03T10-00-00.000-4242",
+		"2026-01-03T10-00-00.000-x",
+		"not-a-run",
+	}
+	for _, run := range runs {
+		if err := os.MkdirAll(filepath.Join(dir, run), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pruneRunLogs(dir, 2); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	want := []string{"2026-01-02T10-00-00.000", "2026-01-03T10-00-00.000-4242", "2026-01-03T10-00-00.000-x", "not-a-run"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestNewRunID(t *testing.T) {
+	now := time.Date(2026, 10, 16, 9, 12, 44, 301_000_000, time.UTC)
+	id := newRunID(now)
+	if want := "2026-10-16T09-12-44.301-" + strconv.Itoa(os.Getpid()); id != want {
+		t.Errorf("newRunID = %q, want %q", id, want)
+	}
+	if !isRunID(id) {
+		t.Errorf("expected %q to be recognized as a run ID", id)
+	}
+}
+
+func TestLogsTask(t *testing.T) {
+	root := e2eSetup(t)
+	logsDir := filepath.Join(root, ".pocket", logsDirName)
+	files := map[string]string{
+		"2026-01-01T10-00-00.000/go-test.log":                   "old run\n",
+		"2026-01-02T10-00-00.000/go-test@services%2Fapi.log":    "api tests\n",
+		"2026-01-02T10-00-00.000/go-test@services%2Fworker.log": "worker tests\n",
+		"2026-01-02T10-00-00.000/go-lint.log":                   "lint\n",
+	}
+	for name, content := range files {
+		writeFile(t, filepath.Join(logsDir, name), content)
+	}
+	if repopath.FromPocketDir(logsDirName) != logsDir {
+		t.Fatalf("unexpected logs dir %s", repopath.FromPocketDir(logsDirName))
+	}
+
+	run := func(args []string, flags map[string]any) (string, error) {
+		ctx := context.WithValue(context.Background(), ctxkey.TaskArgs{}, args)
+		if flags != nil {
+			ctx = withCLIFlags(ctx, logsTask.Name, flags)
+		}
+		var out bytes.Buffer
+		ctx = context.WithValue(ctx, ctxkey.Output{}, &pkrun.Output{Stdout: &out, Stderr: &out})
+		err := logsTask.run(ctx)
+		return out.String(), err
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		flags   map[string]any
+		want    string
+		wantErr string
+	}{
+		{
+			name: "ListRuns",
+			want: "2026-01-02T10-00-00.000  3 task logs\n2026-01-01T10-00-00.000  1 task log\n",
+		},
+		{
+			name:  "ListTaskLogsOfRun",
+			flags: map[string]any{"run": "2026-01-02T10-00-00.000"},
+			want:  "go-lint\ngo-test@services/api\ngo-test@services/worker\n",
+		},
+		{name: "LatestRun", args: []string{"go-test@services/api"}, want: "api tests\n"},
+		{name: "UniqueTaskName", args: []string{"go-lint"}, want: "lint\n"},
+		{
+			name:  "SelectedRun",
+			args:  []string{"go-test"},
+			flags: map[string]any{"run": "2026-01-01T10-00-00.000"},
+			want:  "old run\n",
+		},
+		{name: "AmbiguousTaskName", args: []string{"go-test"}, wantErr: "ran in several paths"},
+		{name: "UnknownTask", args: []string{"go-fmt"}, wantErr: `no log for "go-fmt"`},
+		{name: "UnknownRun", flags: map[string]any{"run": "yesterday"}, wantErr: `no logs for run "yesterday"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := run(tt.args, tt.flags)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRun_WritesTaskLogs(t *testing.T) {
+	root := e2eSetup(t)
+	writeFile(t, filepath.Join(root, ".pocket", "go.mod"), "module pocket\n\ngo 1.26\n")
+	for _, day := range []string{"01", "02", "03"} {
+		writeFile(t, filepath.Join(root, ".pocket", logsDirName, "2026-01-"+day+"T10-00-00.000", "lint.log"), "run\n")
+	}
+	lint := &Task{Name: "lint", Usage: "lint", HideHeader: true, Do: func(ctx context.Context) error {
+		pkrun.Printf(ctx, "linted\n")
+		return nil
+	}}
+	withArgs(t, "pok", "--summary", "off", "lint")
+	if _, err := run(&Config{Auto: lint, Plan: &PlanConfig{LogRuns: 2}}); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := listRunLogs(filepath.Join(root, ".pocket", logsDirName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[1] != "2026-01-03T10-00-00.000" {
+		t.Fatalf("expected the new run and the newest old run, got %v", runs)
+	}
+	data, err := os.ReadFile(filepath.Join(root, ".pocket", logsDirName, runs[0], "lint.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "linted\n" {
+		t.Errorf("got log %q, want %q", data, "linted\n")
+	}
+}
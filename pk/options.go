@@ -2,11 +2,14 @@ package pk
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"reflect"
 	"slices"
+	"strings"
+	"time"
 
 	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
 	pkrun "github.com/fredrikaverpil/pocket/pk/run"
@@ -132,6 +135,214 @@ func WithVerbose() Option {
 	}
 }
 
+// WithContinueOnError keeps executing the wrapped Runnable after a failure:
+// Serial runs its remaining steps, Parallel does not cancel its other branches,
+// and tasks still run in their remaining paths. The errors are combined with
+// [errors.Join]. This is the scoped equivalent of the -k/--keep-going flag.
+func WithContinueOnError() Option {
+	return func(pf *pathFilter) {
+		pf.keepGoing = true
+	}
+}
+
+// WithRetry re-runs a task in the wrapped Runnable when it fails at a path,
+// up to attempts executions in total. The delay before the second attempt is
+// backoff, and it doubles for each further attempt up to 5 minutes (a longer
+// backoff is used as given). Retries stop early when the context is cancelled,
+// and the final error reports how many attempts were made. Each retry shows
+// the attempt number in the task header and re-runs the whole task, including
+// composed subtasks that would otherwise be deduplicated.
+//
+// The innermost WithRetry applies. attempts must be at least 1 and backoff
+// must not be negative.
+//
+// Example:
+//
+//	pk.WithOptions(IntegrationTest, pk.WithRetry(3, 2*time.Second))
+func WithRetry(attempts int, backoff time.Duration) Option {
+	if attempts < 1 {
+		panic(fmt.Sprintf("pk: WithRetry requires attempts >= 1, got %d", attempts))
+	}
+	if backoff < 0 {
+		panic(fmt.Sprintf("pk: WithRetry requires a non-negative backoff, got %s", backoff))
+	}
+	return func(pf *pathFilter) {
+		pf.retry = &retryPolicy{attempts: attempts, backoff: backoff}
+	}
+}
+
+// WithTimeout fails a task in the wrapped Runnable if a single execution at a
+// path takes longer than d. Commands started with [pkrun.Exec] are interrupted
+// and then killed, the same way as on Ctrl-C. With [WithRetry], the timeout
+// applies to each attempt.
+//
+// The innermost WithTimeout applies, and it takes precedence over the global
+// --timeout flag. d must be positive.
+//
+// Example:
+//
+//	pk.WithOptions(golang.Test, pk.WithTimeout(10*time.Minute))
+func WithTimeout(d time.Duration) Option {
+	if d <= 0 {
+		panic(fmt.Sprintf("pk: WithTimeout requires a positive duration, got %s", d))
+	}
+	return func(pf *pathFilter) {
+		pf.timeout = d
+	}
+}
+
+// WithLock makes each task in the wrapped Runnable hold the named locks while
+// it executes, so tasks holding the same lock never run at the same time, even
+// in [Parallel] branches or parallel paths. Use it for tasks sharing a file,
+// a port, or a generated directory, instead of making the whole tree serial.
+// A task waiting for a lock shows how long it waited in its header.
+//
+// Locks apply in addition to those of enclosing scopes. A task holds its locks
+// for all attempts of [WithRetry] but not while its dependencies run, and
+// tasks it composes run under its locks. The timeout of [WithTimeout] does not
+// include the wait.
+//
+// Example:
+//
+//	pk.Parallel(
+//	    pk.WithOptions(golang.Test, pk.WithLock("coverage")),
+//	    pk.WithOptions(python.Test, pk.WithLock("coverage")),
+//	)
+func WithLock(names ...string) Option {
+	for _, name := range names {
+		if name == "" {
+			panic("pk: WithLock requires a lock name")
+		}
+	}
+	return func(pf *pathFilter) {
+		pf.locks = append(pf.locks, names...)
+	}
+}
+
+// WithMaxParallel limits how many tasks within the wrapped Runnable execute
+// at the same time. The limit covers all nested Parallels in the subtree as a
+// single budget, and applies in addition to any enclosing limit, including the
+// global --jobs flag. n must be at least 1.
+//
+// Example:
+//
+//	pk.WithOptions(
+//	    pk.Parallel(golang.Tasks(), python.Tasks(), markdown.Tasks()),
+//	    pk.WithMaxParallel(2), // At most two tasks run at once across all branches.
+//	)
+func WithMaxParallel(n int) Option {
+	if n < 1 {
+		panic(fmt.Sprintf("pk: WithMaxParallel requires n >= 1, got %d", n))
+	}
+	return func(pf *pathFilter) {
+		pf.maxParallel = n
+	}
+}
+
+// WithParallelPaths runs the wrapped Runnable in all of its resolved paths
+// concurrently instead of one path at a time. Output is buffered per path and
+// failures cancel the other paths, the same way as with [Parallel]; combine
+// with [WithContinueOnError] to let all paths finish, and with
+// [WithMaxParallel] to bound the fan-out. Direct invocation of a task from the
+// CLI (./pok go-test) runs its paths concurrently too. The -s flag still runs
+// paths one at a time.
+//
+// Example:
+//
+//	pk.WithOptions(
+//	    golang.Test,
+//	    pk.WithDetect(golang.Detect()),
+//	    pk.WithParallelPaths(),
+//	    pk.WithMaxParallel(8),
+//	)
+func WithParallelPaths() Option {
+	return func(pf *pathFilter) {
+		pf.parallelPaths = true
+	}
+}
+
+// WithOutputMode selects how the output of parallel branches within the
+// wrapped Runnable is written. [OutputBuffered], the default, writes each
+// branch's output in one piece when the branch completes. [OutputStream]
+// writes every line as it is produced, prefixed with the task and path that
+// wrote it, such as "[go-test@services/api]". Prefixes are colorized on a
+// terminal unless NO_COLOR is set. Streaming suits long-running parallel tasks
+// in CI, where waiting for a branch to complete hides its progress.
+//
+// The innermost WithOutputMode applies, and it takes precedence over the
+// global --output flag. When streaming, commands started with [pkrun.Exec]
+// print their output as it is produced, not only on failure or notice.
+//
+// Example:
+//
+//	pk.WithOptions(
+//	    pk.Parallel(golang.Test, python.Test),
+//	    pk.WithOutputMode(pk.OutputStream),
+//	)
+func WithOutputMode(mode OutputMode) Option {
+	if !slices.Contains(outputModes, mode) {
+		panic(fmt.Sprintf("pk: WithOutputMode requires %q or %q, got %q", OutputBuffered, OutputStream, mode))
+	}
+	return func(pf *pathFilter) {
+		pf.outputMode = mode
+	}
+}
+
+// WithEnv sets environment variables, each given as "KEY=value", for every
+// command started with [pkrun.Exec] within the wrapped Runnable, including
+// its dependencies and composed subtasks. Nested scopes add to and override
+// enclosing ones, and [pkrun.ContextWithEnv] in a task's Do overrides both.
+// Values are redacted in ./pok plan and in the -json export.
+//
+// Panics if a keyValue does not contain "=" or has an empty key.
+//
+// Example:
+//
+//	pk.WithOptions(golang.Test, pk.WithEnv("CGO_ENABLED=1", "GOFLAGS=-tags=integration"))
+func WithEnv(keyValues ...string) Option {
+	sources := make([]envSource, 0, len(keyValues))
+	for _, kv := range keyValues {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || key == "" {
+			panic(fmt.Sprintf("pk: WithEnv requires \"KEY=value\", got %q", kv))
+		}
+		sources = append(sources, envSource{key: key, value: value})
+	}
+	return func(pf *pathFilter) {
+		pf.env = append(pf.env, sources...)
+	}
+}
+
+// WithEnvFile sets the environment variables of .env files for every command
+// started with [pkrun.Exec] within the wrapped Runnable, like [WithEnv]. A
+// relative file is resolved against each path the task executes in, so each
+// module can have its own; a missing file fails the task. Files and WithEnv
+// variables apply in the order the options are given, later ones winning.
+//
+// Each line of a file is KEY=value, optionally preceded by "export". Blank
+// lines and lines starting with # are ignored, and values may be single or
+// double quoted. Variables are not expanded.
+//
+// Example:
+//
+//	pk.WithOptions(
+//	    golang.Test,
+//	    pk.WithDetect(golang.Detect()),
+//	    pk.WithEnvFile(".env.test"), // services/api/.env.test in services/api.
+//	)
+func WithEnvFile(files ...string) Option {
+	for _, file := range files {
+		if file == "" {
+			panic("pk: WithEnvFile requires a file name")
+		}
+	}
+	return func(pf *pathFilter) {
+		for _, file := range files {
+			pf.env = append(pf.env, envSource{file: file})
+		}
+	}
+}
+
 // WithNameSuffix creates a named variant of tasks within this scope.
 // The suffix is appended with a colon separator (e.g., "py-test" becomes "py-test:3.9").
 //
@@ -203,9 +414,17 @@ type pathFilter struct {
 	// cloned filters, never on user-owned WithOptions values.
 	resolvedPaths []string
 
-	forceRun       bool     // Disable task deduplication for the wrapped Runnable.
-	verbose        bool     // Force verbose mode for the wrapped Runnable.
-	noticePatterns []string // Custom notice detection patterns (nil = use default).
+	forceRun       bool          // Disable task deduplication for the wrapped Runnable.
+	verbose        bool          // Force verbose mode for the wrapped Runnable.
+	noticePatterns []string      // Custom notice detection patterns (nil = use default).
+	maxParallel    int           // Concurrency limit for the wrapped Runnable (0 = unlimited).
+	keepGoing      bool          // Continue executing the wrapped Runnable after errors.
+	retry          *retryPolicy  // Retry failed tasks in the wrapped Runnable (nil = no retry).
+	timeout        time.Duration // Per-execution timeout for tasks in the wrapped Runnable (0 = none).
+	parallelPaths  bool          // Run the wrapped Runnable in all resolved paths concurrently.
+	outputMode     OutputMode    // Output mode of parallel branches in the wrapped Runnable ("" = inherit).
+	env            []envSource   // Environment of commands in the wrapped Runnable, in order.
+	locks          []string      // Named locks held by tasks in the wrapped Runnable.
 }
 
 type excludePattern struct {
@@ -241,6 +460,18 @@ func (pf *pathFilter) run(ctx context.Context) error {
 		ctx = context.WithValue(ctx, ctxkey.NoticePatterns{}, pf.noticePatterns)
 	}
 
+	if pf.maxParallel > 0 {
+		ctx = withMaxParallel(ctx, pf.maxParallel)
+	}
+
+	if pf.keepGoing {
+		ctx = context.WithValue(ctx, ctxkey.KeepGoing{}, true)
+	}
+
+	if pf.outputMode != "" {
+		ctx = context.WithValue(ctx, ctxkey.OutputMode{}, pf.outputMode)
+	}
+
 	if pf.inner == nil {
 		return nil
 	}
@@ -266,14 +497,37 @@ func (pf *pathFilter) run(ctx context.Context) error {
 		}
 	}
 
+	runPath := func(ctx context.Context, path string) error {
+		pathCtx, err := contextWithEnvSources(pkrun.ContextWithPath(ctx, path), path, pf.env)
+		if err != nil {
+			return err
+		}
+		end := startSpan(pathCtx, path, traceCatPath)
+		err = pf.inner.run(pathCtx)
+		end(err)
+		return err
+	}
+
+	// With WithParallelPaths, fan out across the resolved paths.
+	if pf.parallelPaths && len(paths) > 1 && !serialFromContext(ctx) {
+		return runConcurrently(ctx, len(paths),
+			func(i int) string { return "path " + paths[i] },
+			func(ctx context.Context, i int) error { return runPath(ctx, paths[i]) },
+		)
+	}
+
 	// Execute inner Runnable for each resolved path.
+	keepGoing := keepGoingFromContext(ctx)
+	var errs []error
 	for _, path := range paths {
-		pathCtx := pkrun.ContextWithPath(ctx, path)
-		if err := pf.inner.run(pathCtx); err != nil {
-			return err
+		if err := runPath(ctx, path); err != nil {
+			if !keepGoing {
+				return err
+			}
+			errs = append(errs, err)
 		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
 // resolveTypedFlags resolves flagOverrides that use flagsType (deferred resolution)
@@ -322,7 +576,7 @@ func findTaskByFlagsType(r Runnable, ft reflect.Type) (string, *Task, error) {
 		if tt.Kind() == reflect.Pointer {
 			tt = tt.Elem()
 		}
-		if tt == ft {
+		if tt == ft && !slices.Contains(matches, t) {
 			matches = append(matches, t)
 		}
 	})
@@ -341,11 +595,15 @@ func findTaskByFlagsType(r Runnable, ft reflect.Type) (string, *Task, error) {
 	}
 }
 
-// walkTasks calls fn for every *Task reachable from r.
+// walkTasks calls fn for every *Task reachable from r, including task
+// dependencies, which run in the same scope as their dependent.
 func walkTasks(r Runnable, fn func(*Task)) {
 	switch v := r.(type) {
 	case *Task:
 		fn(v)
+		for _, dep := range v.Deps {
+			walkTasks(dep, fn)
+		}
 	case *serial:
 		for _, child := range v.runnables {
 			walkTasks(child, fn)
@@ -354,6 +612,9 @@ func walkTasks(r Runnable, fn func(*Task)) {
 		for _, child := range v.runnables {
 			walkTasks(child, fn)
 		}
+	case *finally:
+		walkTasks(v.body, fn)
+		walkTasks(v.cleanup, fn)
 	case *pathFilter:
 		walkTasks(v.inner, fn)
 	}
@@ -1,12 +1,17 @@
 package pk
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
 	pkrun "github.com/fredrikaverpil/pocket/pk/run"
 )
 
@@ -274,3 +279,131 @@ func TestDetectByFile_Multiple(t *testing.T) {
 		}
 	}
 }
+
+// pathBarrier blocks each caller until n paths have arrived, so a task using
+// it only succeeds when its paths run concurrently.
+type pathBarrier struct {
+	n       int32
+	arrived atomic.Int32
+	all     chan struct{}
+}
+
+func newPathBarrier(n int32) *pathBarrier {
+	return &pathBarrier{n: n, all: make(chan struct{})}
+}
+
+func (b *pathBarrier) wait(ctx context.Context) error {
+	if b.arrived.Add(1) == b.n {
+		close(b.all)
+	}
+	select {
+	case <-b.all:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(2 * time.Second):
+		return errors.New("paths did not run concurrently")
+	}
+}
+
+func TestWithParallelPaths_RunsConcurrently(t *testing.T) {
+	barrier := newPathBarrier(3)
+	task := &Task{Name: "par-paths", Usage: "test task", HideHeader: true, Do: barrier.wait}
+
+	pf := WithOptions(task, WithParallelPaths()).(*pathFilter)
+	pf.resolvedPaths = []string{"a", "b", "c"}
+
+	ctx := withExecutionTracker(context.Background(), newExecutionTracker())
+	ctx = context.WithValue(ctx, ctxkey.Output{}, testOutput())
+	if err := pf.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWithParallelPaths_BuffersOutputPerPath(t *testing.T) {
+	task := &Task{Name: "par-paths", Usage: "test task", HideHeader: true, Do: func(ctx context.Context) error {
+		for range 50 {
+			pkrun.Printf(ctx, "%s", pkrun.PathFromContext(ctx))
+		}
+		return nil
+	}}
+
+	pf := WithOptions(task, WithParallelPaths()).(*pathFilter)
+	pf.resolvedPaths = []string{"a", "b"}
+
+	var stdout bytes.Buffer
+	ctx := context.WithValue(context.Background(), ctxkey.Output{}, &pkrun.Output{Stdout: &stdout, Stderr: &stdout})
+	if err := pf.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got := stdout.String()
+	a, b := strings.Repeat("a", 50), strings.Repeat("b", 50)
+	if got != a+b && got != b+a {
+		t.Errorf("expected each path's output flushed as one block, got %q", got)
+	}
+}
+
+func TestWithParallelPaths_FailureCancelsOtherPaths(t *testing.T) {
+	errBoom := errors.New("boom")
+	task := &Task{Name: "par-paths", Usage: "test task", HideHeader: true, Do: func(ctx context.Context) error {
+		if pkrun.PathFromContext(ctx) == "a" {
+			return errBoom
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+			return errors.New("expected cancellation")
+		}
+	}}
+
+	pf := WithOptions(task, WithParallelPaths()).(*pathFilter)
+	pf.resolvedPaths = []string{"a", "b"}
+
+	ctx := context.WithValue(context.Background(), ctxkey.Output{}, testOutput())
+	if err := pf.run(ctx); !errors.Is(err, errBoom) {
+		t.Errorf("expected errBoom, got %v", err)
+	}
+}
+
+func TestWithParallelPaths_SerialMode(t *testing.T) {
+	var running, peak atomic.Int32
+	task := &Task{Name: "par-paths", Usage: "test task", HideHeader: true, Do: func(_ context.Context) error {
+		peak.Store(max(peak.Load(), running.Add(1)))
+		time.Sleep(5 * time.Millisecond)
+		running.Add(-1)
+		return nil
+	}}
+
+	pf := WithOptions(task, WithParallelPaths()).(*pathFilter)
+	pf.resolvedPaths = []string{"a", "b", "c"}
+
+	ctx := context.WithValue(context.Background(), ctxkey.Serial{}, true)
+	if err := pf.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := peak.Load(); got != 1 {
+		t.Errorf("expected -s to run paths one at a time, peak concurrency %d", got)
+	}
+}
+
+func TestWithParallelPaths_DirectExecution(t *testing.T) {
+	root := e2eSetup(t)
+	barrier := newPathBarrier(2)
+	task := &Task{Name: "par-paths", Usage: "test task", HideHeader: true, Do: barrier.wait}
+
+	cfg := &Config{Auto: WithOptions(task, WithPath("a", "b"), WithParallelPaths())}
+	plan, err := newPlan(cfg, root, []string{".", "a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := plan.taskInstanceByName("par-paths")
+	if instance == nil || !instance.parallelPaths {
+		t.Fatalf("expected instance with parallel paths, got %+v", instance)
+	}
+
+	ctx, _ := integrationCtx(t, plan)
+	if err := instance.execute(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
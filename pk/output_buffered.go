@@ -2,6 +2,7 @@ package pk
 
 import (
 	"bytes"
+	"io"
 
 	pkrun "github.com/fredrikaverpil/pocket/pk/run"
 )
@@ -29,11 +30,12 @@ func (b *bufferedOutput) output() *pkrun.Output {
 	}
 }
 
+// flush writes the buffered output to the parent, with secrets masked.
 func (b *bufferedOutput) flush() {
 	if b.stdout.Len() > 0 {
-		_, _ = b.parent.Stdout.Write(b.stdout.Bytes())
+		_, _ = io.WriteString(b.parent.Stdout, pkrun.Redact(b.stdout.String()))
 	}
 	if b.stderr.Len() > 0 {
-		_, _ = b.parent.Stderr.Write(b.stderr.Bytes())
+		_, _ = io.WriteString(b.parent.Stderr, pkrun.Redact(b.stderr.String()))
 	}
 }
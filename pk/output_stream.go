@@ -0,0 +1,144 @@
+package pk
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"hash/fnv"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+	pkrun "github.com/fredrikaverpil/pocket/pk/run"
+)
+
+// OutputMode selects how the output of parallel branches is written.
+// See [WithOutputMode] and the --output flag.
+type OutputMode string
+
+const (
+	// OutputBuffered buffers the output of each parallel branch and writes it
+	// in one piece when the branch completes. This is the default.
+	OutputBuffered OutputMode = "buffered"
+	// OutputStream writes the output of parallel branches line by line as it
+	// is produced, each line prefixed with the task and path ([task@path]).
+	OutputStream OutputMode = "stream"
+)
+
+// outputModes lists the valid output modes.
+var outputModes = []OutputMode{OutputBuffered, OutputStream}
+
+// streamColors are the ANSI colors of line prefixes on a terminal. Red is
+// left out, so prefixes are not mistaken for errors.
+var streamColors = []string{"36", "32", "33", "35", "34", "96", "92", "93", "95", "94"}
+
+func outputModeFromContext(ctx context.Context) OutputMode {
+	mode, _ := ctx.Value(ctxkey.OutputMode{}).(OutputMode)
+	return cmp.Or(mode, OutputBuffered)
+}
+
+// streamSink is the output shared by the streaming branches of parallel
+// execution. Writers created with [streamSink.output] write whole lines under
+// a shared lock, so the lines of concurrent branches never interleave.
+type streamSink struct {
+	mu    sync.Mutex
+	out   *pkrun.Output
+	color bool // Colorize line prefixes.
+}
+
+// newStreamSink returns a sink writing to out. Prefixes are colorized when
+// stdout is a terminal, unless NO_COLOR is set, the same way command output is.
+// out itself is usually wrapped, for example by the progress display.
+func newStreamSink(out *pkrun.Output) *streamSink {
+	_, noColor := os.LookupEnv("NO_COLOR")
+	return &streamSink{
+		out:   out,
+		color: !noColor && os.Getenv("TERM") != "dumb" && pkrun.IsTerminal(os.Stdout),
+	}
+}
+
+func streamSinkFromContext(ctx context.Context) *streamSink {
+	s, _ := ctx.Value(ctxkey.StreamOutput{}).(*streamSink)
+	return s
+}
+
+// output returns an Output writing to the sink with each line prefixed with
+// [label], and a function writing any incomplete last line.
+func (s *streamSink) output(label string) (*pkrun.Output, func()) {
+	prefix := "[" + label + "] "
+	if s.color {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(label))
+		color := streamColors[h.Sum32()%uint32(len(streamColors))]
+		prefix = "\x1b[" + color + "m[" + label + "]\x1b[0m "
+	}
+	stdout := &streamWriter{sink: s, w: s.out.Stdout, prefix: []byte(prefix)}
+	stderr := &streamWriter{sink: s, w: s.out.Stderr, prefix: []byte(prefix)}
+	return &pkrun.Output{Stdout: stdout, Stderr: stderr}, func() {
+		stdout.flush()
+		stderr.flush()
+	}
+}
+
+// withStreamPrefix prefixes the output of the task effectiveName with its name
+// and path when ctx belongs to a streaming parallel branch. The returned
+// function writes any incomplete last line; call it when the task completes.
+func withStreamPrefix(ctx context.Context, effectiveName string) (context.Context, func()) {
+	s := streamSinkFromContext(ctx)
+	if s == nil {
+		return ctx, func() {}
+	}
+	label := effectiveName
+	if path := pkrun.PathFromContext(ctx); path != "" && path != "." {
+		label += "@" + path
+	}
+	out, flush := s.output(label)
+	return context.WithValue(ctx, ctxkey.Output{}, out), flush
+}
+
+// streamWriter writes complete lines to w, each preceded by prefix. A partial
+// line is held until its line break is written or flush is called.
+type streamWriter struct {
+	sink   *streamSink
+	w      io.Writer
+	prefix []byte
+	buf    []byte // Incomplete last line.
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	sw.sink.mu.Lock()
+	defer sw.sink.mu.Unlock()
+	sw.buf = append(sw.buf, p...)
+	for {
+		i := bytes.IndexByte(sw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := sw.writeLine(sw.buf[:i+1]); err != nil {
+			return len(p), err
+		}
+		sw.buf = sw.buf[i+1:]
+	}
+	if len(sw.buf) == 0 {
+		sw.buf = nil // Release the written lines.
+	}
+	return len(p), nil
+}
+
+// flush writes the incomplete last line, if any, with a line break.
+func (sw *streamWriter) flush() {
+	sw.sink.mu.Lock()
+	defer sw.sink.mu.Unlock()
+	if len(sw.buf) > 0 {
+		_ = sw.writeLine(append(sw.buf, '\n'))
+		sw.buf = nil
+	}
+}
+
+// writeLine writes line with its prefix in a single write. The caller holds
+// sw.sink.mu.
+func (sw *streamWriter) writeLine(line []byte) error {
+	_, err := io.WriteString(sw.w, string(sw.prefix)+pkrun.Redact(string(line)))
+	return err
+}
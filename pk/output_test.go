@@ -3,7 +3,13 @@ package pk
 import (
 	"bytes"
 	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
 	pkrun "github.com/fredrikaverpil/pocket/pk/run"
@@ -44,6 +50,25 @@ func TestBufferedOutput_FlushEmpty(t *testing.T) {
 	}
 }
 
+func TestBufferedOutput_FlushRedactsSecrets(t *testing.T) {
+	pkrun.RegisterSecret("buffered-test-secret")
+	var parentStdout, parentStderr bytes.Buffer
+	buf := newBufferedOutput(&pkrun.Output{Stdout: &parentStdout, Stderr: &parentStderr})
+	out := buf.output()
+
+	_, _ = out.Stdout.Write([]byte("token buffered-test-"))
+	_, _ = out.Stdout.Write([]byte("secret\n"))
+	_, _ = out.Stderr.Write([]byte("buffered-test-secret"))
+	buf.flush()
+
+	if got := parentStdout.String(); got != "token ***\n" {
+		t.Errorf("stdout: expected the secret to be masked, got %q", got)
+	}
+	if got := parentStderr.String(); got != "***" {
+		t.Errorf("stderr: expected the secret to be masked, got %q", got)
+	}
+}
+
 func TestOutputFromContext(t *testing.T) {
 	t.Run("ReturnsSetOutput", func(t *testing.T) {
 		var buf bytes.Buffer
@@ -99,3 +124,206 @@ func TestPrintln(t *testing.T) {
 		t.Errorf("expected %q, got %q", "hello\n", got)
 	}
 }
+
+func TestStreamSink_PrefixesCompleteLines(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	sink := newStreamSink(&pkrun.Output{Stdout: &stdout, Stderr: &stderr})
+	out, flush := sink.output("go-test@api")
+
+	_, _ = out.Stdout.Write([]byte("one\ntw"))
+	if got, want := stdout.String(), "[go-test@api] one\n"; got != want {
+		t.Errorf("expected partial line held back, got %q, want %q", got, want)
+	}
+	_, _ = out.Stdout.Write([]byte("o\nthree"))
+	_, _ = out.Stderr.Write([]byte("oops\n"))
+	flush()
+
+	if got, want := stdout.String(), "[go-test@api] one\n[go-test@api] two\n[go-test@api] three\n"; got != want {
+		t.Errorf("stdout: got %q, want %q", got, want)
+	}
+	if got, want := stderr.String(), "[go-test@api] oops\n"; got != want {
+		t.Errorf("stderr: got %q, want %q", got, want)
+	}
+}
+
+func TestOutputStream_StreamsBeforeBranchCompletes(t *testing.T) {
+	var mu sync.Mutex
+	var stdout bytes.Buffer
+	seen := make(chan struct{})
+	w := writerFunc(func(p []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		stdout.Write(p)
+		if strings.Contains(stdout.String(), "[stream@a] started\n") && strings.Contains(stdout.String(), "[stream@b]") {
+			select {
+			case <-seen:
+			default:
+				close(seen)
+			}
+		}
+		return len(p), nil
+	})
+
+	task := &Task{Name: "stream", Usage: "test task", Do: func(ctx context.Context) error {
+		pkrun.Printf(ctx, "started\n")
+		// Only returns once both paths' lines reached the output.
+		select {
+		case <-seen:
+			return nil
+		case <-time.After(2 * time.Second):
+			return errors.New("output was not streamed")
+		}
+	}}
+	pf := WithOptions(task, WithParallelPaths(), WithOutputMode(OutputStream)).(*pathFilter)
+	pf.resolvedPaths = []string{"a", "b"}
+
+	ctx := context.WithValue(context.Background(), ctxkey.Output{}, &pkrun.Output{Stdout: w, Stderr: w})
+	if err := pf.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for line := range strings.Lines(stdout.String()) {
+		if !strings.HasPrefix(line, "[stream@a] ") && !strings.HasPrefix(line, "[stream@b] ") {
+			t.Errorf("expected every line prefixed with task and path, got %q", line)
+		}
+	}
+	if !strings.Contains(stdout.String(), "[stream@a] :: stream [a]\n") {
+		t.Errorf("expected prefixed header, got %q", stdout.String())
+	}
+}
+
+func TestOutputStream_StreamsExecOutput(t *testing.T) {
+	root := e2eSetup(t)
+	writeFile(t, filepath.Join(root, "a", "go.mod"), "module a")
+	writeFile(t, filepath.Join(root, "b", "go.mod"), "module b")
+	marker := filepath.Join(root, "released")
+
+	var mu sync.Mutex
+	var stdout bytes.Buffer
+	w := writerFunc(func(p []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		stdout.Write(p)
+		if strings.Contains(stdout.String(), "[exec@a] started\n") {
+			writeFile(t, marker, "")
+		}
+		return len(p), nil
+	})
+
+	// The command only exits once its first line reached the output.
+	script := "echo started; i=0; while [ ! -f " + marker + " ]; do i=$((i+1)); " +
+		"[ $i -gt 200 ] && exit 1; sleep 0.01; done; echo 'warning: slow'"
+	task := &Task{Name: "exec", Usage: "test task", HideHeader: true, Do: func(ctx context.Context) error {
+		return pkrun.Exec(ctx, "sh", "-c", script)
+	}}
+	pf := WithOptions(task, WithParallelPaths(), WithOutputMode(OutputStream)).(*pathFilter)
+	pf.resolvedPaths = []string{"a", "b"}
+
+	ctx := context.WithValue(context.Background(), ctxkey.Output{}, &pkrun.Output{Stdout: w, Stderr: w})
+	if err := pf.run(ctx); err != nil {
+		t.Fatalf("expected command output to stream without verbose mode, got %v", err)
+	}
+	// The notice was already shown, so it is not printed again.
+	if got := strings.Count(stdout.String(), "[exec@a] warning: slow\n"); got != 1 {
+		t.Errorf("expected the notice once, got %d times in:\n%s", got, stdout.String())
+	}
+}
+
+func TestOutputStream_NestedBranchesUseSinglePrefix(t *testing.T) {
+	leaf := func(name string) *Task {
+		return &Task{Name: name, Usage: "test task", HideHeader: true, Do: func(ctx context.Context) error {
+			pkrun.Printf(ctx, "hello\n")
+			return nil
+		}}
+	}
+	r := WithOptions(
+		Parallel(Parallel(leaf("a"), leaf("b")), leaf("c")),
+		WithOutputMode(OutputStream),
+	).(*pathFilter)
+	r.resolvedPaths = []string{"."}
+
+	var stdout bytes.Buffer
+	ctx := withExecutionTracker(context.Background(), newExecutionTracker())
+	ctx = context.WithValue(ctx, ctxkey.Output{}, &pkrun.Output{Stdout: &stdout, Stderr: &stdout})
+	if err := r.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	lines := slices.Sorted(strings.Lines(stdout.String()))
+	want := []string{"[a] hello\n", "[b] hello\n", "[c] hello\n"}
+	if !slices.Equal(lines, want) {
+		t.Errorf("got %q, want %q", lines, want)
+	}
+}
+
+func TestOutputStream_BufferedScopeWithinStream(t *testing.T) {
+	task := func(name string) *Task {
+		return &Task{Name: name, Usage: "test task", HideHeader: true, Do: func(ctx context.Context) error {
+			for range 20 {
+				pkrun.Printf(ctx, "%s", name)
+			}
+			pkrun.Printf(ctx, "\n")
+			return nil
+		}}
+	}
+	buffered := WithOptions(Parallel(task("a"), task("b")), WithOutputMode(OutputBuffered)).(*pathFilter)
+	buffered.resolvedPaths = []string{"."}
+	r := WithOptions(Parallel(buffered, task("c")), WithOutputMode(OutputStream)).(*pathFilter)
+	r.resolvedPaths = []string{"."}
+
+	var stdout bytes.Buffer
+	ctx := withExecutionTracker(context.Background(), newExecutionTracker())
+	ctx = context.WithValue(ctx, ctxkey.Output{}, &pkrun.Output{Stdout: &stdout, Stderr: &stdout})
+	if err := r.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	lines := slices.Sorted(strings.Lines(stdout.String()))
+	want := []string{
+		"[c] " + strings.Repeat("c", 20) + "\n",
+		"[parallel branch 1/2] " + strings.Repeat("a", 20) + "\n",
+		"[parallel branch 1/2] " + strings.Repeat("b", 20) + "\n",
+	}
+	if !slices.Equal(lines, want) {
+		t.Errorf("got %q, want %q", lines, want)
+	}
+}
+
+func TestWithOutputMode_InvalidModePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for invalid output mode")
+		}
+	}()
+	WithOutputMode("interleaved")
+}
+
+// writerFunc adapts a function to [io.Writer].
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+func TestWithOutputMode_DirectExecution(t *testing.T) {
+	root := e2eSetup(t)
+	task := &Task{Name: "stream", Usage: "test task", HideHeader: true, Do: func(ctx context.Context) error {
+		pkrun.Printf(ctx, "hello\n")
+		return nil
+	}}
+
+	cfg := &Config{Auto: WithOptions(task, WithPath("a", "b"), WithParallelPaths(), WithOutputMode(OutputStream))}
+	plan, err := newPlan(cfg, root, []string{".", "a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := plan.taskInstanceByName("stream")
+	if instance == nil || instance.outputMode != OutputStream {
+		t.Fatalf("expected instance with stream output mode, got %+v", instance)
+	}
+
+	ctx, out := integrationCtx(t, plan)
+	if err := instance.execute(ctx); err != nil {
+		t.Fatal(err)
+	}
+	lines := slices.Sorted(strings.Lines(out.String()))
+	want := []string{"[stream@a] hello\n", "[stream@b] hello\n"}
+	if !slices.Equal(lines, want) {
+		t.Errorf("got %q, want %q", lines, want)
+	}
+}
@@ -0,0 +1,116 @@
+package pktest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/fredrikaverpil/pocket/pk/run"
+)
+
+// Command is a command started with run.Exec.
+type Command struct {
+	// Name is the command name as passed to run.Exec, without directory.
+	Name string
+	// Args are the command arguments, excluding the name.
+	Args []string
+	// Dir is the working directory relative to the fixture root, with forward
+	// slashes ("." for the root).
+	Dir string
+	// Env holds the environment overrides set with run.ContextWithEnv.
+	Env map[string]string
+}
+
+// String returns the command line: the name and arguments separated by spaces.
+func (c Command) String() string {
+	return strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+// Response is the scripted result of a command.
+type Response struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int // Non-zero makes the command fail with an [*ExitError].
+}
+
+// ExitError is returned by a faked command with a non-zero exit code.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+type stub struct {
+	prefix   string
+	response Response
+}
+
+// Stub scripts the response of commands whose command line starts with the
+// words in prefix, e.g. "go test" or "golangci-lint run --fix". When several
+// stubs match, the one added last wins. Commands without a matching stub
+// succeed without output.
+func (f *Fixture) Stub(prefix string, r Response) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stubs = append(f.stubs, stub{prefix: prefix, response: r})
+}
+
+// exec records cmd and writes its scripted response. It replaces os/exec in
+// run.Exec.
+func (f *Fixture) exec(ctx context.Context, cmd *exec.Cmd) error {
+	c := Command{
+		Name: strings.TrimSuffix(filepath.Base(cmd.Args[0]), exeSuffix()),
+		Args: slices.Clone(cmd.Args[1:]),
+		Dir:  f.relDir(cmd.Dir),
+		Env:  run.EnvConfigFromContext(ctx).Set,
+	}
+	line := c.String()
+
+	f.mu.Lock()
+	f.commands = append(f.commands, c)
+	var r Response
+	for i := len(f.stubs) - 1; i >= 0; i-- {
+		if line == f.stubs[i].prefix || strings.HasPrefix(line, f.stubs[i].prefix+" ") {
+			r = f.stubs[i].response
+			break
+		}
+	}
+	f.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(cmd.Stdout, r.Stdout); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(cmd.Stderr, r.Stderr); err != nil {
+		return err
+	}
+	if r.ExitCode != 0 {
+		return &ExitError{Code: r.ExitCode}
+	}
+	return nil
+}
+
+// relDir returns dir relative to the fixture root, with forward slashes.
+func (f *Fixture) relDir(dir string) string {
+	rel, err := filepath.Rel(f.Root, dir)
+	if err != nil {
+		return dir
+	}
+	return filepath.ToSlash(rel)
+}
+
+func exeSuffix() string {
+	if runtime.GOOS == "windows" {
+		return ".exe"
+	}
+	return ""
+}
@@ -0,0 +1,188 @@
+// Package pktest runs Pocket configs and tasks in tests, against a temporary
+// repository and with a fake in place of [run.Exec].
+//
+// A [Fixture] is a temporary git repository. Write the files a config
+// expects with [Fixture.WriteFile], script command results with
+// [Fixture.Stub], then run a config or task and assert on the commands that
+// ran:
+//
+//	func TestLint(t *testing.T) {
+//	    f := pktest.New(t, pktest.SkipTasks("install:golangci-lint"))
+//	    f.WriteFile("services/api/go.mod", "module api")
+//	    f.Stub("golangci-lint run", pktest.Response{Stdout: "1 issue", ExitCode: 1})
+//
+//	    res := f.Run(Config, "go-lint")
+//	    if res.Err == nil {
+//	        t.Fatal("expected lint failure")
+//	    }
+//	    if got := res.Commands[0].Dir; got != "services/api" {
+//	        t.Errorf("expected lint in services/api, got %s", got)
+//	    }
+//	}
+//
+// Only commands started with [run.Exec] are faked. Tool installation, which
+// downloads or builds binaries, runs for real; use [SkipTasks] to leave out
+// install tasks.
+//
+// A fixture points Pocket's git root at its directory for the duration of
+// the test, so tests using pktest must not run in parallel.
+package pktest
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/fredrikaverpil/pocket/pk"
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+	"github.com/fredrikaverpil/pocket/pk/repopath"
+	"github.com/fredrikaverpil/pocket/pk/run"
+)
+
+// Option configures a [Fixture].
+type Option func(*Fixture)
+
+// SkipTasks leaves out the named tasks, e.g. tool install dependencies, when
+// running. Names match a task's name or its effective name with suffix.
+func SkipTasks(names ...string) Option {
+	return func(f *Fixture) {
+		f.skipTasks = append(f.skipTasks, names...)
+	}
+}
+
+// Verbose runs tasks as with the -v flag, streaming command output.
+func Verbose() Option {
+	return func(f *Fixture) {
+		f.verbose = true
+	}
+}
+
+// Serial runs parallel compositions sequentially, as with the -s flag, so
+// commands are recorded in a deterministic order.
+func Serial() Option {
+	return func(f *Fixture) {
+		f.serial = true
+	}
+}
+
+// Fixture is a temporary git repository in which configs and tasks run with
+// faked commands.
+type Fixture struct {
+	// Root is the absolute path of the repository.
+	Root string
+
+	t         testing.TB
+	skipTasks []string
+	verbose   bool
+	serial    bool
+
+	mu       sync.Mutex
+	stubs    []stub
+	commands []Command
+}
+
+// New returns a fixture in a new temporary git repository. Pocket's git root
+// points at the fixture until the test ends.
+func New(t testing.TB, opts ...Option) *Fixture {
+	t.Helper()
+	f := &Fixture{Root: t.TempDir(), t: t}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	cmd := exec.Command("git", "init", "--quiet")
+	cmd.Dir = f.Root
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("pktest: git init: %v\n%s", err, out)
+	}
+	repopath.SetGitRootFunc(func() string { return f.Root })
+	t.Cleanup(func() { repopath.SetGitRootFunc(nil) })
+	return f
+}
+
+// WriteFile writes content to name, relative to the fixture root, creating
+// parent directories.
+func (f *Fixture) WriteFile(name, content string) {
+	f.t.Helper()
+	path := filepath.Join(f.Root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		f.t.Fatalf("pktest: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		f.t.Fatalf("pktest: %v", err)
+	}
+}
+
+// Result is the outcome of running a config or task in a fixture.
+type Result struct {
+	// Err is the error returned by the run, if any.
+	Err error
+	// Output is everything the tasks printed, including task headers.
+	Output string
+	// Commands are the commands started with run.Exec, in the order they started.
+	Commands []Command
+}
+
+// CommandLines returns the command lines of the commands that ran, in order.
+func (r *Result) CommandLines() []string {
+	lines := make([]string, len(r.Commands))
+	for i, c := range r.Commands {
+		lines[i] = c.String()
+	}
+	return lines
+}
+
+// Run runs cfg in the fixture the way ./pok does: with no names, the auto
+// tasks run; otherwise, the named tasks run in order. Each run records its
+// commands separately.
+func (f *Fixture) Run(cfg *pk.Config, names ...string) *Result {
+	f.t.Helper()
+	f.mu.Lock()
+	f.commands = nil
+	f.mu.Unlock()
+
+	var out lockedBuffer
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, ctxkey.Output{}, &run.Output{Stdout: &out, Stderr: &out})
+	ctx = context.WithValue(ctx, ctxkey.ExecHook{}, f.exec)
+	ctx = context.WithValue(ctx, ctxkey.Verbose{}, f.verbose)
+	ctx = context.WithValue(ctx, ctxkey.Serial{}, f.serial)
+	if len(f.skipTasks) > 0 {
+		ctx = context.WithValue(ctx, ctxkey.SkipTasks{}, f.skipTasks)
+	}
+
+	err := pk.Execute(ctx, cfg, names...)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &Result{Err: err, Output: out.String(), Commands: f.commands}
+}
+
+// RunTask runs r as the auto tasks of a config. Use [pk.WithOptions] to set
+// its paths or flags.
+func (f *Fixture) RunTask(r pk.Runnable) *Result {
+	f.t.Helper()
+	return f.Run(&pk.Config{Auto: r})
+}
+
+// lockedBuffer is a bytes.Buffer safe for concurrent writes.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,153 @@
+package pktest_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/fredrikaverpil/pocket/pk"
+	"github.com/fredrikaverpil/pocket/pk/pktest"
+	"github.com/fredrikaverpil/pocket/pk/run"
+)
+
+type lintFlags struct {
+	Fix bool `flag:"fix" usage:"apply fixes"`
+}
+
+var install = &pk.Task{
+	Name:   "install:linter",
+	Usage:  "install linter",
+	Hidden: true,
+	Global: true,
+	Do: func(ctx context.Context) error {
+		return run.Exec(ctx, "go", "install", "example.com/linter@v1")
+	},
+}
+
+var lint = &pk.Task{
+	Name:  "lint",
+	Usage: "lint",
+	Flags: lintFlags{},
+	Deps:  []pk.Runnable{install},
+	Do: func(ctx context.Context) error {
+		args := []string{"run"}
+		if run.GetFlags[lintFlags](ctx).Fix {
+			args = append(args, "--fix")
+		}
+		return run.Exec(ctx, "linter", append(args, "./...")...)
+	},
+}
+
+var test = &pk.Task{
+	Name:  "test",
+	Usage: "test",
+	Do: func(ctx context.Context) error {
+		ctx = run.ContextWithEnv(ctx, "CGO_ENABLED=0")
+		return run.Exec(ctx, "go", "test", "./...")
+	},
+}
+
+var release = &pk.Task{
+	Name:  "release",
+	Usage: "release",
+	Do: func(ctx context.Context) error {
+		return run.Exec(ctx, "goreleaser", "release")
+	},
+}
+
+var config = &pk.Config{
+	Auto: pk.WithOptions(
+		pk.Parallel(pk.WithOptions(lint, pk.WithFlags(lintFlags{Fix: true})), test),
+		pk.WithDetect(pk.DetectByFile("go.mod")),
+	),
+	Manual: []pk.Runnable{release},
+}
+
+func TestFixture_Run(t *testing.T) {
+	f := pktest.New(t, pktest.Serial())
+	f.WriteFile("svc/a/go.mod", "module a")
+	f.WriteFile("svc/b/go.mod", "module b")
+	f.WriteFile("docs/README.md", "docs")
+
+	res := f.Run(config)
+	if res.Err != nil {
+		t.Fatalf("unexpected error: %v\n%s", res.Err, res.Output)
+	}
+
+	var got []string
+	for _, c := range res.Commands {
+		got = append(got, c.Dir+": "+c.String())
+	}
+	want := []string{
+		"svc/a: go install example.com/linter@v1",
+		"svc/a: linter run --fix ./...",
+		"svc/a: go test ./...",
+		"svc/b: linter run --fix ./...",
+		"svc/b: go test ./...",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("unexpected commands:\ngot  %q\nwant %q", got, want)
+	}
+	if env := res.Commands[2].Env; env["CGO_ENABLED"] != "0" {
+		t.Errorf("expected env override to be recorded, got %v", env)
+	}
+	if !strings.Contains(res.Output, ":: lint") {
+		t.Errorf("expected task headers in output, got:\n%s", res.Output)
+	}
+}
+
+func TestFixture_Stub(t *testing.T) {
+	f := pktest.New(t, pktest.SkipTasks("install:linter"))
+	f.WriteFile("go.mod", "module root")
+	f.Stub("linter", pktest.Response{Stdout: "main.go:1: unused import"})
+	f.Stub("linter run --fix", pktest.Response{Stdout: "main.go:3: bad format", ExitCode: 2})
+
+	res := f.Run(config, "lint")
+	var exitErr *pktest.ExitError
+	if !errors.As(res.Err, &exitErr) || exitErr.Code != 2 {
+		t.Fatalf("expected exit status 2, got %v", res.Err)
+	}
+	if !strings.Contains(res.Err.Error(), "main.go:3: bad format") {
+		t.Errorf("expected scripted output in error, got %v", res.Err)
+	}
+	if got := res.CommandLines(); !slices.Equal(got, []string{"linter run --fix ./..."}) {
+		t.Errorf("expected skipped install and a single lint command, got %q", got)
+	}
+}
+
+func TestFixture_StubWarning(t *testing.T) {
+	f := pktest.New(t)
+	f.Stub("go test", pktest.Response{Stderr: "WARNING: slow test"})
+
+	res := f.RunTask(test)
+	if res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	if !strings.Contains(res.Output, "WARNING: slow test") {
+		t.Errorf("expected warning output to be shown, got:\n%s", res.Output)
+	}
+}
+
+func TestFixture_RunManualTask(t *testing.T) {
+	f := pktest.New(t)
+
+	if res := f.Run(config); slices.Contains(res.CommandLines(), "goreleaser release") {
+		t.Error("expected manual task to be skipped by auto execution")
+	}
+	res := f.Run(config, "release")
+	if res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	if got := res.CommandLines(); !slices.Equal(got, []string{"goreleaser release"}) {
+		t.Errorf("expected only the release command, got %q", got)
+	}
+	if res.Commands[0].Dir != "." {
+		t.Errorf("expected command in the root, got %q", res.Commands[0].Dir)
+	}
+
+	if res := f.Run(config, "missing"); res.Err == nil || !strings.Contains(res.Err.Error(), `"missing" not found`) {
+		t.Errorf("expected unknown task error, got %v", res.Err)
+	}
+}
@@ -1,11 +1,14 @@
 package pk
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"reflect"
 	"slices"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
 	"github.com/fredrikaverpil/pocket/pk/repopath"
@@ -55,6 +58,15 @@ type Plan struct {
 
 	// shimConfig holds the shim generation configuration from Config.
 	shimConfig *ShimConfig
+
+	// skipDirs and includeHidden are the directory walk rules the plan was
+	// built with, reused when matching task Inputs and Outputs.
+	// A nil skipDirs means the plan was not built from the filesystem.
+	skipDirs      []string
+	includeHidden bool
+
+	// logRuns is how many runs keep their task logs (from PlanConfig.LogRuns).
+	logRuns int
 }
 
 // ShimConfig returns the resolved shim configuration from the [Config].
@@ -104,7 +116,12 @@ func newPublicPlan(cfg *Config) (*Plan, error) {
 	if err != nil {
 		return nil, err
 	}
-	return newPlan(cfg, gitRoot, allDirs)
+	p, err := newPlan(cfg, gitRoot, allDirs)
+	if err != nil {
+		return nil, err
+	}
+	p.skipDirs, p.includeHidden = skipDirs, includeHidden
+	return p, nil
 }
 
 func newPlan(cfg *Config, gitRoot string, allDirs []string) (*Plan, error) {
@@ -121,8 +138,10 @@ func newPlan(cfg *Config, gitRoot string, allDirs []string) (*Plan, error) {
 
 	// Extract plan config fields
 	var shimConfig *ShimConfig
+	var logRuns int
 	if cfg.Plan != nil {
 		shimConfig = cfg.Plan.Shims
+		logRuns = cfg.Plan.LogRuns
 	}
 
 	if cfg.Auto == nil && len(cfg.Manual) == 0 {
@@ -133,9 +152,19 @@ func newPlan(cfg *Config, gitRoot string, allDirs []string) (*Plan, error) {
 			pathMappings:      make(map[string]pathInfo),
 			moduleDirectories: []string{},
 			shimConfig:        shimConfig,
+			logRuns:           logRuns,
 		}, nil
 	}
 
+	// Reject dependency cycles before walking, since walking follows Deps.
+	roots := slices.Clone(cfg.Manual)
+	if cfg.Auto != nil {
+		roots = append([]Runnable{cfg.Auto}, roots...)
+	}
+	if err := checkDependencyCycles(roots); err != nil {
+		return nil, err
+	}
+
 	collector := &taskCollector{
 		taskInstances: make([]taskInstance, 0),
 		seenTasks:     make(map[taskKey]int),
@@ -185,6 +214,7 @@ func newPlan(cfg *Config, gitRoot string, allDirs []string) (*Plan, error) {
 		pathMappings:      collector.pathMappings,
 		moduleDirectories: moduleDirectories,
 		shimConfig:        shimConfig,
+		logRuns:           logRuns,
 	}, nil
 }
 
@@ -196,6 +226,13 @@ type taskInstance struct {
 	flags    map[string]any // Pre-merged flag overrides for this task.
 	isManual bool           // Whether task is manual (from Config.Manual).
 	verbose  bool           // Force verbose mode (from WithVerbose).
+	retry    *retryPolicy   // Retry policy for failed executions (from WithRetry).
+	timeout  time.Duration  // Per-execution timeout (from WithTimeout, 0 = none).
+
+	parallelPaths bool        // Run in all resolved paths concurrently (from WithParallelPaths).
+	outputMode    OutputMode  // Output mode when run directly (from WithOutputMode, "" = inherit).
+	env           []envSource // Environment of commands, outermost scope first (from WithEnv and WithEnvFile).
+	locks         []string    // Sorted names of the locks held while executing (from WithLock).
 
 	// Execution context from path filter.
 	resolvedPaths []string // Directories where this task executes.
@@ -218,6 +255,12 @@ type taskCollector struct {
 	activeNameSuffix string           // Current name suffix from WithNameSuffix.
 	activeFlags      []flagOverride   // All flag overrides in current scope.
 	activeVerbose    bool             // Force verbose mode in current scope.
+	activeRetry      *retryPolicy     // Innermost retry policy in current scope.
+	activeTimeout    time.Duration    // Innermost timeout in current scope.
+	activeParallel   bool             // Run paths concurrently in current scope.
+	activeOutputMode OutputMode       // Innermost output mode in current scope.
+	activeEnv        []envSource      // Environment sources in current scope, outermost first.
+	activeLocks      []string         // Sorted lock names in current scope.
 	inManualSection  bool             // True when walking Config.Manual tasks.
 }
 
@@ -306,10 +349,11 @@ func (pc *taskCollector) walk(r Runnable) (Runnable, error) {
 		if v.Do != nil && v.Body != nil {
 			return nil, fmt.Errorf("task %q: Do and Body are mutually exclusive", v.Name)
 		}
-		if v.Body != nil {
-			if err := validateBodyComposition(v.Name, v.Body); err != nil {
-				return nil, err
-			}
+		if err := validateTaskComposition(v); err != nil {
+			return nil, err
+		}
+		if err := validateGlobs(v); err != nil {
+			return nil, err
 		}
 		// Build internal flagSet if not already built.
 		if v.flagSet == nil {
@@ -323,6 +367,14 @@ func (pc *taskCollector) walk(r Runnable) (Runnable, error) {
 			return nil, nil
 		}
 
+		// Dependencies run in the dependent's scope, so collect them as task
+		// instances of the current scope before the dependent itself.
+		for _, dep := range v.Deps {
+			if _, err := pc.walk(dep); err != nil {
+				return nil, err
+			}
+		}
+
 		// Build effective name with suffix (e.g., "py-test:3.9").
 		effectiveName := v.Name
 		if pc.activeNameSuffix != "" {
@@ -398,9 +450,35 @@ func (pc *taskCollector) walk(r Runnable) (Runnable, error) {
 						"use WithNameSuffix to create distinct variants",
 					effectiveName, instance.flags, mergedFlags)
 			}
+			if !reflect.DeepEqual(instance.retry, pc.activeRetry) {
+				return nil, fmt.Errorf(
+					"task %q: conflicting retry policies across scopes; "+
+						"use WithNameSuffix to create distinct variants",
+					effectiveName)
+			}
+			if instance.timeout != pc.activeTimeout {
+				return nil, fmt.Errorf(
+					"task %q: conflicting timeouts across scopes (%s vs %s); "+
+						"use WithNameSuffix to create distinct variants",
+					effectiveName, instance.timeout, pc.activeTimeout)
+			}
+			if !slices.Equal(instance.env, pc.activeEnv) {
+				return nil, fmt.Errorf(
+					"task %q: conflicting environments across scopes; "+
+						"use WithNameSuffix to create distinct variants",
+					effectiveName)
+			}
+			if !slices.Equal(instance.locks, pc.activeLocks) {
+				return nil, fmt.Errorf(
+					"task %q: conflicting locks across scopes (%v vs %v); "+
+						"use WithNameSuffix to create distinct variants",
+					effectiveName, instance.locks, pc.activeLocks)
+			}
 			instance.resolvedPaths = unionPaths(instance.resolvedPaths, finalPaths)
 			instance.isManual = instance.isManual && pc.inManualSection
 			instance.verbose = instance.verbose || v.Verbose || pc.activeVerbose
+			instance.parallelPaths = instance.parallelPaths || pc.activeParallel
+			instance.outputMode = cmp.Or(instance.outputMode, pc.activeOutputMode)
 		} else {
 			pc.seenTasks[key] = len(pc.taskInstances)
 			pc.taskInstances = append(pc.taskInstances, taskInstance{
@@ -409,6 +487,12 @@ func (pc *taskCollector) walk(r Runnable) (Runnable, error) {
 				flags:         mergedFlags,
 				isManual:      pc.inManualSection,
 				verbose:       v.Verbose || pc.activeVerbose,
+				retry:         pc.activeRetry,
+				timeout:       pc.activeTimeout,
+				parallelPaths: pc.activeParallel,
+				outputMode:    pc.activeOutputMode,
+				env:           pc.activeEnv,
+				locks:         pc.activeLocks,
 				resolvedPaths: finalPaths,
 			})
 		}
@@ -467,6 +551,25 @@ func (pc *taskCollector) walk(r Runnable) (Runnable, error) {
 		}
 		return &parallel{runnables: children}, nil
 
+	case *finally:
+		body, err := pc.walk(v.body)
+		if err != nil {
+			return nil, err
+		}
+		cleanup, err := pc.walk(v.cleanup)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case body == nil && cleanup == nil:
+			return nil, nil
+		case body == nil:
+			return cleanup, nil
+		case cleanup == nil:
+			return body, nil
+		}
+		return &finally{body: body, cleanup: cleanup}, nil
+
 	case *pathFilter:
 		// 1. Resolve paths for this occurrence based on current candidates. The
 		// user-owned pathFilter is not mutated; a cloned filter carrying this
@@ -485,6 +588,12 @@ func (pc *taskCollector) walk(r Runnable) (Runnable, error) {
 		prevNameSuffix := pc.activeNameSuffix
 		prevFlags := pc.activeFlags
 		prevVerbose := pc.activeVerbose
+		prevRetry := pc.activeRetry
+		prevTimeout := pc.activeTimeout
+		prevParallel := pc.activeParallel
+		prevOutputMode := pc.activeOutputMode
+		prevEnv := pc.activeEnv
+		prevLocks := pc.activeLocks
 
 		// Resolve type-based flag overrides against the inner runnable.
 		resolvedFlags, err := resolveTypedFlags(v.flags, v.inner)
@@ -500,6 +609,21 @@ func (pc *taskCollector) walk(r Runnable) (Runnable, error) {
 		pc.activeFlags = append(pc.activeFlags, resolvedFlags...)
 		pc.currentPath = v
 		pc.activeVerbose = pc.activeVerbose || v.verbose
+		pc.activeParallel = pc.activeParallel || v.parallelPaths
+		if v.retry != nil {
+			pc.activeRetry = v.retry
+		}
+		if v.timeout > 0 {
+			pc.activeTimeout = v.timeout
+		}
+		if v.outputMode != "" {
+			pc.activeOutputMode = v.outputMode
+		}
+		// Concat copies, so instances never share a backing array with sibling scopes.
+		pc.activeEnv = slices.Concat(pc.activeEnv, v.env)
+		if len(v.locks) > 0 {
+			pc.activeLocks = slices.Compact(slices.Sorted(slices.Values(slices.Concat(pc.activeLocks, v.locks))))
+		}
 
 		// Apply name suffix (cumulative: "3.9" + "foo" -> "3.9:foo").
 		if v.nameSuffix != "" {
@@ -525,6 +649,12 @@ func (pc *taskCollector) walk(r Runnable) (Runnable, error) {
 		pc.activeNameSuffix = prevNameSuffix
 		pc.activeFlags = prevFlags
 		pc.activeVerbose = prevVerbose
+		pc.activeRetry = prevRetry
+		pc.activeTimeout = prevTimeout
+		pc.activeParallel = prevParallel
+		pc.activeOutputMode = prevOutputMode
+		pc.activeEnv = prevEnv
+		pc.activeLocks = prevLocks
 
 		if plannedInner == nil {
 			return nil, nil
@@ -539,36 +669,135 @@ func (pc *taskCollector) walk(r Runnable) (Runnable, error) {
 	}
 }
 
-// validateBodyComposition rejects pathFilters anywhere inside a Task.Body. A
-// pathFilter (pk.WithPath/WithDetect/WithOptions) depends on plan-time path
-// resolution that only happens for the top-level composition tree; inside a Body
-// it stays unresolved and silently iterates zero paths at runtime. The check
-// recurses through Serial/Parallel and into nested Task bodies, which are equally
-// subject to the rule. Do and command runnables are valid leaves.
-func validateBodyComposition(taskName string, r Runnable) error {
+// validateTaskComposition rejects pathFilters anywhere inside a task's Body or
+// Deps. A pathFilter (pk.WithPath/WithDetect/WithOptions) depends on plan-time
+// path resolution that only happens for the top-level composition tree; inside
+// a Body or Deps it stays unresolved and silently iterates zero paths at runtime.
+// The check recurses through Serial/Parallel and into nested tasks, which are
+// equally subject to the rule. Do and command runnables are valid leaves.
+func validateTaskComposition(t *Task) error {
+	if t.Body != nil {
+		if err := validateComposition(t.Name, "Body", t.Body); err != nil {
+			return err
+		}
+	}
+	for _, dep := range t.Deps {
+		if err := validateComposition(t.Name, "Deps", dep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateComposition reports a pathFilter found in r, which was taken from the
+// given field of the named task.
+func validateComposition(taskName, field string, r Runnable) error {
 	switch v := r.(type) {
 	case *pathFilter:
 		return fmt.Errorf("task %q: pk.WithPath/WithDetect/WithOptions is not allowed "+
-			"inside Task.Body; apply path scopes at the composition level instead "+
-			"(Body may contain Do, Task, Serial, Parallel)", taskName)
+			"inside Task.%s; apply path scopes at the composition level instead "+
+			"(%s may contain Do, Task, Serial, Parallel, Finally)", taskName, field, field)
 	case *serial:
 		for _, child := range v.runnables {
-			if err := validateBodyComposition(taskName, child); err != nil {
+			if err := validateComposition(taskName, field, child); err != nil {
 				return err
 			}
 		}
 	case *parallel:
 		for _, child := range v.runnables {
-			if err := validateBodyComposition(taskName, child); err != nil {
+			if err := validateComposition(taskName, field, child); err != nil {
 				return err
 			}
 		}
+	case *finally:
+		if err := validateComposition(taskName, field, v.body); err != nil {
+			return err
+		}
+		return validateComposition(taskName, field, v.cleanup)
 	case *Task:
-		// A nested task's own Body is equally subject to the rule; report it under
-		// its own name.
-		if v.Body != nil {
-			return validateBodyComposition(v.Name, v.Body)
+		// A nested task's own Body and Deps are equally subject to the rule;
+		// report them under its own name.
+		return validateTaskComposition(v)
+	}
+	return nil
+}
+
+// checkDependencyCycles returns an error if any task reachable from roots
+// transitively depends on itself. Edges run from a task to the tasks in its
+// Deps and Body, since a dependency that waits on its own dependent would
+// never finish.
+func checkDependencyCycles(roots []Runnable) error {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[*Task]int)
+	var stack []*Task
+
+	var visit func(t *Task) error
+	visit = func(t *Task) error {
+		switch state[t] {
+		case visited:
+			return nil
+		case visiting:
+			start := slices.Index(stack, t)
+			names := make([]string, 0, len(stack)-start+1)
+			for _, s := range stack[start:] {
+				names = append(names, s.Name)
+			}
+			names = append(names, t.Name)
+			return fmt.Errorf("task %q: dependency cycle: %s", t.Name, strings.Join(names, " -> "))
+		}
+		state[t] = visiting
+		stack = append(stack, t)
+		children := slices.Clone(t.Deps)
+		if t.Body != nil {
+			children = append(children, t.Body)
+		}
+		for _, child := range children {
+			for _, next := range composedTasks(child) {
+				if err := visit(next); err != nil {
+					return err
+				}
+			}
 		}
+		stack = stack[:len(stack)-1]
+		state[t] = visited
+		return nil
+	}
+
+	for _, r := range roots {
+		for _, t := range composedTasks(r) {
+			if err := visit(t); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// composedTasks returns the tasks directly composed in r, without descending
+// into task bodies or dependencies.
+func composedTasks(r Runnable) []*Task {
+	switch v := r.(type) {
+	case *Task:
+		return []*Task{v}
+	case *serial:
+		var tasks []*Task
+		for _, child := range v.runnables {
+			tasks = append(tasks, composedTasks(child)...)
+		}
+		return tasks
+	case *parallel:
+		var tasks []*Task
+		for _, child := range v.runnables {
+			tasks = append(tasks, composedTasks(child)...)
+		}
+		return tasks
+	case *finally:
+		return append(composedTasks(v.body), composedTasks(v.cleanup)...)
+	case *pathFilter:
+		return composedTasks(v.inner)
 	}
 	return nil
 }
@@ -6,6 +6,7 @@ import (
 	"slices"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestPlan_Tasks(t *testing.T) {
@@ -649,6 +650,69 @@ func TestNewPlan_PathFilterInBodyRejected(t *testing.T) {
 	})
 }
 
+func TestNewPlan_Deps(t *testing.T) {
+	allDirs := []string{".", "svc-a"}
+	noop := func(_ context.Context) error { return nil }
+
+	t.Run("CollectsDepInstances", func(t *testing.T) {
+		generate := &Task{Name: "generate", Usage: "generate", Do: noop}
+		lint := &Task{Name: "lint", Usage: "lint", Deps: []Runnable{generate}, Do: noop}
+		plan, err := newPlan(&Config{Auto: lint}, "/tmp", allDirs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plan.taskInstanceByName("generate") == nil {
+			t.Error("expected dependency to be collected as a task instance")
+		}
+	})
+
+	t.Run("CycleRejected", func(t *testing.T) {
+		a := &Task{Name: "a", Usage: "a", Do: noop}
+		b := &Task{Name: "b", Usage: "b", Deps: []Runnable{a}, Do: noop}
+		a.Deps = []Runnable{b}
+		_, err := newPlan(&Config{Auto: a}, "/tmp", allDirs)
+		if err == nil {
+			t.Fatal("expected error for dependency cycle, got nil")
+		}
+		if !strings.Contains(err.Error(), "dependency cycle: a -> b -> a") {
+			t.Errorf("expected cycle path in error, got: %v", err)
+		}
+	})
+
+	t.Run("CycleThroughBodyRejected", func(t *testing.T) {
+		a := &Task{Name: "a", Usage: "a", Do: noop}
+		b := &Task{Name: "b", Usage: "b", Body: Serial(a)}
+		a.Deps = []Runnable{b}
+		_, err := newPlan(&Config{Auto: a}, "/tmp", allDirs)
+		if err == nil || !strings.Contains(err.Error(), "dependency cycle") {
+			t.Errorf("expected dependency cycle error, got: %v", err)
+		}
+	})
+
+	t.Run("PathFilterRejected", func(t *testing.T) {
+		generate := &Task{Name: "generate", Usage: "generate", Do: noop}
+		lint := &Task{
+			Name:  "lint",
+			Usage: "lint",
+			Deps:  []Runnable{WithOptions(generate, WithPath("svc-a"))},
+			Do:    noop,
+		}
+		_, err := newPlan(&Config{Auto: lint}, "/tmp", allDirs)
+		if err == nil || !strings.Contains(err.Error(), "not allowed inside Task.Deps") {
+			t.Errorf("expected 'not allowed inside Task.Deps' error, got: %v", err)
+		}
+	})
+
+	t.Run("SharedDepAllowed", func(t *testing.T) {
+		generate := &Task{Name: "generate", Usage: "generate", Do: noop}
+		lint := &Task{Name: "lint", Usage: "lint", Deps: []Runnable{generate}, Do: noop}
+		test := &Task{Name: "test", Usage: "test", Deps: []Runnable{generate}, Do: noop}
+		if _, err := newPlan(&Config{Auto: Parallel(lint, test)}, "/tmp", allDirs); err != nil {
+			t.Errorf("unexpected error for shared dependency: %v", err)
+		}
+	})
+}
+
 func TestNewPlan_InvalidRegexPattern(t *testing.T) {
 	allDirs := []string{".", "services", "pkg"}
 	task := &Task{Name: "test", Usage: "test", Do: func(_ context.Context) error { return nil }}
@@ -1202,6 +1266,42 @@ func TestNewPlan_TaskInMultipleScopes(t *testing.T) {
 		}
 	})
 
+	t.Run("ConflictingRetryPoliciesError", func(t *testing.T) {
+		task := newTask()
+		cfg := &Config{
+			Auto: Serial(
+				WithOptions(task, WithPath("svc-a"), WithRetry(2, 0)),
+				WithOptions(task, WithPath("svc-b")),
+			),
+		}
+
+		_, err := newPlan(cfg, "/tmp", allDirs)
+		if err == nil {
+			t.Fatal("expected error for conflicting retry policies across scopes")
+		}
+		if !strings.Contains(err.Error(), "conflicting retry policies") {
+			t.Errorf("expected 'conflicting retry policies' in error, got %q", err.Error())
+		}
+	})
+
+	t.Run("ConflictingTimeoutsError", func(t *testing.T) {
+		task := newTask()
+		cfg := &Config{
+			Auto: Serial(
+				WithOptions(task, WithPath("svc-a"), WithTimeout(time.Minute)),
+				WithOptions(task, WithPath("svc-b"), WithTimeout(time.Hour)),
+			),
+		}
+
+		_, err := newPlan(cfg, "/tmp", allDirs)
+		if err == nil {
+			t.Fatal("expected error for conflicting timeouts across scopes")
+		}
+		if !strings.Contains(err.Error(), "conflicting timeouts") {
+			t.Errorf("expected 'conflicting timeouts' in error, got %q", err.Error())
+		}
+	})
+
 	t.Run("OverrideVsNoOverrideError", func(t *testing.T) {
 		task := newTask()
 		task.Flags = multiScopeFlags{Mode: "default"}
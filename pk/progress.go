@@ -0,0 +1,327 @@
+package pk
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+	pkrun "github.com/fredrikaverpil/pocket/pk/run"
+	"golang.org/x/term"
+)
+
+const (
+	// progressInterval is how often the progress region is redrawn.
+	progressInterval = 100 * time.Millisecond
+	// maxProgressLines caps the region's height; further branches are counted.
+	maxProgressLines = 10
+)
+
+// progressFrames animate the spinner in front of each running branch.
+var progressFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// progressDisplay draws a live status region below the output on a terminal,
+// with one line per running parallel branch showing its task, path, elapsed
+// time, and last line of output. Branch output is still buffered and flushed
+// in full when the branch completes: writes to the terminal go through
+// [progressDisplay.writer], which clears the region, writes, and draws the
+// region again below the new output.
+type progressDisplay struct {
+	mu      sync.Mutex
+	out     io.Writer  // Terminal the region is drawn on.
+	width   func() int // Terminal width in columns.
+	lines   []*progressLine
+	drawn   int  // Number of region lines on screen.
+	midLine bool // The output so far does not end with a newline.
+	frame   int  // Spinner frame.
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// progressLine is the status of a running parallel branch. A branch running
+// nested parallel branches is represented by its children instead.
+type progressLine struct {
+	parent   *progressLine
+	children int       // Running nested branches.
+	label    string    // Task name and path, or the branch's lane before a task starts.
+	start    time.Time // When the current task started.
+	last     string    // Last complete, non-blank line of output.
+	tail     string    // Output after the last line break, such as a progress bar.
+}
+
+// newProgressDisplay returns a display drawing on out, whose width in columns
+// is reported by width.
+func newProgressDisplay(out io.Writer, width func() int) *progressDisplay {
+	return &progressDisplay{out: out, width: width}
+}
+
+// withTerminalProgress enables the progress display when the stdout of ctx is
+// a terminal, unless -s disables parallelism. It returns a function that stops
+// the display and clears the region; call it before printing final results.
+func withTerminalProgress(ctx context.Context) (context.Context, func()) {
+	out := pkrun.OutputFromContext(ctx)
+	if out == nil {
+		out = pkrun.StdOutput()
+	}
+	f, ok := out.Stdout.(*os.File)
+	if !ok || !pkrun.IsTerminal(f) || os.Getenv("TERM") == "dumb" || serialFromContext(ctx) {
+		return ctx, func() {}
+	}
+	d := newProgressDisplay(f, func() int {
+		width, _, err := term.GetSize(int(f.Fd()))
+		if err != nil || width <= 0 {
+			return 80
+		}
+		return width
+	})
+	d.start(progressInterval)
+	ctx = context.WithValue(ctx, ctxkey.Progress{}, d)
+	ctx = context.WithValue(ctx, ctxkey.Output{}, &pkrun.Output{
+		Stdout: d.writer(out.Stdout),
+		Stderr: d.writer(out.Stderr),
+	})
+	return ctx, d.close
+}
+
+func progressFromContext(ctx context.Context) *progressDisplay {
+	d, _ := ctx.Value(ctxkey.Progress{}).(*progressDisplay)
+	return d
+}
+
+func progressLineFromContext(ctx context.Context) *progressLine {
+	l, _ := ctx.Value(ctxkey.ProgressLine{}).(*progressLine)
+	return l
+}
+
+// withProgressBranch adds a line for a parallel branch to the display in ctx,
+// if any. The returned context carries the line, and its output also feeds the
+// line's last output. The returned function removes the line.
+func withProgressBranch(ctx context.Context, lane string) (context.Context, func()) {
+	d := progressFromContext(ctx)
+	if d == nil {
+		return ctx, func() {}
+	}
+	line := d.add(progressLineFromContext(ctx), lane)
+	w := &progressLineWriter{d: d, line: line}
+	if out := pkrun.OutputFromContext(ctx); out != nil {
+		ctx = context.WithValue(ctx, ctxkey.Output{}, &pkrun.Output{
+			Stdout: io.MultiWriter(out.Stdout, w),
+			Stderr: io.MultiWriter(out.Stderr, w),
+		})
+	}
+	ctx = context.WithValue(ctx, ctxkey.ProgressLine{}, line)
+	ctx = context.WithValue(ctx, ctxkey.LiveOutput{}, io.Writer(w))
+	return ctx, func() { d.remove(line) }
+}
+
+// setProgressTask shows effectiveName at the current path on the branch's
+// line, if ctx has one, and restarts its elapsed time.
+func setProgressTask(ctx context.Context, effectiveName string) {
+	d, line := progressFromContext(ctx), progressLineFromContext(ctx)
+	if d == nil || line == nil {
+		return
+	}
+	label := effectiveName
+	if path := pkrun.PathFromContext(ctx); path != "" && path != "." {
+		label += " [" + path + "]"
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	line.label, line.start, line.last, line.tail = label, time.Now(), "", ""
+}
+
+// start redraws the region every interval until close is called.
+func (d *progressDisplay) start(interval time.Duration) {
+	d.stop, d.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.stop:
+				return
+			case <-ticker.C:
+				d.mu.Lock()
+				d.frame++
+				d.redraw()
+				d.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// close stops redrawing and clears the region.
+func (d *progressDisplay) close() {
+	if d.stop != nil {
+		close(d.stop)
+		<-d.done
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clear()
+}
+
+// add adds a line for a branch nested in parent, which may be nil.
+func (d *progressDisplay) add(parent *progressLine, label string) *progressLine {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	line := &progressLine{parent: parent, label: label, start: time.Now()}
+	if parent != nil {
+		parent.children++
+	}
+	d.lines = append(d.lines, line)
+	return line
+}
+
+// remove removes the line of a completed branch.
+func (d *progressDisplay) remove(line *progressLine) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lines = slices.DeleteFunc(d.lines, func(l *progressLine) bool { return l == line })
+	if line.parent != nil {
+		line.parent.children--
+	}
+	d.redraw()
+}
+
+// writer returns a writer to w that keeps the region below the written output.
+func (d *progressDisplay) writer(w io.Writer) io.Writer {
+	return &progressWriter{d: d, w: w}
+}
+
+// clear erases the region. The caller holds d.mu.
+func (d *progressDisplay) clear() {
+	if d.drawn > 0 {
+		// Move to the start of the region's first line and erase to the end of the screen.
+		fmt.Fprintf(d.out, "\x1b[%dF\x1b[J", d.drawn)
+		d.drawn = 0
+	}
+}
+
+// redraw replaces the region with the current lines. The caller holds d.mu.
+func (d *progressDisplay) redraw() {
+	var visible []*progressLine
+	for _, line := range d.lines {
+		if line.children == 0 {
+			visible = append(visible, line)
+		}
+	}
+
+	var b strings.Builder
+	if d.drawn > 0 {
+		fmt.Fprintf(&b, "\x1b[%dF\x1b[J", d.drawn)
+	}
+	if len(visible) > 0 && d.midLine {
+		// Start the region below a partial line of output instead of after it.
+		b.WriteString("\n")
+		d.midLine = false
+	}
+	width := d.width()
+	now := time.Now()
+	drawn := 0
+	for i, line := range visible {
+		if i == maxProgressLines-1 && len(visible) > maxProgressLines {
+			b.WriteString(truncateRunes(fmt.Sprintf("  … and %d more", len(visible)-i), width-1) + "\n")
+			drawn++
+			break
+		}
+		b.WriteString(d.format(line, width, now) + "\n")
+		drawn++
+	}
+	if b.Len() > 0 {
+		_, _ = io.WriteString(d.out, b.String())
+	}
+	d.drawn = drawn
+}
+
+// format renders line to fit within width columns.
+func (d *progressDisplay) format(line *progressLine, width int, now time.Time) string {
+	frame := progressFrames[d.frame%len(progressFrames)]
+	s := fmt.Sprintf("  %s %s %s", frame, line.label, now.Sub(line.start).Truncate(time.Second))
+	if last := cmp.Or(progressSanitize(line.tail), line.last); last != "" {
+		s += "  " + last
+	}
+	return truncateRunes(s, width-1)
+}
+
+// progressWriter writes to the terminal beneath which the region is drawn.
+type progressWriter struct {
+	d *progressDisplay
+	w io.Writer
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	pw.d.mu.Lock()
+	defer pw.d.mu.Unlock()
+	pw.d.clear()
+	n, err := pw.w.Write(p)
+	if len(p) > 0 {
+		pw.d.midLine = p[len(p)-1] != '\n'
+	}
+	pw.d.redraw()
+	return n, err
+}
+
+// progressLineWriter records the last line of a branch's output on its line.
+type progressLineWriter struct {
+	d    *progressDisplay
+	line *progressLine
+}
+
+func (lw *progressLineWriter) Write(p []byte) (int, error) {
+	lw.d.mu.Lock()
+	defer lw.d.mu.Unlock()
+	text := lw.line.tail + string(p)
+	if i := strings.LastIndexAny(text, "\r\n"); i >= 0 {
+		lines := strings.FieldsFunc(text[:i], func(r rune) bool { return r == '\n' || r == '\r' })
+		for _, l := range slices.Backward(lines) {
+			if l = progressSanitize(l); l != "" {
+				lw.line.last = l
+				break
+			}
+		}
+		text = text[i+1:]
+	}
+	// Keep the end of a long partial line, which is what a progress bar updates.
+	const maxTail = 1024
+	if len(text) > maxTail {
+		text = text[len(text)-maxTail:]
+	}
+	lw.line.tail = text
+	return len(p), nil
+}
+
+// progressSanitize removes color codes and control characters from a line of
+// output, so it can be shown within a single terminal line.
+func progressSanitize(s string) string {
+	s = ansiEscape.ReplaceAllString(s, "")
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\t':
+			return ' '
+		case r < ' ' || r == 0x7f:
+			return -1
+		}
+		return r
+	}, s)
+	return strings.TrimSpace(s)
+}
+
+// truncateRunes shortens s to at most n runes, marking the cut with an ellipsis.
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if n <= 0 {
+		return ""
+	}
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
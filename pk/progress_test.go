@@ -0,0 +1,195 @@
+package pk
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+	pkrun "github.com/fredrikaverpil/pocket/pk/run"
+)
+
+// progressCtx returns a context with a progress display drawing on a buffer,
+// as withTerminalProgress sets up for a terminal, without the redraw ticker.
+func progressCtx(t *testing.T) (context.Context, *progressDisplay, *bytes.Buffer) {
+	t.Helper()
+	var term bytes.Buffer
+	d := newProgressDisplay(&term, func() int { return 80 })
+	ctx := context.WithValue(context.Background(), ctxkey.Progress{}, d)
+	ctx = context.WithValue(ctx, ctxkey.Output{}, &pkrun.Output{Stdout: d.writer(&term), Stderr: d.writer(&term)})
+	return ctx, d, &term
+}
+
+func TestProgressDisplay_ShowsTaskAndLastLine(t *testing.T) {
+	ctx, d, term := progressCtx(t)
+
+	branchCtx, end := withProgressBranch(ctx, "parallel branch 1/2")
+	setProgressTask(pkrun.ContextWithPath(branchCtx, "services/api"), "go-test")
+	pkrun.Printf(branchCtx, "=== RUN TestA\n\x1b[32mok\x1b[0m  pkg/a\n\n")
+
+	d.mu.Lock()
+	d.redraw()
+	d.mu.Unlock()
+	if got := term.String(); !strings.Contains(got, "go-test [services/api] 0s  ok  pkg/a\n") {
+		t.Errorf("expected task, path, elapsed time, and last line, got %q", got)
+	}
+
+	end()
+	term.Reset()
+	d.close()
+	if got := term.String(); got != "" {
+		t.Errorf("expected nothing drawn after the branch ended, got %q", got)
+	}
+}
+
+func TestProgressDisplay_WriteKeepsRegionBelowOutput(t *testing.T) {
+	ctx, d, term := progressCtx(t)
+
+	_, end := withProgressBranch(ctx, "branch")
+	defer end()
+	d.mu.Lock()
+	d.redraw()
+	d.mu.Unlock()
+
+	term.Reset()
+	pkrun.Printf(ctx, ":: flushed output\n")
+	got := term.String()
+	want := "\x1b[1F\x1b[J:: flushed output\n"
+	if !strings.HasPrefix(got, want) || !strings.Contains(got[len(want):], "branch") {
+		t.Errorf("expected region cleared, output written, and region redrawn, got %q", got)
+	}
+}
+
+func TestProgressDisplay_PartialLine(t *testing.T) {
+	ctx, d, term := progressCtx(t)
+
+	pkrun.Printf(ctx, "no newline")
+	_, end := withProgressBranch(ctx, "branch")
+	defer end()
+	d.mu.Lock()
+	d.redraw()
+	d.mu.Unlock()
+	if got := term.String(); !strings.HasPrefix(got, "no newline\n  ") {
+		t.Errorf("expected region to start on a new line, got %q", got)
+	}
+}
+
+func TestProgressDisplay_NestedBranchesReplaceParent(t *testing.T) {
+	ctx, d, _ := progressCtx(t)
+
+	outerCtx, endOuter := withProgressBranch(ctx, "outer")
+	defer endOuter()
+	_, endInner := withProgressBranch(outerCtx, "inner")
+
+	d.mu.Lock()
+	visible := 0
+	for _, line := range d.lines {
+		if line.children == 0 {
+			visible++
+			if line.label != "inner" {
+				t.Errorf("expected only the inner branch visible, got %q", line.label)
+			}
+		}
+	}
+	d.mu.Unlock()
+	if visible != 1 {
+		t.Errorf("expected 1 visible line, got %d", visible)
+	}
+
+	endInner()
+	if got := progressLineFromContext(outerCtx).children; got != 0 {
+		t.Errorf("expected outer branch to have no children after inner ended, got %d", got)
+	}
+}
+
+func TestProgressDisplay_CapsLines(t *testing.T) {
+	ctx, d, term := progressCtx(t)
+	for range maxProgressLines + 3 {
+		_, end := withProgressBranch(ctx, "branch")
+		defer end()
+	}
+	d.mu.Lock()
+	d.redraw()
+	d.mu.Unlock()
+	if got := strings.Count(term.String(), "\n"); got != maxProgressLines {
+		t.Errorf("expected %d lines, got %d", maxProgressLines, got)
+	}
+	if !strings.Contains(term.String(), "… and 4 more") {
+		t.Errorf("expected remaining branches to be counted, got %q", term.String())
+	}
+}
+
+func TestProgressLineWriter(t *testing.T) {
+	d := newProgressDisplay(&bytes.Buffer{}, func() int { return 80 })
+	line := d.add(nil, "branch")
+	w := &progressLineWriter{d: d, line: line}
+
+	_, _ = w.Write([]byte("first\nsec"))
+	_, _ = w.Write([]byte("ond\n\t\n"))
+	if line.last != "second" || line.tail != "" {
+		t.Errorf("expected last line %q and no tail, got %q and %q", "second", line.last, line.tail)
+	}
+
+	_, _ = w.Write([]byte("downloading 10%\rdownloading 50%"))
+	if line.last != "downloading 10%" || line.tail != "downloading 50%" {
+		t.Errorf("expected progress bar in tail, got last %q and tail %q", line.last, line.tail)
+	}
+}
+
+func TestParallel_ProgressFlushesFullOutput(t *testing.T) {
+	ctx, d, term := progressCtx(t)
+
+	branch := func(name string) Runnable {
+		return &Task{Name: name, Usage: name, Do: func(ctx context.Context) error {
+			if progressLineFromContext(ctx) == nil {
+				t.Errorf("expected %s to run with a progress line", name)
+			}
+			pkrun.Printf(ctx, "%s line 1\n%s line 2\n", name, name)
+			return nil
+		}}
+	}
+	if err := Parallel(branch("a"), branch("b")).run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(d.lines) != 0 {
+		t.Errorf("expected no lines after the parallel run, got %d", len(d.lines))
+	}
+	for _, name := range []string{"a", "b"} {
+		block := ":: " + name + "\n" + name + " line 1\n" + name + " line 2\n"
+		if !strings.Contains(term.String(), block) {
+			t.Errorf("expected %s output flushed as one block, got %q", name, term.String())
+		}
+	}
+}
+
+func TestWithTerminalProgress_NotTerminal(t *testing.T) {
+	ctx := context.WithValue(context.Background(), ctxkey.Output{}, testOutput())
+	got, stop := withTerminalProgress(ctx)
+	defer stop()
+	if progressFromContext(got) != nil {
+		t.Error("expected no progress display when stdout is not a terminal")
+	}
+}
+
+func TestExec_LiveOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	e2eSetup(t)
+
+	var out, live bytes.Buffer
+	ctx := context.WithValue(context.Background(), ctxkey.Output{}, &pkrun.Output{Stdout: &out, Stderr: &out})
+	ctx = context.WithValue(ctx, ctxkey.LiveOutput{}, &live)
+	if err := pkrun.Exec(ctx, "sh", "-c", "echo hello"); err != nil {
+		t.Fatal(err)
+	}
+	if live.String() != "hello\n" {
+		t.Errorf("expected live output %q, got %q", "hello\n", live.String())
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected non-verbose output to stay hidden, got %q", out.String())
+	}
+}
@@ -0,0 +1,88 @@
+package pk
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+)
+
+// maxRetryDelay caps the doubling wait between attempts. A longer backoff
+// is used as given.
+const maxRetryDelay = 5 * time.Minute
+
+// retryPolicy describes how often a failed task is re-run. See [WithRetry].
+type retryPolicy struct {
+	attempts int           // Total number of executions, including the first.
+	backoff  time.Duration // Delay before the second attempt; doubles afterwards, up to maxRetryDelay.
+}
+
+// delay returns the wait before the given attempt (2 or later). The delay
+// doubles up to maxRetryDelay, so it cannot overflow however many attempts
+// are made.
+func (r *retryPolicy) delay(attempt int) time.Duration {
+	d := r.backoff
+	for i := 2; i < attempt && d > 0 && d < maxRetryDelay; i++ {
+		d *= 2
+	}
+	return max(min(d, maxRetryDelay), r.backoff)
+}
+
+// String formats the policy for plan output, e.g. "3 attempts, backoff 1s".
+func (r *retryPolicy) String() string {
+	return fmt.Sprintf("%d attempts, backoff %s", r.attempts, r.backoff)
+}
+
+// executeWithRetry runs the task according to policy, printing the header
+// before each attempt, with status appended to the first. A nil policy runs
+// the task once. The timeout, if any, applies to each attempt separately.
+//
+// Attempts after the first force execution of composed subtasks, which were
+// marked done by the failed attempt. Non-final attempts run with a context
+// marking the failure as pending a retry, so the failures of their subtasks
+// are not reported unless the final attempt fails as well.
+func (t *Task) executeWithRetry(
+	ctx context.Context,
+	effectiveName string,
+	policy *retryPolicy,
+	timeout time.Duration,
+	status string,
+) error {
+	if policy == nil || policy.attempts <= 1 {
+		t.printHeader(ctx, effectiveName, status)
+		return t.executeWithTimeout(ctx, effectiveName, timeout)
+	}
+
+	var err error
+	for attempt := 1; attempt <= policy.attempts; attempt++ {
+		attemptCtx := ctx
+		if attempt > 1 {
+			timer := time.NewTimer(policy.delay(attempt))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return fmt.Errorf("task %q: retry cancelled after %d attempts: %w", effectiveName, attempt-1, err)
+			case <-timer.C:
+			}
+			attemptCtx = context.WithValue(attemptCtx, ctxkey.ForceRun{}, true)
+			t.printHeader(ctx, effectiveName, fmt.Sprintf(" (attempt %d/%d)", attempt, policy.attempts))
+		} else {
+			t.printHeader(ctx, effectiveName, status)
+		}
+		if attempt < policy.attempts {
+			attemptCtx = context.WithValue(attemptCtx, ctxkey.RetryPending{}, true)
+		}
+
+		if err = t.executeWithTimeout(attemptCtx, effectiveName, timeout); err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			if attempt == 1 {
+				return err
+			}
+			return fmt.Errorf("task %q failed after %d attempts: %w", effectiveName, attempt, err)
+		}
+	}
+	return fmt.Errorf("task %q failed after %d attempts: %w", effectiveName, policy.attempts, err)
+}
@@ -0,0 +1,162 @@
+package pk
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithRetry_SucceedsOnLaterAttempt(t *testing.T) {
+	var runs int
+	task := &Task{Name: "flaky", Usage: "flaky", Do: func(_ context.Context) error {
+		runs++
+		if runs < 2 {
+			return errors.New("transient")
+		}
+		return nil
+	}}
+	plan, err := newPlan(&Config{Auto: WithOptions(task, WithRetry(3, 0))}, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, out := integrationCtx(t, plan)
+	if err := plan.tree.run(ctx); err != nil {
+		t.Fatalf("expected retry to succeed, got %v", err)
+	}
+	if runs != 2 {
+		t.Errorf("expected 2 runs, got %d", runs)
+	}
+	if !strings.Contains(out.String(), ":: flaky (attempt 2/3)") {
+		t.Errorf("expected attempt header, got:\n%s", out.String())
+	}
+	if failures := executionTrackerFromContext(ctx).failed(); len(failures) != 0 {
+		t.Errorf("expected no recorded failures, got %v", failures)
+	}
+}
+
+func TestWithRetry_ReportsAttempts(t *testing.T) {
+	var runs int
+	task := &Task{Name: "broken", Usage: "broken", Do: func(_ context.Context) error {
+		runs++
+		return errors.New("boom")
+	}}
+	plan, err := newPlan(&Config{Auto: WithOptions(task, WithRetry(3, 0))}, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := integrationCtx(t, plan)
+	err = plan.tree.run(ctx)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if runs != 3 {
+		t.Errorf("expected 3 runs, got %d", runs)
+	}
+	if !strings.Contains(err.Error(), `task "broken" failed after 3 attempts: boom`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if failures := executionTrackerFromContext(ctx).failed(); len(failures) != 1 {
+		t.Errorf("expected only the final attempt to be recorded, got %v", failures)
+	}
+}
+
+func TestWithRetry_RerunsComposedSubtasks(t *testing.T) {
+	var subRuns, attempts int
+	sub := &Task{Name: "sub", Usage: "sub", Do: func(_ context.Context) error {
+		subRuns++
+		return nil
+	}}
+	check := Do(func(_ context.Context) error {
+		attempts++
+		if attempts < 2 {
+			return errors.New("transient")
+		}
+		return nil
+	})
+	task := &Task{Name: "parent", Usage: "parent", Body: Serial(sub, check)}
+	plan, err := newPlan(&Config{Auto: WithOptions(task, WithRetry(2, 0))}, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := integrationCtx(t, plan)
+	if err := plan.tree.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if subRuns != 2 {
+		t.Errorf("expected subtask to run on each attempt, ran %d times", subRuns)
+	}
+}
+
+func TestWithRetry_CancelledDuringBackoff(t *testing.T) {
+	var runs int
+	task := &Task{Name: "slow", Usage: "slow", Do: func(_ context.Context) error {
+		runs++
+		return errors.New("boom")
+	}}
+	plan, err := newPlan(&Config{Auto: WithOptions(task, WithRetry(3, time.Hour))}, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := integrationCtx(t, plan)
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = plan.tree.run(ctx)
+	if err == nil || !strings.Contains(err.Error(), "retry cancelled after 1 attempts") {
+		t.Fatalf("expected cancelled retry error, got %v", err)
+	}
+	if runs != 1 {
+		t.Errorf("expected 1 run, got %d", runs)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected cancellation to interrupt backoff, took %s", elapsed)
+	}
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	r := &retryPolicy{attempts: 4, backoff: time.Second}
+	for attempt, want := range map[int]time.Duration{2: time.Second, 3: 2 * time.Second, 4: 4 * time.Second} {
+		if got := r.delay(attempt); got != want {
+			t.Errorf("delay(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+}
+
+func TestRetryPolicy_DelayCapped(t *testing.T) {
+	r := &retryPolicy{attempts: math.MaxInt, backoff: time.Second}
+	for _, attempt := range []int{11, 64, 100, math.MaxInt} {
+		if got := r.delay(attempt); got != maxRetryDelay {
+			t.Errorf("delay(%d) = %s, want %s", attempt, got, maxRetryDelay)
+		}
+	}
+
+	// A backoff longer than the cap is kept.
+	r = &retryPolicy{attempts: 100, backoff: time.Hour}
+	if got := r.delay(100); got != time.Hour {
+		t.Errorf("delay(100) = %s, want 1h", got)
+	}
+}
+
+func TestWithRetry_InvalidArgsPanic(t *testing.T) {
+	for _, tt := range []struct {
+		attempts int
+		backoff  time.Duration
+	}{{0, 0}, {2, -time.Second}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("WithRetry(%d, %s): expected panic", tt.attempts, tt.backoff)
+				}
+			}()
+			WithRetry(tt.attempts, tt.backoff)
+		}()
+	}
+}
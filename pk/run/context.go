@@ -26,6 +26,22 @@ func Verbose(ctx context.Context) bool {
 	return false
 }
 
+// DryRun returns whether dry-run mode (-n) is enabled in the context.
+// In dry-run mode, [Exec] prints commands instead of running them. Tasks
+// that change files from Go code should check DryRun and skip the change.
+func DryRun(ctx context.Context) bool {
+	if v, ok := ctx.Value(ctxkey.DryRun{}).(bool); ok {
+		return v
+	}
+	return false
+}
+
+// streaming reports whether ctx belongs to a parallel branch that writes its
+// output line by line as it is produced (see pk.OutputStream).
+func streaming(ctx context.Context) bool {
+	return ctx.Value(ctxkey.StreamOutput{}) != nil
+}
+
 // ContextWithPath returns a new context with the given execution path.
 func ContextWithPath(ctx context.Context, path string) context.Context {
 	return context.WithValue(ctx, ctxkey.Path{}, path)
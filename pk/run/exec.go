@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -13,6 +15,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
 	"github.com/fredrikaverpil/pocket/pk/repopath"
 )
 
@@ -25,6 +28,13 @@ type WarningMarker interface {
 	MarkWarning()
 }
 
+// taskWarningMarker is implemented by pk's execution tracker, which
+// attributes warnings to the task and path in the context. [Exec] prefers it
+// over [WarningMarker].
+type taskWarningMarker interface {
+	MarkTaskWarning(ctx context.Context)
+}
+
 var (
 	colorEnvOnce sync.Once
 	colorEnvVars []string
@@ -69,11 +79,22 @@ func Exec(ctx context.Context, name string, args ...string) error {
 	if !filepath.IsAbs(path) {
 		targetDir = repopath.FromGitRoot(path)
 	}
-	env := ApplyEnvConfig(os.Environ(), EnvConfigFromContext(ctx))
+	envConfig := EnvConfigFromContext(ctx)
+	for key, value := range envConfig.Set {
+		if isSecretEnvName(key) {
+			RegisterSecret(value)
+		}
+	}
+	env := ApplyEnvConfig(os.Environ(), envConfig)
 	env = PrependBinToPath(env)
 
 	resolvedName := LookPathInEnv(name, env)
 
+	if DryRun(ctx) {
+		printDryRun(ctx, resolvedName, args, targetDir, envConfig)
+		return nil
+	}
+
 	cmd := exec.CommandContext(ctx, resolvedName, args...)
 	cmd.Dir = targetDir
 	cmd.Env = env
@@ -85,35 +106,135 @@ func Exec(ctx context.Context, name string, args ...string) error {
 	out := outputOrStd(ctx)
 
 	if Verbose(ctx) {
-		cmd.Stdout = out.Stdout
-		cmd.Stderr = out.Stderr
-		return cmd.Run()
+		// Without secrets to mask, the writers are passed through, so an
+		// *os.File reaches the command as is and it can detect a terminal.
+		if !hasSecrets() {
+			cmd.Stdout, cmd.Stderr = out.Stdout, out.Stderr
+			return runCmd(ctx, cmd)
+		}
+		stdout, stderr := &redactWriter{w: out.Stdout}, &redactWriter{w: out.Stderr}
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+		err := runCmd(ctx, cmd)
+		stdout.flush()
+		stderr.flush()
+		return err
 	}
 
 	var buf bytes.Buffer
-	cmd.Stdout = &buf
-	cmd.Stderr = &buf
+	var w io.Writer = &buf
+	live, hasLive := ctx.Value(ctxkey.LiveOutput{}).(io.Writer)
+	var liveRedacted *redactWriter
+	if hasLive {
+		// Show output as it is produced, e.g. in the progress display, while
+		// still only printing it on failure or notice.
+		liveRedacted = &redactWriter{w: live}
+		w = io.MultiWriter(w, liveRedacted)
+	}
+	var streamed *redactWriter
+	if streaming(ctx) {
+		// Streaming parallel branches print output line by line as it is
+		// produced. It is still buffered to detect notices.
+		streamed = &redactWriter{w: out.Stdout}
+		w = io.MultiWriter(w, streamed)
+	}
+	cmd.Stdout, cmd.Stderr = w, w
 
-	err := cmd.Run()
+	err := runCmd(ctx, cmd)
+	if liveRedacted != nil {
+		liveRedacted.flush()
+	}
+	if streamed != nil {
+		streamed.flush()
+	}
+	output := Redact(buf.String())
 	if err != nil {
-		return fmt.Errorf("%s %s: %w\n%s", name, strings.Join(args, " "), err, buf.String())
+		if streamed != nil {
+			return fmt.Errorf("%s: %w", Redact(commandLine(name, args)), err)
+		}
+		writeTaskLog(ctx, output)
+		return fmt.Errorf("%s: %w\n%s", Redact(commandLine(name, args)), err, output)
 	}
 
 	patterns := noticePatternsFromContext(ctx)
 	if patterns == nil {
 		patterns = DefaultNoticePatterns
 	}
-	if output := buf.String(); ContainsNotice(output, patterns) {
-		_, _ = out.Stderr.Write([]byte(output))
-		if tracker := trackerFromContext(ctx); tracker != nil {
-			if wm, ok := tracker.(WarningMarker); ok {
-				wm.MarkWarning()
-			}
+	if ContainsNotice(output, patterns) {
+		if streamed == nil {
+			_, _ = io.WriteString(out.Stderr, output)
+		}
+		switch wm := trackerFromContext(ctx).(type) {
+		case taskWarningMarker:
+			wm.MarkTaskWarning(ctx)
+		case WarningMarker:
+			wm.MarkWarning()
 		}
+		return nil
+	}
+	if streamed == nil {
+		writeTaskLog(ctx, output)
 	}
 	return nil
 }
 
+// commandLine returns name and args as shown in errors.
+func commandLine(name string, args []string) string {
+	return strings.TrimSuffix(name+" "+strings.Join(args, " "), " ")
+}
+
+// writeTaskLog writes command output that is not printed to the running
+// task's log file, if any. Printed output reaches the log through the task's
+// output writers.
+func writeTaskLog(ctx context.Context, output string) {
+	if log, ok := ctx.Value(ctxkey.TaskLog{}).(io.Writer); ok && output != "" {
+		_, _ = io.WriteString(log, output)
+	}
+}
+
+// runCmd runs cmd, or hands it to the exec hook in ctx. The hook lets
+// package pktest record and script commands instead of running them.
+func runCmd(ctx context.Context, cmd *exec.Cmd) error {
+	if hook, ok := ctx.Value(ctxkey.ExecHook{}).(func(context.Context, *exec.Cmd) error); ok {
+		return hook(ctx, cmd)
+	}
+	return cmd.Run()
+}
+
+// printDryRun prints the command that [Exec] would run, with its working
+// directory and environment overrides.
+func printDryRun(ctx context.Context, name string, args []string, dir string, cfg EnvConfig) {
+	quoted := make([]string, 0, len(args)+1)
+	for _, arg := range append([]string{name}, args...) {
+		quoted = append(quoted, quoteArg(arg))
+	}
+	Printf(ctx, "  [dry-run] %s\n", strings.Join(quoted, " "))
+	Printf(ctx, "            dir: %s\n", dir)
+	for _, key := range slices.Sorted(maps.Keys(cfg.Set)) {
+		Printf(ctx, "            env: %s=%s\n", key, quoteArg(cfg.Set[key]))
+	}
+	for _, prefix := range cfg.Filter {
+		Printf(ctx, "            env: unset %s*\n", prefix)
+	}
+}
+
+// quoteArg quotes arg for display in a POSIX shell, if needed.
+func quoteArg(arg string) string {
+	if arg != "" && !strings.ContainsFunc(arg, needsQuote) {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// needsQuote reports whether r has a special meaning in a POSIX shell.
+func needsQuote(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return false
+	default:
+		return !strings.ContainsRune("@%+=:,./_-", r)
+	}
+}
+
 // RegisterPATH registers a directory to be added to PATH for all [Exec] calls.
 func RegisterPATH(dir string) {
 	extraPATHDirsMu.Lock()
@@ -30,20 +30,21 @@ func IsTerminal(f *os.File) bool {
 	return term.IsTerminal(int(f.Fd()))
 }
 
-// Printf formats and writes to the context's stdout.
+// Printf formats and writes to the context's stdout. Like [Println] and
+// [Errorf], it masks secrets; see [RegisterSecret].
 func Printf(ctx context.Context, format string, a ...any) {
 	out := outputOrStd(ctx)
-	fmt.Fprintf(out.Stdout, format, a...)
+	_, _ = io.WriteString(out.Stdout, Redact(fmt.Sprintf(format, a...)))
 }
 
 // Println writes to the context's stdout, appending a newline.
 func Println(ctx context.Context, a ...any) {
 	out := outputOrStd(ctx)
-	fmt.Fprintln(out.Stdout, a...)
+	_, _ = io.WriteString(out.Stdout, Redact(fmt.Sprintln(a...)))
 }
 
 // Errorf formats and writes to the context's stderr.
 func Errorf(ctx context.Context, format string, a ...any) {
 	out := outputOrStd(ctx)
-	fmt.Fprintf(out.Stderr, format, a...)
+	_, _ = io.WriteString(out.Stderr, Redact(fmt.Sprintf(format, a...)))
 }
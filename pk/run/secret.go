@@ -0,0 +1,171 @@
+package run
+
+import (
+	"bytes"
+	"cmp"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// RedactedSecret replaces secret values in output and errors.
+const RedactedSecret = "***"
+
+// minSecretLen is the length below which values are not treated as secrets,
+// since masking them would garble unrelated output.
+const minSecretLen = 4
+
+// SecretEnvPatterns are the patterns, in [path.Match] syntax, of environment
+// variable names whose values are masked like values passed to
+// [RegisterSecret]. Names are matched in upper case. This covers variables of
+// the process environment and those set for [Exec] with [ContextWithEnv].
+var SecretEnvPatterns = []string{"*_TOKEN", "*_SECRET*", "*PASSWORD*", "*_API_KEY", "*_PRIVATE_KEY"}
+
+var (
+	secretsMu      sync.RWMutex
+	secretValues   []string          // Registered secrets, longest first.
+	secretReplacer *strings.Replacer // Masks secretValues; nil without secrets.
+	secretEnvOnce  sync.Once
+)
+
+// RegisterSecret masks value wherever Pocket writes output or errors for the
+// rest of the process: [Printf], [Println], [Errorf], the output of [Exec]
+// commands and the errors it returns, buffered parallel output, task logs,
+// and the run summary and reports. Occurrences are replaced with "***".
+// Values shorter than 4 characters are ignored.
+//
+// Output written directly to the writers of [OutputFromContext] is masked
+// when it is buffered for parallel execution, but not otherwise.
+func RegisterSecret(value string) {
+	if len(value) < minSecretLen {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	if slices.Contains(secretValues, value) {
+		return
+	}
+	secretValues = append(secretValues, value)
+	// Longer secrets first, so a secret containing another is masked whole.
+	slices.SortStableFunc(secretValues, func(a, b string) int { return cmp.Compare(len(b), len(a)) })
+	pairs := make([]string, 0, 2*len(secretValues))
+	for _, v := range secretValues {
+		pairs = append(pairs, v, RedactedSecret)
+	}
+	secretReplacer = strings.NewReplacer(pairs...)
+}
+
+// Redact returns s with registered secrets and the values of secret
+// environment variables replaced with "***". See [RegisterSecret].
+func Redact(s string) string {
+	loadEnvSecrets()
+	secretsMu.RLock()
+	r := secretReplacer
+	secretsMu.RUnlock()
+	if r == nil {
+		return s
+	}
+	return r.Replace(s)
+}
+
+// hasSecrets reports whether any secret is registered, including those of
+// the process environment.
+func hasSecrets() bool {
+	loadEnvSecrets()
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	return len(secretValues) > 0
+}
+
+// loadEnvSecrets registers the secrets of the process environment once.
+func loadEnvSecrets() {
+	secretEnvOnce.Do(func() { registerEnvSecrets(os.Environ()) })
+}
+
+// registerEnvSecrets registers the values of environ entries whose names
+// match [SecretEnvPatterns].
+func registerEnvSecrets(environ []string) {
+	for _, entry := range environ {
+		if key, value, ok := strings.Cut(entry, "="); ok && isSecretEnvName(key) {
+			RegisterSecret(value)
+		}
+	}
+}
+
+// isSecretEnvName reports whether the environment variable name matches
+// [SecretEnvPatterns].
+func isSecretEnvName(name string) bool {
+	name = strings.ToUpper(name)
+	for _, pattern := range SecretEnvPatterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// redactCut returns how much of data can be masked and written now: all of
+// it, except an end that a later write may complete into a secret, along with
+// any secret overlapping that end.
+func redactCut(data []byte) int {
+	loadEnvSecrets()
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	cut := len(data)
+	for _, secret := range secretValues {
+		for n := min(len(secret)-1, len(data)); n > len(data)-cut; n-- {
+			if bytes.HasSuffix(data, []byte(secret[:n])) {
+				cut = len(data) - n
+				break
+			}
+		}
+	}
+	for moved := cut < len(data); moved; {
+		moved = false
+		for _, secret := range secretValues {
+			for i := 0; ; {
+				j := bytes.Index(data[i:], []byte(secret))
+				if j < 0 {
+					break
+				}
+				if start := i + j; start < cut && start+len(secret) > cut {
+					cut, moved = start, true
+				}
+				i += j + 1
+			}
+		}
+	}
+	return cut
+}
+
+// redactWriter masks secrets in the output written to w. Output ending with
+// the start of a secret is held back until the next write or flush, so a
+// secret split across writes is masked too. It is not safe for concurrent use.
+type redactWriter struct {
+	w       io.Writer
+	pending []byte
+}
+
+func (rw *redactWriter) Write(p []byte) (int, error) {
+	data := append(rw.pending, p...)
+	cut := redactCut(data)
+	rw.pending = slices.Clone(data[cut:])
+	if cut == 0 {
+		return len(p), nil
+	}
+	if _, err := io.WriteString(rw.w, Redact(string(data[:cut]))); err != nil {
+		return len(p), err
+	}
+	return len(p), nil
+}
+
+// flush writes the output held back by Write.
+func (rw *redactWriter) flush() {
+	if len(rw.pending) > 0 {
+		_, _ = io.WriteString(rw.w, Redact(string(rw.pending)))
+		rw.pending = nil
+	}
+}
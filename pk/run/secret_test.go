@@ -0,0 +1,135 @@
+package run
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+	"gotest.tools/v3/assert"
+)
+
+func TestRedact(t *testing.T) {
+	RegisterSecret("redact-test-secret")
+	RegisterSecret("redact-test-secret-longer")
+	RegisterSecret("abc") // Too short to be masked.
+
+	tests := []struct {
+		name, input, want string
+	}{
+		{"no secret", "plain output", "plain output"},
+		{"secret", "token=redact-test-secret\n", "token=***\n"},
+		{"longer secret containing another", "redact-test-secret-longer", "***"},
+		{"repeated", "redact-test-secret redact-test-secret", "*** ***"},
+		{"short value", "abc", "abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, Redact(tt.input), tt.want)
+		})
+	}
+}
+
+func TestIsSecretEnvName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"GITHUB_TOKEN":    true,
+		"github_token":    true,
+		"CLIENT_SECRET":   true,
+		"AWS_SECRET_KEY":  true,
+		"DB_PASSWORD":     true,
+		"OPENAI_API_KEY":  true,
+		"SSH_PRIVATE_KEY": true,
+		"HOME":            false,
+		"TOKENIZER":       false,
+		"API_KEY_ID":      false,
+	} {
+		assert.Equal(t, isSecretEnvName(name), want, name)
+	}
+}
+
+func TestRegisterEnvSecrets(t *testing.T) {
+	registerEnvSecrets([]string{"ENV_TEST_TOKEN=env-test-token-value", "ENV_TEST_NAME=env-test-plain-value"})
+	assert.Equal(t, Redact("env-test-token-value"), RedactedSecret)
+	assert.Equal(t, Redact("env-test-plain-value"), "env-test-plain-value")
+}
+
+func TestRedactWriter(t *testing.T) {
+	RegisterSecret("split-secret-value")
+	RegisterSecret("split-secret-value-long")
+
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{"whole", []string{"a split-secret-value b"}, "a *** b"},
+		{"split", []string{"a split-sec", "ret-value b"}, "a *** b"},
+		{"split byte by byte", strings.Split("x split-secret-value y", ""), "x *** y"},
+		{"longer secret completed later", []string{"split-secret-value", "-long!"}, "***!"},
+		{"prefix never completed", []string{"a split-sec"}, "a split-sec"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			rw := &redactWriter{w: &buf}
+			for _, w := range tt.writes {
+				n, err := rw.Write([]byte(w))
+				assert.NilError(t, err)
+				assert.Equal(t, n, len(w))
+			}
+			rw.flush()
+			assert.Equal(t, buf.String(), tt.want)
+		})
+	}
+}
+
+func TestExec_RedactsSecrets(t *testing.T) {
+	const secret = "exec-test-token-value"
+	var buf bytes.Buffer
+	ctx := context.WithValue(context.Background(), ctxkey.Output{}, &Output{Stdout: &buf, Stderr: &buf})
+	ctx = ContextWithPath(ctx, t.TempDir())
+	ctx = ContextWithEnv(ctx, "EXEC_TEST_TOKEN="+secret)
+
+	err := Exec(ctx, "sh", "-c", "echo token is $EXEC_TEST_TOKEN; exit 1")
+	assert.ErrorContains(t, err, "token is ***")
+	assert.Assert(t, !strings.Contains(err.Error(), secret), err.Error())
+
+	err = Exec(ctx, "sh", "-c", "echo warning: "+secret)
+	assert.NilError(t, err)
+	assert.Equal(t, buf.String(), "warning: ***\n")
+}
+
+func TestExec_VerbosePassesWritersWithoutSecrets(t *testing.T) {
+	// Start without secrets, and restore those of other tests afterwards.
+	loadEnvSecrets()
+	secretsMu.Lock()
+	values, replacer := secretValues, secretReplacer
+	secretValues, secretReplacer = nil, nil
+	secretsMu.Unlock()
+	t.Cleanup(func() {
+		secretsMu.Lock()
+		secretValues, secretReplacer = values, replacer
+		secretsMu.Unlock()
+	})
+
+	var buf bytes.Buffer
+	var stdout io.Writer
+	ctx := context.WithValue(context.Background(), ctxkey.Output{}, &Output{Stdout: &buf, Stderr: &buf})
+	ctx = context.WithValue(ctx, ctxkey.Verbose{}, true)
+	ctx = context.WithValue(ctx, ctxkey.ExecHook{}, func(_ context.Context, cmd *exec.Cmd) error {
+		stdout = cmd.Stdout
+		return nil
+	})
+	ctx = ContextWithPath(ctx, t.TempDir())
+
+	assert.NilError(t, Exec(ctx, "true"))
+	assert.Equal(t, stdout, io.Writer(&buf), "expected the output writer to reach the command unwrapped")
+
+	RegisterSecret("verbose-test-secret")
+	assert.NilError(t, Exec(ctx, "true"))
+	_, masked := stdout.(*redactWriter)
+	assert.Assert(t, masked, "expected output to be masked once a secret is registered")
+}
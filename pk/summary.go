@@ -0,0 +1,199 @@
+package pk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	pkrun "github.com/fredrikaverpil/pocket/pk/run"
+)
+
+// Summary formats for the --summary flag.
+const (
+	summaryText = "text"
+	summaryJSON = "json"
+	summaryOff  = "off"
+)
+
+// taskStatus is the outcome of a task at a path in the run summary.
+type taskStatus string
+
+const (
+	statusOK           taskStatus = "ok"
+	statusFailed       taskStatus = "failed"
+	statusCancelled    taskStatus = "cancelled"
+	statusCached       taskStatus = "cached"
+	statusSkippedDedup taskStatus = "skipped-dedup" // Already ran in this invocation.
+	statusSkippedScope taskStatus = "skipped-scope" // Excluded from the path, TASK_SCOPE, or --since.
+	statusManual       taskStatus = "manual"        // Manual task, not run by bare ./pok.
+)
+
+// skipped reports whether the status means the task did not execute.
+func (s taskStatus) skipped() bool {
+	switch s {
+	case statusSkippedDedup, statusSkippedScope, statusManual:
+		return true
+	}
+	return false
+}
+
+// taskRecord is one row of the run summary.
+type taskRecord struct {
+	Task     string
+	Path     string
+	Status   taskStatus
+	Duration time.Duration // Wall-clock execution time, zero when skipped.
+	Error    string        // Error text of a failed or cancelled execution.
+	Output   string        // Output of a failed or cancelled execution, when captured.
+}
+
+// recordSkip records that the named task did not execute at the current path.
+func recordSkip(ctx context.Context, effectiveName string, status taskStatus) {
+	recordTask(ctx, taskRecord{Task: effectiveName, Status: status})
+}
+
+// recordExecution records the result of an execution of the named task at the
+// current path that started at start and returned err. The captured output,
+// if any, is kept for failed executions only.
+func recordExecution(ctx context.Context, effectiveName string, start time.Time, err error, captured *capturedOutput) {
+	r := taskRecord{Task: effectiveName, Status: statusForError(ctx, err), Duration: time.Since(start)}
+	if err != nil {
+		r.Error = pkrun.Redact(err.Error())
+		if captured != nil {
+			r.Output = captured.String()
+		}
+	}
+	recordTask(ctx, r)
+}
+
+// recordTask adds r at the current path to the tracker, if any.
+func recordTask(ctx context.Context, r taskRecord) {
+	tracker := executionTrackerFromContext(ctx)
+	if tracker == nil {
+		return
+	}
+	r.Path = pkrun.PathFromContext(ctx)
+	if r.Path == "" {
+		r.Path = "."
+	}
+	tracker.record(r)
+}
+
+// statusForError returns the summary status of an execution that returned err.
+func statusForError(ctx context.Context, err error) taskStatus {
+	switch {
+	case err == nil:
+		return statusOK
+	case ctx.Err() != nil:
+		return statusCancelled
+	default:
+		return statusFailed
+	}
+}
+
+// recordManualTasks adds summary rows for manual tasks, which bare ./pok does
+// not run.
+func recordManualTasks(tracker *executionTracker, p *Plan) {
+	taskScope := taskScopeFromEnv()
+	for _, instance := range p.taskInstances {
+		if !instance.isManual || instance.task.Hidden {
+			continue
+		}
+		paths := instance.resolvedPaths
+		if len(paths) == 0 {
+			paths = []string{"."}
+		}
+		for _, path := range paths {
+			if taskScope == "" || path == taskScope {
+				tracker.record(taskRecord{Task: instance.name, Path: path, Status: statusManual})
+			}
+		}
+	}
+}
+
+// summaryRecords returns the records shown in the summary. Builtins such as
+// the git-diff post-action are left out.
+func summaryRecords(tracker *executionTracker) []taskRecord {
+	var records []taskRecord
+	for _, r := range tracker.taskRecords() {
+		if !isBuiltinName(r.Task) {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// writeSummaryText writes the summary as an aligned table.
+func writeSummaryText(w io.Writer, records []taskRecord, total time.Duration) {
+	if len(records) == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Summary:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  TASK\tPATH\tSTATUS\tDURATION")
+	for _, r := range records {
+		duration := "-"
+		if !r.Status.skipped() && r.Status != statusCached {
+			duration = formatDuration(r.Duration)
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", r.Task, r.Path, r.Status, duration)
+	}
+	_ = tw.Flush()
+
+	counts := make(map[taskStatus]int)
+	for _, r := range records {
+		counts[r.Status]++
+	}
+	var parts []string
+	for _, s := range []taskStatus{
+		statusOK, statusFailed, statusCancelled, statusCached,
+		statusSkippedDedup, statusSkippedScope, statusManual,
+	} {
+		if counts[s] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[s], s))
+		}
+	}
+	fmt.Fprintf(w, "  %s in %s\n", strings.Join(parts, ", "), formatDuration(total))
+}
+
+// jsonSummary is the --summary=json document.
+type jsonSummary struct {
+	DurationMS int64             `json:"duration_ms"`
+	Tasks      []jsonSummaryTask `json:"tasks"`
+}
+
+type jsonSummaryTask struct {
+	Task       string     `json:"task"`
+	Path       string     `json:"path"`
+	Status     taskStatus `json:"status"`
+	DurationMS int64      `json:"duration_ms"`
+}
+
+// writeSummaryJSON writes the summary as an indented JSON document.
+func writeSummaryJSON(w io.Writer, records []taskRecord, total time.Duration) error {
+	doc := jsonSummary{DurationMS: total.Milliseconds(), Tasks: make([]jsonSummaryTask, 0, len(records))}
+	for _, r := range records {
+		doc.Tasks = append(doc.Tasks, jsonSummaryTask{
+			Task:       r.Task,
+			Path:       r.Path,
+			Status:     r.Status,
+			DurationMS: r.Duration.Milliseconds(),
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// formatDuration rounds d for display.
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(100 * time.Millisecond).String()
+}
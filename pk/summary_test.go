@@ -0,0 +1,192 @@
+package pk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+	pkrun "github.com/fredrikaverpil/pocket/pk/run"
+)
+
+func TestExecutionTracker_Record(t *testing.T) {
+	tracker := newExecutionTracker()
+	tracker.record(taskRecord{Task: "lint", Path: "a", Status: statusOK, Duration: time.Second})
+	tracker.record(taskRecord{Task: "lint", Path: "a", Status: statusSkippedDedup})
+	tracker.record(taskRecord{Task: "test", Path: "a", Status: statusSkippedDedup})
+	tracker.record(taskRecord{Task: "test", Path: "a", Status: statusFailed, Duration: 2 * time.Second})
+	tracker.record(taskRecord{Task: "lint", Path: "a", Status: statusOK, Duration: time.Second})
+
+	got := tracker.taskRecords()
+	want := []taskRecord{
+		{Task: "lint", Path: "a", Status: statusOK, Duration: 2 * time.Second},
+		{Task: "test", Path: "a", Status: statusFailed, Duration: 2 * time.Second},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d records, got %+v", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("record %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+}
+
+func TestSummary_Statuses(t *testing.T) {
+	noop := func(_ context.Context) error { return nil }
+	install := &Task{Name: "install", Usage: "install", Global: true, Hidden: true, Do: noop}
+	lint := &Task{Name: "lint", Usage: "lint", Deps: []Runnable{install}, Do: noop}
+	test := &Task{Name: "test", Usage: "test", Do: noop}
+	fail := &Task{Name: "fail", Usage: "fail", Do: func(_ context.Context) error { return errors.New("boom") }}
+	release := &Task{Name: "release", Usage: "release", Do: noop}
+
+	cfg := &Config{
+		Auto: Serial(
+			WithOptions(Serial(lint, test), WithPath("svc-a", "svc-b"), WithSkipTask(test, "svc-b")),
+			fail,
+		),
+		Manual: []Runnable{release},
+	}
+	plan, err := newPlan(cfg, "/tmp", []string{".", "svc-a", "svc-b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := integrationCtx(t, plan)
+	ctx = context.WithValue(ctx, ctxkey.AutoExec{}, true)
+	if err := plan.tree.run(ctx); err == nil {
+		t.Fatal("expected error")
+	}
+	tracker := executionTrackerFromContext(ctx)
+	recordManualTasks(tracker, plan)
+
+	got := make(map[string]taskStatus)
+	for _, r := range summaryRecords(tracker) {
+		got[r.Task+" "+r.Path] = r.Status
+	}
+	want := map[string]taskStatus{
+		"install svc-a": statusOK,
+		"install svc-b": statusSkippedDedup,
+		"lint svc-a":    statusOK,
+		"lint svc-b":    statusOK,
+		"test svc-a":    statusOK,
+		"test svc-b":    statusSkippedScope,
+		"fail .":        statusFailed,
+		"release .":     statusManual,
+	}
+	if len(got) != len(want) {
+		t.Errorf("expected %d records, got %v", len(want), got)
+	}
+	for key, status := range want {
+		if got[key] != status {
+			t.Errorf("%s: expected %q, got %q", key, status, got[key])
+		}
+	}
+}
+
+func TestSummary_RedactsErrors(t *testing.T) {
+	pkrun.RegisterSecret("summary-test-secret")
+	fail := &Task{Name: "fail", Usage: "fail", Do: func(_ context.Context) error {
+		return errors.New("login with summary-test-secret failed")
+	}}
+	plan, err := newPlan(&Config{Auto: fail}, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := integrationCtx(t, plan)
+	if err := plan.tree.run(ctx); err == nil {
+		t.Fatal("expected error")
+	}
+	records := summaryRecords(executionTrackerFromContext(ctx))
+	if len(records) != 1 || records[0].Error != "login with *** failed" {
+		t.Errorf("expected the secret to be masked, got %+v", records)
+	}
+}
+
+func TestSummary_Cached(t *testing.T) {
+	root := e2eSetup(t)
+	writeFile(t, filepath.Join(root, "main.go"), "package main")
+
+	task := &Task{Name: "lint", Usage: "lint", Inputs: []string{"*.go"}, Do: func(_ context.Context) error { return nil }}
+	plan, err := newPlan(&Config{Auto: task}, root, []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []taskStatus{statusOK, statusCached} {
+		ctx, _ := integrationCtx(t, plan)
+		if err := task.run(ctx); err != nil {
+			t.Fatal(err)
+		}
+		records := executionTrackerFromContext(ctx).taskRecords()
+		if len(records) != 1 || records[0].Status != want {
+			t.Errorf("expected one %q record, got %+v", want, records)
+		}
+	}
+}
+
+func TestWriteSummaryText(t *testing.T) {
+	records := []taskRecord{
+		{Task: "go-lint", Path: "services/api", Status: statusOK, Duration: 1234 * time.Millisecond},
+		{Task: "go-test", Path: ".", Status: statusFailed, Duration: 42 * time.Millisecond},
+		{Task: "go-pprof", Path: ".", Status: statusManual},
+	}
+	var buf bytes.Buffer
+	writeSummaryText(&buf, records, 2*time.Second)
+	out := buf.String()
+
+	for _, want := range []string{
+		"Summary:",
+		"TASK      PATH          STATUS  DURATION",
+		"go-lint   services/api  ok      1.2s",
+		"go-test   .             failed  42ms",
+		"go-pprof  .             manual  -",
+		"1 ok, 1 failed, 1 manual in 2s",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in output, got:\n%s", want, out)
+		}
+	}
+
+	buf.Reset()
+	writeSummaryText(&buf, nil, time.Second)
+	if buf.Len() != 0 {
+		t.Errorf("expected no output without records, got %q", buf.String())
+	}
+}
+
+func TestWriteSummaryJSON(t *testing.T) {
+	records := []taskRecord{
+		{Task: "go-lint", Path: "services/api", Status: statusOK, Duration: 1234 * time.Millisecond},
+		{Task: "go-test", Path: ".", Status: statusSkippedDedup},
+	}
+	var buf bytes.Buffer
+	if err := writeSummaryJSON(&buf, records, 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc jsonSummary
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+	if doc.DurationMS != 2000 {
+		t.Errorf("expected duration_ms 2000, got %d", doc.DurationMS)
+	}
+	want := []jsonSummaryTask{
+		{Task: "go-lint", Path: "services/api", Status: statusOK, DurationMS: 1234},
+		{Task: "go-test", Path: ".", Status: statusSkippedDedup, DurationMS: 0},
+	}
+	if len(doc.Tasks) != len(want) {
+		t.Fatalf("expected %d tasks, got %+v", len(want), doc.Tasks)
+	}
+	for i := range want {
+		if doc.Tasks[i] != want[i] {
+			t.Errorf("task %d: expected %+v, got %+v", i, want[i], doc.Tasks[i])
+		}
+	}
+}
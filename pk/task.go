@@ -1,11 +1,14 @@
 package pk
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"maps"
 	"slices"
+	"time"
 
 	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
 	pkrun "github.com/fredrikaverpil/pocket/pk/run"
@@ -57,6 +60,21 @@ type Task struct {
 	// Verbose forces verbose (streamed) output for this task,
 	// regardless of the -v CLI flag. Can also be set via [WithVerbose].
 	Verbose bool
+	// Deps lists runnables that must complete before this task runs.
+	// Dependencies run concurrently with each other and are shared with the
+	// rest of the execution tree: a dependency that has already run, or is
+	// still running in another branch, is awaited instead of executed again.
+	// A failed dependency fails the task without running it.
+	Deps []Runnable
+	// Inputs lists glob patterns, relative to the execution path, of files the
+	// task reads. When set, the task is skipped at a path if the matched files
+	// and Outputs are unchanged since its last successful run there with the
+	// same resolved flags. Patterns use [path.Match] syntax per segment, and a
+	// "**" segment matches any number of directories.
+	Inputs []string
+	// Outputs lists glob patterns of files the task produces. A task whose
+	// outputs are missing or modified runs again. Requires Inputs.
+	Outputs []string
 
 	// flagSet is the internal FlagSet built from Flags by the engine.
 	flagSet *flag.FlagSet
@@ -74,7 +92,7 @@ func (t *Task) buildFlagSet() error {
 }
 
 // run implements the Runnable interface.
-func (t *Task) run(ctx context.Context) error {
+func (t *Task) run(ctx context.Context) (err error) {
 	if t.Do == nil && t.Body == nil {
 		return fmt.Errorf("task %q has no implementation", t.Name)
 	}
@@ -85,6 +103,12 @@ func (t *Task) run(ctx context.Context) error {
 		effectiveName = t.Name + ":" + suffix
 	}
 
+	// Tasks skipped by a test harness (see package pktest) do not run at all.
+	if skip := skipTasksFromContext(ctx); slices.Contains(skip, effectiveName) || slices.Contains(skip, t.Name) {
+		recordSkip(ctx, effectiveName, statusSkippedScope)
+		return nil
+	}
+
 	// Look up plan-level settings for this task (manual check, verbose, flag overrides).
 	var instance *taskInstance
 	plan := planFromContext(ctx)
@@ -93,6 +117,7 @@ func (t *Task) run(ctx context.Context) error {
 	}
 	if instance != nil {
 		if instance.isManual && isAutoExec(ctx) {
+			recordSkip(ctx, effectiveName, statusManual)
 			return nil
 		}
 		if instance.verbose {
@@ -101,6 +126,7 @@ func (t *Task) run(ctx context.Context) error {
 	}
 	if taskScope := taskScopeFromEnv(); isAutoExec(ctx) && taskScope != "" && plan != nil {
 		if !plan.taskRunsInPath(effectiveName, taskScope) {
+			recordSkip(ctx, effectiveName, statusSkippedScope)
 			return nil
 		}
 	}
@@ -108,8 +134,10 @@ func (t *Task) run(ctx context.Context) error {
 	// Build resolved flags from declared defaults + plan overrides + CLI overrides.
 	// This avoids mutating the shared flagSet, preventing races when the same
 	// task runs in parallel with different WithNameSuffix variants.
+	var resolved map[string]any
 	if t.Flags != nil {
-		resolved, err := structToMap(t.Flags)
+		var err error
+		resolved, err = structToMap(t.Flags)
 		if err != nil {
 			return fmt.Errorf("task %q: %w", t.Name, err)
 		}
@@ -130,38 +158,171 @@ func (t *Task) run(ctx context.Context) error {
 		if info, ok := plan.pathMappings[effectiveName]; ok {
 			path := pkrun.PathFromContext(ctx)
 			if !slices.Contains(info.resolvedPaths, path) {
+				recordSkip(ctx, effectiveName, statusSkippedScope)
 				return nil // Task is excluded from this path.
 			}
 		}
 	}
 
+	// With --since, run only the task paths affected by changed files.
+	// Dependencies and composed subtasks of an affected task are not filtered.
+	if instance != nil && affectedFromContext(ctx) != nil {
+		if skipUnaffected(ctx, effectiveName, pkrun.PathFromContext(ctx)) {
+			recordSkip(ctx, effectiveName, statusSkippedScope)
+			return nil
+		}
+		ctx = withAffected(ctx, nil)
+	}
+
 	// Check deduplication unless forceRun is set in context.
 	// Deduplication uses taskID (effective name + path), or base name + "." for global tasks.
 	// Global tasks use base name only (ignoring suffix) to ensure install tasks run once.
+	// When resolving dependencies, a duplicate waits for the first execution to
+	// finish and shares its result, so dependents never start too early.
+	awaiting := awaitingDepsFromContext(ctx)
+	if awaiting {
+		// Only the dependency itself is awaited; its own subtree deduplicates normally.
+		ctx = context.WithValue(ctx, ctxkey.AwaitDeps{}, false)
+	}
 	if !forceRunFromContext(ctx) {
 		tracker := executionTrackerFromContext(ctx)
 		if tracker != nil {
-			id := taskID{Name: effectiveName, Path: pkrun.PathFromContext(ctx)}
-			if t.Global {
-				id = taskID{Name: t.Name, Path: "."} // Global tasks deduplicate by base name only.
-			}
+			id := t.dedupID(effectiveName, pkrun.PathFromContext(ctx))
 			if alreadyDone := tracker.markDone(id); alreadyDone {
+				emitTaskEvent(ctx, event{Type: eventTaskSkip, Reason: skipReasonDedup}, effectiveName)
+				recordSkip(ctx, effectiveName, statusSkippedDedup)
+				if awaiting {
+					return tracker.wait(ctx, id)
+				}
 				return nil // Silent skip.
 			}
+			defer func() { tracker.finish(id, err) }()
 		}
 	}
 
-	// Print task header before execution (unless header is hidden).
-	if !t.HideHeader {
-		path := pkrun.PathFromContext(ctx)
-		if path != "" && path != "." {
-			pkrun.Printf(ctx, ":: %s [%s]\n", effectiveName, path)
-		} else {
-			pkrun.Printf(ctx, ":: %s\n", effectiveName)
+	// In a streaming parallel branch, prefix this task's output, including its
+	// header, with its name and path. Dependencies and subtasks use their own.
+	ctx, flushStream := withStreamPrefix(ctx, effectiveName)
+	defer flushStream()
+
+	if err := t.runDeps(ctx); err != nil {
+		return fmt.Errorf("task %q: dependency failed: %w", effectiveName, err)
+	}
+
+	// Skip the task if its inputs and outputs are unchanged since the last
+	// successful run. Dependencies run first since they may produce inputs.
+	cache, err := newTaskCache(plan, t, effectiveName, pkrun.PathFromContext(ctx), resolved,
+		pkrun.EnvConfigFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	// A dry run neither uses nor stores cached results.
+	dryRun := pkrun.DryRun(ctx)
+	if cache != nil && !noCacheFromContext(ctx) && !dryRun {
+		hit, err := cache.hit()
+		if err != nil {
+			return fmt.Errorf("task %q: %w", effectiveName, err)
+		}
+		if hit {
+			t.printHeader(ctx, effectiveName, " [cached]")
+			emitTaskEvent(ctx, event{Type: eventTaskSkip, Reason: skipReasonCached}, effectiveName)
+			recordSkip(ctx, effectiveName, statusCached)
+			return nil
 		}
 	}
 
-	return t.execute(ctx)
+	// Retries, timeouts, and locks apply to planned tasks. Builtins such as
+	// exec and watch, and composed subtasks, run within their caller's limits.
+	var retry *retryPolicy
+	var timeout time.Duration
+	var locks []string
+	if instance != nil {
+		retry = instance.retry
+		timeout = cmp.Or(instance.timeout, timeoutFromContext(ctx))
+		locks = instance.locks
+	}
+	ctx, locked, err := acquireLocks(ctx, locks)
+	if err != nil {
+		return err
+	}
+	defer locked.release()
+	ctx = context.WithValue(ctx, ctxkey.TaskName{}, effectiveName)
+	setProgressTask(ctx, effectiveName)
+	ctx, closeLog := withTaskLog(ctx, effectiveName)
+	defer closeLog()
+	var captured *capturedOutput
+	if captureOutputFromContext(ctx) {
+		ctx, captured = withCapturedOutput(ctx)
+	}
+	emitTaskEvent(ctx, event{Type: eventTaskStart}, effectiveName)
+	endSpan := startSpan(ctx, effectiveName, traceCatTask)
+	start := time.Now()
+	execErr := t.executeWithRetry(ctx, effectiveName, retry, timeout, locked.status())
+	endSpan(execErr)
+	emitTaskEvent(ctx, taskFinishEvent(ctx, start, execErr), effectiveName)
+	recordExecution(ctx, effectiveName, start, execErr, captured)
+	if execErr != nil {
+		return t.failure(ctx, effectiveName, execErr)
+	}
+	if cache != nil && !dryRun {
+		if err := cache.store(); err != nil {
+			return fmt.Errorf("task %q: %w", effectiveName, err)
+		}
+	}
+	return nil
+}
+
+// failure attributes err to this task at the current path and records it in
+// the tracker, unless a task composed by this one already failed (the error
+// then belongs to that task) or the context was cancelled by a sibling failure.
+// Failures that an enclosing task will retry are attributed but not recorded.
+func (t *Task) failure(ctx context.Context, effectiveName string, err error) error {
+	var te *taskError
+	if errors.As(err, &te) || ctx.Err() != nil {
+		return err
+	}
+	path := pkrun.PathFromContext(ctx)
+	if path == "" {
+		path = "."
+	}
+	te = &taskError{name: effectiveName, path: path, err: err}
+	if tracker := executionTrackerFromContext(ctx); tracker != nil && !retryPendingFromContext(ctx) {
+		tracker.recordFailure(te)
+	}
+	return te
+}
+
+// printHeader prints the ":: name [path]" header unless the header is hidden.
+// status is appended verbatim (e.g., " [cached]").
+func (t *Task) printHeader(ctx context.Context, effectiveName, status string) {
+	if t.HideHeader {
+		return
+	}
+	path := pkrun.PathFromContext(ctx)
+	if path != "" && path != "." {
+		pkrun.Printf(ctx, ":: %s [%s]%s\n", effectiveName, path, status)
+	} else {
+		pkrun.Printf(ctx, ":: %s%s\n", effectiveName, status)
+	}
+}
+
+// dedupID returns the deduplication key for this task at path.
+// Global tasks deduplicate by base name only.
+func (t *Task) dedupID(effectiveName, path string) taskID {
+	if t.Global {
+		return taskID{Name: t.Name, Path: "."}
+	}
+	return taskID{Name: effectiveName, Path: path}
+}
+
+// runDeps runs the task's dependencies concurrently and waits for all of them,
+// including dependencies that are already running elsewhere in the tree.
+func (t *Task) runDeps(ctx context.Context) error {
+	if len(t.Deps) == 0 {
+		return nil
+	}
+	ctx = context.WithValue(ctx, ctxkey.AwaitDeps{}, true)
+	return Parallel(t.Deps...).run(ctx)
 }
 
 // execute runs the task body, recovering flagError panics from GetFlag.
@@ -176,7 +337,7 @@ func (t *Task) execute(ctx context.Context) (err error) {
 		}
 	}()
 	if t.Do != nil {
-		return t.Do(ctx)
+		return runLimited(ctx, t.Do)
 	}
 	return t.Body.run(ctx)
 }
@@ -2,8 +2,14 @@ package pk
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
 	pkrun "github.com/fredrikaverpil/pocket/pk/run"
@@ -680,3 +686,90 @@ func TestGetFlags_RecoveredByTaskRun(t *testing.T) {
 		t.Errorf("unexpected error message: %q", got)
 	}
 }
+
+func TestTask_Run_DepsRunBeforeTask(t *testing.T) {
+	var order []string
+	var mu sync.Mutex
+	record := func(name string) func(context.Context) error {
+		return func(_ context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, name)
+			return nil
+		}
+	}
+
+	generate := &Task{Name: "generate", Do: record("generate")}
+	lint := &Task{Name: "lint", Deps: []Runnable{generate}, Do: record("lint")}
+
+	ctx := withExecutionTracker(context.Background(), newExecutionTracker())
+	ctx = context.WithValue(ctx, ctxkey.Output{}, testOutput())
+	if err := lint.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"generate", "lint"}; !slices.Equal(order, want) {
+		t.Errorf("expected %v, got %v", want, order)
+	}
+}
+
+func TestTask_Run_SharedDepIsAwaited(t *testing.T) {
+	// Both dependents start concurrently. The dependent that loses the race for
+	// the shared dependency must wait for it instead of skipping it.
+	var generated atomic.Bool
+	generate := &Task{Name: "generate", Do: func(_ context.Context) error {
+		time.Sleep(20 * time.Millisecond)
+		generated.Store(true)
+		return nil
+	}}
+	var runs atomic.Int32
+	dependent := func(name string) *Task {
+		return &Task{Name: name, Deps: []Runnable{generate}, Do: func(_ context.Context) error {
+			runs.Add(1)
+			if !generated.Load() {
+				return fmt.Errorf("%s started before its dependency finished", name)
+			}
+			return nil
+		}}
+	}
+
+	ctx := withExecutionTracker(context.Background(), newExecutionTracker())
+	ctx = context.WithValue(ctx, ctxkey.Output{}, testOutput())
+	if err := Parallel(dependent("lint"), dependent("test")).run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := runs.Load(); got != 2 {
+		t.Errorf("expected both dependents to run, got %d", got)
+	}
+}
+
+func TestTask_Run_FailedDepFailsTask(t *testing.T) {
+	errBoom := errors.New("boom")
+	var ran atomic.Bool
+	generate := &Task{Name: "generate", Do: func(_ context.Context) error { return errBoom }}
+	lint := &Task{Name: "lint", Deps: []Runnable{generate}, Do: func(_ context.Context) error {
+		ran.Store(true)
+		return nil
+	}}
+	test := &Task{Name: "test", Deps: []Runnable{generate}, Do: func(_ context.Context) error {
+		ran.Store(true)
+		return nil
+	}}
+
+	ctx := withExecutionTracker(context.Background(), newExecutionTracker())
+	ctx = context.WithValue(ctx, ctxkey.Output{}, testOutput())
+
+	// The second dependent sees the recorded failure of the deduplicated dependency.
+	for _, task := range []*Task{lint, test} {
+		err := task.run(ctx)
+		if !errors.Is(err, errBoom) {
+			t.Errorf("%s: expected errBoom, got %v", task.Name, err)
+		}
+		if err == nil || !strings.Contains(err.Error(), "dependency failed") {
+			t.Errorf("%s: expected dependency failure, got %v", task.Name, err)
+		}
+	}
+	if ran.Load() {
+		t.Error("dependents should not run after a failed dependency")
+	}
+}
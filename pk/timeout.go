@@ -0,0 +1,32 @@
+package pk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// executeWithTimeout runs the task once, failing it if it takes longer than
+// timeout. A zero timeout runs the task without a deadline.
+//
+// The deadline cancels the task's context, so commands started with run.Exec
+// receive SIGINT and are killed after run.WaitDelay if they do not exit.
+// Whatever error the interrupted task returns is replaced by one that names
+// the timeout, unless the parent context was cancelled as well.
+func (t *Task) executeWithTimeout(
+	ctx context.Context,
+	effectiveName string,
+	timeout time.Duration,
+) error {
+	if timeout <= 0 {
+		return t.execute(ctx)
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := t.execute(timeoutCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("task %q timed out after %s: %w", effectiveName, timeout, context.DeadlineExceeded)
+	}
+	return err
+}
@@ -0,0 +1,131 @@
+package pk
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+)
+
+// hangingTask returns a task that blocks until its context is done.
+func hangingTask(name string) *Task {
+	return &Task{Name: name, Usage: name, Do: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+}
+
+func TestWithTimeout_FailsHungTask(t *testing.T) {
+	task := hangingTask("hang")
+	plan, err := newPlan(&Config{Auto: WithOptions(task, WithTimeout(20*time.Millisecond))}, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := integrationCtx(t, plan)
+	err = plan.tree.run(ctx)
+	if err == nil || !strings.Contains(err.Error(), `task "hang" timed out after 20ms`) {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected error to wrap context.DeadlineExceeded, got %v", err)
+	}
+	if failures := executionTrackerFromContext(ctx).failed(); len(failures) != 1 {
+		t.Errorf("expected the timeout to be recorded as a failure, got %v", failures)
+	}
+}
+
+func TestWithTimeout_OverridesGlobalTimeout(t *testing.T) {
+	task := &Task{Name: "slow", Usage: "slow", Do: func(ctx context.Context) error {
+		select {
+		case <-time.After(50 * time.Millisecond):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}}
+	plan, err := newPlan(&Config{Auto: WithOptions(task, WithTimeout(time.Hour))}, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := integrationCtx(t, plan)
+	ctx = context.WithValue(ctx, ctxkey.Timeout{}, time.Millisecond)
+	if err := plan.tree.run(ctx); err != nil {
+		t.Fatalf("expected WithTimeout to take precedence over --timeout, got %v", err)
+	}
+}
+
+func TestGlobalTimeout(t *testing.T) {
+	task := hangingTask("hang")
+	plan, err := newPlan(&Config{Auto: task}, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := integrationCtx(t, plan)
+	ctx = context.WithValue(ctx, ctxkey.Timeout{}, 20*time.Millisecond)
+	err = plan.tree.run(ctx)
+	if err == nil || !strings.Contains(err.Error(), "timed out after 20ms") {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+}
+
+func TestGlobalTimeout_SkipsUnplannedTasks(t *testing.T) {
+	plan, err := newPlan(&Config{Auto: hangingTask("planned")}, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Like the watch and exec builtins, the task is not part of the plan.
+	unplanned := &Task{Name: "builtin", Usage: "builtin", Do: func(ctx context.Context) error {
+		select {
+		case <-time.After(50 * time.Millisecond):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}}
+
+	ctx, _ := integrationCtx(t, plan)
+	ctx = context.WithValue(ctx, ctxkey.Timeout{}, time.Millisecond)
+	if err := unplanned.run(ctx); err != nil {
+		t.Fatalf("expected --timeout not to apply to a task outside the plan, got %v", err)
+	}
+}
+
+func TestWithTimeout_AppliesPerAttempt(t *testing.T) {
+	var runs int
+	task := &Task{Name: "flaky", Usage: "flaky", Do: func(ctx context.Context) error {
+		runs++
+		if runs == 1 {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	}}
+	cfg := &Config{Auto: WithOptions(task, WithRetry(2, 0), WithTimeout(20*time.Millisecond))}
+	plan, err := newPlan(cfg, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := integrationCtx(t, plan)
+	if err := plan.tree.run(ctx); err != nil {
+		t.Fatalf("expected the second attempt to succeed, got %v", err)
+	}
+	if runs != 2 {
+		t.Errorf("expected 2 runs, got %d", runs)
+	}
+}
+
+func TestWithTimeout_InvalidDurationPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for non-positive timeout")
+		}
+	}()
+	WithTimeout(0)
+}
@@ -0,0 +1,186 @@
+package pk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
+	pkrun "github.com/fredrikaverpil/pocket/pk/run"
+)
+
+// Span categories in the --trace timeline.
+const (
+	traceCatRun      = "run"
+	traceCatSerial   = "serial"
+	traceCatParallel = "parallel"
+	traceCatFinally  = "finally"
+	traceCatLock     = "lock"
+	traceCatPath     = "path"
+	traceCatTask     = "task"
+)
+
+// traceRootLane is the lane (trace thread) of the top-level runnable.
+const traceRootLane = 1
+
+// traceEvent is an event in the Chrome trace-event format, which chrome://tracing
+// and Perfetto load. Timestamps and durations are in microseconds.
+type traceEvent struct {
+	Name string         `json:"name"`
+	Cat  string         `json:"cat,omitempty"`
+	Ph   string         `json:"ph"` // "X" for complete spans, "M" for metadata.
+	TS   int64          `json:"ts"`
+	Dur  *int64         `json:"dur,omitempty"`
+	PID  int            `json:"pid"`
+	TID  int            `json:"tid"`
+	Args map[string]any `json:"args,omitempty"`
+}
+
+// traceLog collects execution spans for the --trace timeline. Each parallel
+// branch runs in its own lane, shown as a thread, so concurrent work is laid
+// out side by side and nested spans stack within a lane.
+// It is safe for concurrent use.
+type traceLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	close  func() error
+	start  time.Time
+	lanes  int
+	events []traceEvent
+}
+
+// openTraceLog creates or truncates the --trace file. The trace is written
+// when the run ends.
+func openTraceLog(name string) (*traceLog, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, fmt.Errorf("opening trace file: %w", err)
+	}
+	l := newTraceLog(f)
+	l.close = f.Close
+	return l, nil
+}
+
+// newTraceLog returns a trace log writing to w.
+func newTraceLog(w io.Writer) *traceLog {
+	l := &traceLog{w: w, close: func() error { return nil }, start: time.Now()}
+	l.events = append(l.events, traceEvent{
+		Name: "process_name",
+		Ph:   "M",
+		PID:  1,
+		Args: map[string]any{"name": "pocket"},
+	})
+	l.newLane("main")
+	return l
+}
+
+// newLane allocates a lane labelled name and returns its id.
+func (l *traceLog) newLane(name string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lanes++
+	l.events = append(l.events,
+		traceEvent{Name: "thread_name", Ph: "M", PID: 1, TID: l.lanes, Args: map[string]any{"name": name}},
+		traceEvent{Name: "thread_sort_index", Ph: "M", PID: 1, TID: l.lanes, Args: map[string]any{"sort_index": l.lanes}},
+	)
+	return l.lanes
+}
+
+// span records a complete span in lane from start until now.
+func (l *traceLog) span(name, cat string, lane int, start time.Time, args map[string]any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	dur := time.Since(start).Microseconds()
+	l.events = append(l.events, traceEvent{
+		Name: name,
+		Cat:  cat,
+		Ph:   "X",
+		TS:   start.Sub(l.start).Microseconds(),
+		Dur:  &dur,
+		PID:  1,
+		TID:  lane,
+		Args: args,
+	})
+}
+
+// finish records a span for the whole run, writes the trace, and closes it.
+func (l *traceLog) finish(err error) error {
+	status := "ok"
+	if err != nil {
+		status = "failed"
+	}
+	l.span("pok", traceCatRun, traceRootLane, l.start, map[string]any{"status": status})
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	data, err := json.Marshal(struct {
+		TraceEvents     []traceEvent `json:"traceEvents"`
+		DisplayTimeUnit string       `json:"displayTimeUnit"`
+	}{l.events, "ms"})
+	if err != nil {
+		_ = l.close()
+		return err
+	}
+	if _, err := l.w.Write(data); err != nil {
+		_ = l.close()
+		return err
+	}
+	return l.close()
+}
+
+// withTraceLog returns a context that records execution spans to l.
+func withTraceLog(ctx context.Context, l *traceLog) context.Context {
+	return context.WithValue(ctx, ctxkey.Trace{}, l)
+}
+
+func traceLogFromContext(ctx context.Context) *traceLog {
+	l, _ := ctx.Value(ctxkey.Trace{}).(*traceLog)
+	return l
+}
+
+// traceLaneFromContext returns the lane that spans started with ctx belong to.
+func traceLaneFromContext(ctx context.Context) int {
+	if lane, ok := ctx.Value(ctxkey.TraceLane{}).(int); ok {
+		return lane
+	}
+	return traceRootLane
+}
+
+// withTraceLane returns a context whose spans go to a new lane labelled name.
+// Without a trace log, ctx is returned unchanged.
+func withTraceLane(ctx context.Context, name string) context.Context {
+	l := traceLogFromContext(ctx)
+	if l == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxkey.TraceLane{}, l.newLane(name))
+}
+
+// startSpan starts a span in the current lane and returns the function that
+// ends it. The span records the current path and, if the error passed to the
+// end function is non-nil, the error. Without a trace log, it does nothing.
+func startSpan(ctx context.Context, name, cat string) func(err error) {
+	l := traceLogFromContext(ctx)
+	if l == nil {
+		return func(error) {}
+	}
+	lane := traceLaneFromContext(ctx)
+	start := time.Now()
+	return func(err error) {
+		args := map[string]any{}
+		if path := pkrun.PathFromContext(ctx); path != "" {
+			args["path"] = path
+		}
+		if cat == traceCatTask {
+			args["status"] = statusForError(ctx, err)
+		}
+		if err != nil {
+			args["error"] = pkrun.Redact(err.Error())
+		}
+		l.span(name, cat, lane, start, args)
+	}
+}
@@ -0,0 +1,140 @@
+package pk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readTrace decodes the trace written to buf.
+func readTrace(t *testing.T, buf *bytes.Buffer) []traceEvent {
+	t.Helper()
+	var doc struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid trace: %v\n%s", err, buf.String())
+	}
+	return doc.TraceEvents
+}
+
+func TestTrace_Spans(t *testing.T) {
+	sleep := func(_ context.Context) error {
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}
+	a := &Task{Name: "a", Usage: "a", Do: sleep}
+	b := &Task{Name: "b", Usage: "b", Do: sleep}
+	c := &Task{Name: "c", Usage: "c", Do: func(_ context.Context) error { return errors.New("boom") }}
+	cfg := &Config{Auto: Serial(a, WithOptions(Parallel(b, c), WithPath("svc"), WithContinueOnError()))}
+	plan, err := newPlan(cfg, "/tmp", []string{".", "svc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	trace := newTraceLog(&buf)
+	ctx, _ := integrationCtx(t, plan)
+	ctx = withTraceLog(ctx, trace)
+	runErr := plan.tree.run(ctx)
+	if runErr == nil {
+		t.Fatal("expected error")
+	}
+	if err := trace.finish(runErr); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := make(map[string]traceEvent)
+	lanes := make(map[int]string)
+	for _, e := range readTrace(t, &buf) {
+		switch e.Ph {
+		case "X":
+			spans[e.Name] = e
+		case "M":
+			if e.Name == "thread_name" {
+				lanes[e.TID], _ = e.Args["name"].(string)
+			}
+		}
+	}
+	for _, name := range []string{"pok", "serial", "a", "svc", "parallel", "b", "c"} {
+		if _, ok := spans[name]; !ok {
+			t.Fatalf("expected span %q, got %v", name, spans)
+		}
+	}
+
+	// Sequential work stays in the root lane; parallel branches get their own.
+	for _, name := range []string{"pok", "serial", "a", "svc", "parallel"} {
+		if spans[name].TID != traceRootLane {
+			t.Errorf("expected %q in the root lane, got lane %d", name, spans[name].TID)
+		}
+	}
+	if spans["b"].TID == traceRootLane || spans["c"].TID == traceRootLane || spans["b"].TID == spans["c"].TID {
+		t.Errorf("expected b and c in separate branch lanes, got %d and %d", spans["b"].TID, spans["c"].TID)
+	}
+	if lanes[spans["b"].TID] != "parallel branch 1/2" {
+		t.Errorf("unexpected lane name %q", lanes[spans["b"].TID])
+	}
+
+	// Spans nest in time within their parents.
+	within := func(child, parent string) {
+		t.Helper()
+		ch, p := spans[child], spans[parent]
+		if ch.TS < p.TS || ch.TS+*ch.Dur > p.TS+*p.Dur {
+			t.Errorf("expected %q [%d+%d] within %q [%d+%d]", child, ch.TS, *ch.Dur, parent, p.TS, *p.Dur)
+		}
+	}
+	within("a", "serial")
+	within("svc", "serial")
+	within("parallel", "svc")
+	within("b", "parallel")
+	within("c", "parallel")
+	within("serial", "pok")
+
+	if *spans["a"].Dur < (10 * time.Millisecond).Microseconds() {
+		t.Errorf("expected span duration of at least 10ms, got %dus", *spans["a"].Dur)
+	}
+	if spans["b"].Args["path"] != "svc" || spans["b"].Args["status"] != "ok" {
+		t.Errorf("unexpected task span args: %v", spans["b"].Args)
+	}
+	if spans["c"].Args["status"] != "failed" || spans["c"].Args["error"] != "boom" {
+		t.Errorf("expected failed task span, got args %v", spans["c"].Args)
+	}
+	if spans["pok"].Args["status"] != "failed" {
+		t.Errorf("expected failed run span, got args %v", spans["pok"].Args)
+	}
+}
+
+func TestTrace_Disabled(t *testing.T) {
+	ctx := context.Background()
+	startSpan(ctx, "a", traceCatTask)(nil)
+	if got := withTraceLane(ctx, "lane"); got != ctx {
+		t.Error("expected context unchanged without a trace log")
+	}
+}
+
+func TestOpenTraceLog(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "trace.json")
+	trace, err := openTraceLog(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := trace.finish(nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events := readTrace(t, bytes.NewBuffer(data)); len(events) == 0 {
+		t.Error("expected trace events in file")
+	}
+
+	if _, err := openTraceLog(filepath.Join(t.TempDir(), "missing", "trace.json")); err == nil {
+		t.Error("expected error for missing directory")
+	}
+}
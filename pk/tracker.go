@@ -2,6 +2,7 @@ package pk
 
 import (
 	"context"
+	"slices"
 	"sync"
 
 	"github.com/fredrikaverpil/pocket/pk/internal/ctxkey"
@@ -23,18 +24,32 @@ func (id taskID) String() string {
 type executionTracker struct {
 	mu          sync.Mutex
 	done        map[taskID]bool
+	results     map[taskID]*taskResult
+	failures    []*taskError
 	hadWarnings bool
+	records     []*taskRecord
+	recordIndex map[taskID]*taskRecord
+}
+
+// taskResult holds the outcome of a task execution. finished is closed once
+// err is set, so dependents can wait for a task that is still running.
+type taskResult struct {
+	finished chan struct{}
+	err      error
 }
 
 // newExecutionTracker creates a new execution tracker.
 func newExecutionTracker() *executionTracker {
 	return &executionTracker{
-		done: make(map[taskID]bool),
+		done:        make(map[taskID]bool),
+		results:     make(map[taskID]*taskResult),
+		recordIndex: make(map[taskID]*taskRecord),
 	}
 }
 
 // markDone records that a task has executed.
 // Returns true if it was already done (should skip), false if first time.
+// The first caller must report the outcome with finish.
 func (t *executionTracker) markDone(id taskID) bool {
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -42,9 +57,40 @@ func (t *executionTracker) markDone(id taskID) bool {
 		return true
 	}
 	t.done[id] = true
+	t.results[id] = &taskResult{finished: make(chan struct{})}
 	return false
 }
 
+// finish records the outcome of a task previously marked with markDone
+// and releases any goroutines waiting for it.
+func (t *executionTracker) finish(id taskID, err error) {
+	t.mu.Lock()
+	r := t.results[id]
+	t.mu.Unlock()
+	if r == nil {
+		return
+	}
+	r.err = err
+	close(r.finished)
+}
+
+// wait blocks until the task identified by id has finished and returns its error.
+// Returns nil immediately if the task was never started.
+func (t *executionTracker) wait(ctx context.Context, id taskID) error {
+	t.mu.Lock()
+	r := t.results[id]
+	t.mu.Unlock()
+	if r == nil {
+		return nil
+	}
+	select {
+	case <-r.finished:
+		return r.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // executedTaskPath represents a task that was executed at a specific path.
 type executedTaskPath struct {
 	TaskName string
@@ -85,9 +131,79 @@ func (t *executionTracker) MarkWarning() {
 	t.mu.Unlock()
 }
 
+// MarkTaskWarning records a warning detected while the task in ctx was
+// running and reports it to the --events stream, if enabled.
+// run.Exec calls it in preference to MarkWarning.
+func (t *executionTracker) MarkTaskWarning(ctx context.Context) {
+	t.MarkWarning()
+	emitTaskEvent(ctx, event{Type: eventWarning}, taskNameFromContext(ctx))
+}
+
+// record stores the outcome of a task at a path for the run summary.
+// Records are keyed by effective name and path, independent of deduplication.
+// An execution replaces an earlier skip, a skip never replaces an execution,
+// and repeated executions (e.g., with WithForceRun) add up their durations.
+func (t *executionTracker) record(r taskRecord) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id := taskID{Name: r.Task, Path: r.Path}
+	existing, ok := t.recordIndex[id]
+	switch {
+	case !ok:
+		existing = &r
+		t.recordIndex[id] = existing
+		t.records = append(t.records, existing)
+	case r.Status.skipped():
+	case existing.Status.skipped():
+		*existing = r
+	default:
+		r.Duration += existing.Duration
+		*existing = r
+	}
+}
+
+// taskRecords returns copies of the recorded task outcomes in the order the
+// tasks were first seen.
+func (t *executionTracker) taskRecords() []taskRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	result := make([]taskRecord, len(t.records))
+	for i, r := range t.records {
+		result[i] = *r
+	}
+	return result
+}
+
+// recordFailure records a task failure for the final status report.
+func (t *executionTracker) recordFailure(e *taskError) {
+	t.mu.Lock()
+	t.failures = append(t.failures, e)
+	t.mu.Unlock()
+}
+
+// failed returns the recorded task failures in the order they occurred.
+func (t *executionTracker) failed() []*taskError {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Clone(t.failures)
+}
+
 // warnings returns true if any warnings were detected.
 func (t *executionTracker) warnings() bool {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	return t.hadWarnings
 }
+
+// taskError marks the error of the innermost task that failed, so that a
+// failure is attributed to that task and path and not to the tasks composing it.
+// It does not alter the error message.
+type taskError struct {
+	name string
+	path string
+	err  error
+}
+
+func (e *taskError) Error() string { return e.err.Error() }
+
+func (e *taskError) Unwrap() error { return e.err }
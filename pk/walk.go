@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
 )
@@ -13,12 +14,6 @@ import (
 // all directories found (relative to gitRoot, using forward slashes).
 // Skips directories in skipDirs, and hidden directories unless includeHidden is true.
 func walkDirectories(gitRoot string, skipDirs []string, includeHidden bool) ([]string, error) {
-	// Build a set for O(1) lookup
-	skipSet := make(map[string]struct{}, len(skipDirs))
-	for _, d := range skipDirs {
-		skipSet[d] = struct{}{}
-	}
-
 	var dirs []string
 
 	// Always include "." (the git root itself)
@@ -47,15 +42,7 @@ func walkDirectories(gitRoot string, skipDirs []string, includeHidden bool) ([]s
 		// Normalize to forward slashes
 		relPath = filepath.ToSlash(relPath)
 
-		base := filepath.Base(path)
-
-		// Skip hidden directories unless includeHidden is true
-		if !includeHidden && strings.HasPrefix(base, ".") {
-			return filepath.SkipDir
-		}
-
-		// Skip directories in the skip set
-		if _, skip := skipSet[base]; skip {
+		if skipDir(filepath.Base(path), skipDirs, includeHidden) {
 			return filepath.SkipDir
 		}
 
@@ -66,6 +53,15 @@ func walkDirectories(gitRoot string, skipDirs []string, includeHidden bool) ([]s
 	return dirs, err
 }
 
+// skipDir reports whether a directory with the given base name is skipped:
+// hidden directories unless includeHidden is true, and names in skipDirs.
+func skipDir(base string, skipDirs []string, includeHidden bool) bool {
+	if !includeHidden && strings.HasPrefix(base, ".") {
+		return true
+	}
+	return slices.Contains(skipDirs, base)
+}
+
 var (
 	regexMu    sync.RWMutex
 	regexCache = make(map[string]*regexp.Regexp)
@@ -0,0 +1,393 @@
+package pk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fredrikaverpil/pocket/pk/repopath"
+	pkrun "github.com/fredrikaverpil/pocket/pk/run"
+)
+
+// watchFlags defines flags for the watch task.
+type watchFlags struct {
+	Interval string `flag:"interval" usage:"how often to poll for file changes"`
+	Debounce string `flag:"debounce" usage:"how long changes must settle before tasks re-run"`
+}
+
+// watchTask re-runs tasks when files in their paths change.
+var watchTask = &Task{
+	Name:       "watch",
+	Usage:      "re-run tasks when files in their paths change",
+	HideHeader: true,
+	Flags:      watchFlags{Interval: "500ms", Debounce: "200ms"},
+	Do: func(ctx context.Context) error {
+		p := planFromContext(ctx)
+		if p == nil {
+			return fmt.Errorf("plan not found in context")
+		}
+		f := pkrun.GetFlags[watchFlags](ctx)
+		interval, err := parseWatchDuration("interval", f.Interval)
+		if err != nil {
+			return err
+		}
+		debounce, err := parseWatchDuration("debounce", f.Debounce)
+		if err != nil {
+			return err
+		}
+		w, err := newWatcher(p, taskArgsFromContext(ctx), interval, debounce)
+		if err != nil {
+			return err
+		}
+		return w.run(ctx)
+	},
+}
+
+// parseWatchDuration parses a positive duration flag value.
+func parseWatchDuration(name, value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid -%s value %q: must be a positive duration", name, value)
+	}
+	return d, nil
+}
+
+// watcher polls the paths of a set of task instances and re-runs the task
+// paths affected by each change. Polling avoids platform-specific file
+// notification APIs and the dependencies they would bring.
+type watcher struct {
+	plan          *Plan
+	instances     []*taskInstance
+	gitRoot       string
+	roots         []string // Watched directories, relative to gitRoot, without nested duplicates.
+	skipDirs      []string
+	includeHidden bool
+	interval      time.Duration
+	debounce      time.Duration
+}
+
+// fileState is the part of a file's metadata compared between polls.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// newWatcher returns a watcher for the named tasks.
+func newWatcher(p *Plan, names []string, interval, debounce time.Duration) (*watcher, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("watch requires at least one task name (e.g. pok watch go-test)")
+	}
+	gitRoot, err := repopath.GitRoot()
+	if err != nil {
+		return nil, fmt.Errorf("finding git root: %w", err)
+	}
+
+	w := &watcher{
+		plan:          p,
+		gitRoot:       gitRoot,
+		skipDirs:      DefaultSkipDirs,
+		includeHidden: false,
+		interval:      interval,
+		debounce:      debounce,
+	}
+	if p.skipDirs != nil {
+		w.skipDirs, w.includeHidden = p.skipDirs, p.includeHidden
+	}
+
+	var paths []string
+	for _, name := range names {
+		instance := findTaskByName(p, name)
+		if instance == nil {
+			return nil, fmt.Errorf("unknown task %q\nRun 'pok -h' to see available tasks", name)
+		}
+		w.instances = append(w.instances, instance)
+
+		instancePaths := instance.resolvedPaths
+		if len(instancePaths) == 0 {
+			instancePaths = []string{"."}
+		}
+		// Within a shim's TASK_SCOPE, only the scoped path runs, so only it is watched.
+		if taskScope := taskScopeFromEnv(); taskScope != "" && slices.Contains(instancePaths, taskScope) {
+			instancePaths = []string{taskScope}
+		}
+		paths = unionPaths(paths, instancePaths)
+	}
+	w.roots = watchRoots(paths)
+	return w, nil
+}
+
+// watchRoots returns the unique paths that are not contained in another path.
+func watchRoots(paths []string) []string {
+	var roots []string
+	for _, dir := range paths {
+		nested := slices.ContainsFunc(paths, func(other string) bool {
+			return other != dir && containsPath(other, dir)
+		})
+		if !nested && !slices.Contains(roots, dir) {
+			roots = append(roots, dir)
+		}
+	}
+	slices.Sort(roots)
+	return roots
+}
+
+// run executes the tasks once, then re-runs the affected task paths after
+// each change until ctx is cancelled. Task failures are reported without
+// stopping the watch.
+//
+// Files are not compared while a run is in progress, because changes made by
+// the run cannot be told apart from changes made by the user. When the run
+// finishes, the files it changed become part of the baseline, so a task
+// writing below its own paths, such as a coverage profile or a formatter
+// rewrite, does not trigger itself. Of those files, the ones that were also
+// changed between runs are taken to be edited by the user and trigger another
+// run, so an edit saved during a run is not lost. A file first changed while a
+// run is in progress is treated as the run's output until it changes again
+// between runs.
+func (w *watcher) run(ctx context.Context) error {
+	prev, err := w.snapshot()
+	if err != nil {
+		return err
+	}
+
+	current := w.start(ctx, nil, prev)
+	defer current.stop()
+
+	edited := make(map[string]bool) // Files changed between runs.
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if current.running() {
+			continue
+		}
+
+		var changed []string
+		if after, written, ok := current.result(); ok {
+			prev = after
+			for _, name := range written {
+				if edited[name] {
+					changed = append(changed, name)
+				}
+			}
+		}
+		cur, err := w.snapshot()
+		if err != nil {
+			return err
+		}
+		changed = unionPaths(changed, diffSnapshots(prev, cur))
+		if len(changed) == 0 {
+			continue
+		}
+		if changed, cur, err = w.settle(ctx, changed, cur); err != nil {
+			if ctx.Err() != nil {
+				return nil // Interrupted while waiting for changes to settle.
+			}
+			return err
+		}
+		prev = cur
+		for _, name := range changed {
+			edited[name] = true
+		}
+
+		affected := w.plan.affectedPaths(changed)
+		if !slices.ContainsFunc(w.instances, func(inst *taskInstance) bool {
+			return len(affected[inst.name]) > 0
+		}) {
+			continue
+		}
+
+		pkrun.Printf(ctx, "\n:: watch: %s changed\n", describeChanges(changed))
+		current = w.start(ctx, affected, prev)
+	}
+}
+
+// watchRun is a run of the watched tasks in the background.
+type watchRun struct {
+	cancel  context.CancelFunc
+	done    chan struct{}
+	after   map[string]fileState // Snapshot taken when the run finished; nil if cancelled.
+	written []string             // Files changed between the run's start and after.
+}
+
+// stop cancels the run and waits for it to return.
+func (r *watchRun) stop() {
+	r.cancel()
+	<-r.done
+}
+
+// running reports whether the run is still in progress.
+func (r *watchRun) running() bool {
+	select {
+	case <-r.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// result returns the snapshot taken when the run finished and the files
+// changed while it ran, once. It reports false while the run is in progress
+// and after the result was returned.
+func (r *watchRun) result() (map[string]fileState, []string, bool) {
+	if r.running() || r.after == nil {
+		return nil, nil, false
+	}
+	after, written := r.after, r.written
+	r.after, r.written = nil, nil
+	return after, written, true
+}
+
+// start runs the watched tasks in the background, restricted to the affected
+// task paths unless affected is nil. before is the snapshot the run starts
+// from. Each run gets its own execution tracker, so tasks deduplicated in an
+// earlier run execute again.
+func (w *watcher) start(ctx context.Context, affected map[string][]string, before map[string]fileState) *watchRun {
+	runCtx, cancel := context.WithCancel(ctx)
+	runCtx = withExecutionTracker(runCtx, newExecutionTracker())
+	if affected != nil {
+		runCtx = withAffected(runCtx, affected)
+	}
+
+	r := &watchRun{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		err := w.runOnce(runCtx)
+		if runCtx.Err() != nil {
+			return // Interrupted.
+		}
+		// A failed snapshot is reported by the watcher's next poll.
+		if after, snapErr := w.snapshot(); snapErr == nil {
+			r.after, r.written = after, diffSnapshots(before, after)
+		}
+		if err != nil {
+			pkrun.Printf(ctx, ":: watch: %v\n", err)
+		}
+		pkrun.Printf(ctx, ":: watch: waiting for changes in %s\n", formatPaths(w.roots))
+	}()
+	return r
+}
+
+// runOnce executes each watched task instance in its paths.
+func (w *watcher) runOnce(ctx context.Context) error {
+	keepGoing := keepGoingFromContext(ctx)
+	var errs []error
+	for _, instance := range w.instances {
+		if err := instance.execute(ctx); err != nil {
+			if !keepGoing {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// settle polls every debounce interval until a poll finds no further changes,
+// and returns all files changed in the meantime along with the final snapshot.
+func (w *watcher) settle(
+	ctx context.Context,
+	changed []string,
+	cur map[string]fileState,
+) ([]string, map[string]fileState, error) {
+	for {
+		timer := time.NewTimer(w.debounce)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, nil, ctx.Err()
+		case <-timer.C:
+		}
+		next, err := w.snapshot()
+		if err != nil {
+			return nil, nil, err
+		}
+		more := diffSnapshots(cur, next)
+		if len(more) == 0 {
+			slices.Sort(changed)
+			return changed, cur, nil
+		}
+		changed = unionPaths(changed, more)
+		cur = next
+	}
+}
+
+// snapshot records the state of every file below the watched roots, keyed by
+// slash-separated path relative to the git root. Directories are skipped
+// using the plan's walk rules.
+func (w *watcher) snapshot() (map[string]fileState, error) {
+	files := make(map[string]fileState)
+	for _, root := range w.roots {
+		rootDir := filepath.Join(w.gitRoot, filepath.FromSlash(root))
+		err := filepath.WalkDir(rootDir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil // Removed while walking; picked up by the next poll.
+				}
+				return err
+			}
+			if d.IsDir() {
+				if p != rootDir && skipDir(d.Name(), w.skipDirs, w.includeHidden) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			rel, err := filepath.Rel(w.gitRoot, p)
+			if err != nil {
+				return err
+			}
+			files[filepath.ToSlash(rel)] = fileState{modTime: info.ModTime(), size: info.Size()}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("watching %s: %w", root, err)
+		}
+	}
+	return files, nil
+}
+
+// diffSnapshots returns the sorted paths of files added, removed, or modified
+// between two snapshots.
+func diffSnapshots(prev, cur map[string]fileState) []string {
+	var changed []string
+	for name, state := range cur {
+		if old, ok := prev[name]; !ok || !old.modTime.Equal(state.modTime) || old.size != state.size {
+			changed = append(changed, name)
+		}
+	}
+	for name := range prev {
+		if _, ok := cur[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
+
+// describeChanges summarizes changed files for the re-run header.
+func describeChanges(changed []string) string {
+	if len(changed) == 1 {
+		return changed[0]
+	}
+	const shown = 3
+	if len(changed) <= shown {
+		return strings.Join(changed, ", ")
+	}
+	return fmt.Sprintf("%s and %d more files", strings.Join(changed[:shown], ", "), len(changed)-shown)
+}
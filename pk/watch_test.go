@@ -0,0 +1,252 @@
+package pk
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	pkrun "github.com/fredrikaverpil/pocket/pk/run"
+)
+
+func TestWatchRoots(t *testing.T) {
+	tests := []struct {
+		paths []string
+		want  []string
+	}{
+		{[]string{"."}, []string{"."}},
+		{[]string{"services/api", ".", "services/web"}, []string{"."}},
+		{[]string{"services/web", "services/api", "services"}, []string{"services"}},
+		{[]string{"api", "api2"}, []string{"api", "api2"}},
+	}
+	for _, tt := range tests {
+		if got := watchRoots(tt.paths); !slices.Equal(got, tt.want) {
+			t.Errorf("watchRoots(%v) = %v, want %v", tt.paths, got, tt.want)
+		}
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	now := time.Now()
+	prev := map[string]fileState{
+		"a.go": {modTime: now, size: 1},
+		"b.go": {modTime: now, size: 1},
+		"c.go": {modTime: now, size: 1},
+	}
+	cur := map[string]fileState{
+		"a.go": {modTime: now, size: 1},
+		"b.go": {modTime: now.Add(time.Second), size: 1},
+		"d.go": {modTime: now, size: 1},
+	}
+	want := []string{"b.go", "c.go", "d.go"}
+	if got := diffSnapshots(prev, cur); !slices.Equal(got, want) {
+		t.Errorf("diffSnapshots = %v, want %v", got, want)
+	}
+}
+
+func TestNewWatcher_Errors(t *testing.T) {
+	e2eSetup(t)
+	plan, err := newPlan(&Config{}, "/tmp", []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newWatcher(plan, nil, time.Second, time.Second); err == nil {
+		t.Error("expected error without task names")
+	}
+	if _, err := newWatcher(plan, []string{"missing"}, time.Second, time.Second); err == nil ||
+		!strings.Contains(err.Error(), `unknown task "missing"`) {
+		t.Errorf("expected unknown task error, got %v", err)
+	}
+}
+
+func TestWatcher_RerunsAffectedPaths(t *testing.T) {
+	root := e2eSetup(t)
+	writeFile(t, filepath.Join(root, "a", "main.go"), "package a")
+	writeFile(t, filepath.Join(root, "b", "main.go"), "package b")
+	writeFile(t, filepath.Join(root, "b", "node_modules", "dep.js"), "skipped")
+
+	runs := make(chan string, 10)
+	task := &Task{Name: "lint", Usage: "lint", Do: func(ctx context.Context) error {
+		runs <- pkrun.PathFromContext(ctx)
+		return nil
+	}}
+	plan, err := newPlan(&Config{Auto: WithOptions(task, WithPath("a", "b"))}, root, []string{".", "a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := newWatcher(plan, []string{"lint"}, 10*time.Millisecond, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := integrationCtx(t, plan)
+	ctx, cancel := context.WithCancel(ctx)
+	result := make(chan error, 1)
+	go func() { result <- w.run(ctx) }()
+
+	// The initial run covers every path.
+	if got := receiveRuns(t, runs, 2); !slices.Equal(got, []string{"a", "b"}) {
+		t.Fatalf("initial run paths = %v, want [a b]", got)
+	}
+
+	// Files in skipped directories are not watched.
+	writeFile(t, filepath.Join(root, "b", "node_modules", "dep.js"), "changed")
+	// A change re-runs only the path it belongs to.
+	writeFile(t, filepath.Join(root, "b", "main.go"), "package b // changed")
+	if got := receiveRuns(t, runs, 1); !slices.Equal(got, []string{"b"}) {
+		t.Fatalf("re-run paths = %v, want [b]", got)
+	}
+
+	cancel()
+	if err := <-result; err != nil {
+		t.Fatalf("watch returned %v after cancellation", err)
+	}
+	select {
+	case path := <-runs:
+		t.Errorf("unexpected extra run in %s", path)
+	default:
+	}
+}
+
+func TestWatcher_RerunsAfterEditDuringRun(t *testing.T) {
+	root := e2eSetup(t)
+	writeFile(t, filepath.Join(root, "main.go"), "package main")
+
+	runs := make(chan string, 10)
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	var calls int
+	task := &Task{Name: "test", Usage: "test", Do: func(ctx context.Context) error {
+		calls++
+		if calls == 2 {
+			started <- struct{}{}
+			<-release
+		}
+		if ctx.Err() != nil {
+			t.Error("expected the in-flight run not to be cancelled")
+		}
+		runs <- pkrun.PathFromContext(ctx)
+		return nil
+	}}
+	plan, err := newPlan(&Config{Auto: task}, root, []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := newWatcher(plan, []string{"test"}, 10*time.Millisecond, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := integrationCtx(t, plan)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	result := make(chan error, 1)
+	go func() { result <- w.run(ctx) }()
+
+	receiveRuns(t, runs, 1)
+	writeFile(t, filepath.Join(root, "main.go"), "package main // changed")
+	waitFor(t, started, "re-run after change")
+
+	// Saved again while the run is in progress, and over several polls.
+	writeFile(t, filepath.Join(root, "main.go"), "package main // changed again")
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	receiveRuns(t, runs, 1)
+
+	// The edit is picked up once the run finishes.
+	receiveRuns(t, runs, 1)
+	select {
+	case <-runs:
+		t.Fatal("expected a single re-run after the edit")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	cancel()
+	if err := <-result; err != nil {
+		t.Fatalf("watch returned %v after cancellation", err)
+	}
+}
+
+func TestWatcher_IgnoresFilesWrittenByTask(t *testing.T) {
+	root := e2eSetup(t)
+	writeFile(t, filepath.Join(root, "main.go"), "package main")
+
+	runs := make(chan string, 10)
+	var calls int
+	task := &Task{Name: "test", Usage: "test", Do: func(ctx context.Context) error {
+		calls++
+		// Like a coverage profile, the output differs on every run. It is
+		// written for longer than a poll interval.
+		for i := range 5 {
+			writeFile(t, filepath.Join(root, "coverage.out"), strings.Repeat("x", calls*10+i))
+			time.Sleep(10 * time.Millisecond)
+		}
+		if ctx.Err() != nil {
+			t.Error("expected the run not to be cancelled by its own writes")
+		}
+		runs <- pkrun.PathFromContext(ctx)
+		return nil
+	}}
+	plan, err := newPlan(&Config{Auto: task}, root, []string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := newWatcher(plan, []string{"test"}, 5*time.Millisecond, 5*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, _ := integrationCtx(t, plan)
+	ctx, cancel := context.WithCancel(ctx)
+	result := make(chan error, 1)
+	go func() { result <- w.run(ctx) }()
+
+	receiveRuns(t, runs, 1)
+	select {
+	case <-runs:
+		t.Fatal("expected the file written by the task not to trigger a re-run")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// Changes made after the run still trigger one.
+	writeFile(t, filepath.Join(root, "main.go"), "package main // changed")
+	receiveRuns(t, runs, 1)
+	select {
+	case <-runs:
+		t.Fatal("expected a single re-run after the change")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	cancel()
+	if err := <-result; err != nil {
+		t.Fatalf("watch returned %v after cancellation", err)
+	}
+}
+
+// receiveRuns collects n run paths from runs, sorted.
+func receiveRuns(t *testing.T, runs <-chan string, n int) []string {
+	t.Helper()
+	var got []string
+	for range n {
+		select {
+		case path := <-runs:
+			got = append(got, path)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for runs, got %v", got)
+		}
+	}
+	slices.Sort(got)
+	return got
+}
+
+// waitFor waits for a value on ch or fails the test.
+func waitFor(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
@@ -115,6 +115,10 @@ func runWorkflows(ctx context.Context) error {
 
 	// Ensure .github/workflows directory exists
 	workflowDir := repopath.FromGitRoot(".github", "workflows")
+	if run.DryRun(ctx) {
+		run.Printf(ctx, "  [dry-run] bootstrap workflows in %s\n", workflowDir)
+		return nil
+	}
 	if err := os.MkdirAll(workflowDir, 0o755); err != nil {
 		return fmt.Errorf("create workflows dir: %w", err)
 	}
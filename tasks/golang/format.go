@@ -28,7 +28,7 @@ func formatCmd() pk.Runnable {
 
 		configPath := f.Config
 		if configPath == "" && !golangcilint.HasProjectConfig() {
-			configPath = golangcilint.EnsureDefaultConfig()
+			configPath = golangcilint.EnsureDefaultConfigContext(ctx)
 		}
 		if configPath != "" {
 			args = append(args, "-c", configPath)
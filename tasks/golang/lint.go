@@ -33,7 +33,7 @@ func lintCmd() pk.Runnable {
 
 		configPath := f.Config
 		if configPath == "" && !golangcilint.HasProjectConfig() {
-			configPath = golangcilint.EnsureDefaultConfig()
+			configPath = golangcilint.EnsureDefaultConfigContext(ctx)
 		}
 		if configPath != "" {
 			args = append(args, "-c", configPath)
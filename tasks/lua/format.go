@@ -27,7 +27,7 @@ func formatCmd() pk.Runnable {
 		f := run.GetFlags[FormatFlags](ctx)
 		configPath := f.Config
 		if configPath == "" && !stylua.HasProjectConfig() {
-			configPath = stylua.EnsureDefaultConfig()
+			configPath = stylua.EnsureDefaultConfigContext(ctx)
 		}
 
 		absDir := repopath.FromGitRoot(run.PathFromContext(ctx))
@@ -38,7 +38,7 @@ func formatCmd() pk.Runnable {
 		// Always write the bundled config so a project config can inherit
 		// from it via rumdl's `extends`, then fall back to it only when the
 		// project has no rumdl config of its own.
-		defaultConfig := rumdl.EnsureDefaultConfig()
+		defaultConfig := rumdl.EnsureDefaultConfigContext(ctx)
 		configPath := f.Config
 		if configPath == "" && !rumdl.HasProjectConfig() {
 			configPath = defaultConfig
@@ -52,13 +52,17 @@ func install(ctx context.Context, pkg, version string) error {
 	// Destination directory: .pocket/tools/go/<pkg>/<version>/
 	toolDir := repopath.FromToolsDir("go", pkg, version)
 	toolBinPath := filepath.Join(toolDir, binaryName)
+	pkgWithVersion := pkg + "@" + version
 
 	// Check if already installed.
+	stale := false
 	if _, err := os.Stat(toolBinPath); err == nil {
 		if !builtWithStaleGo(toolBinPath) {
 			// Already installed, ensure symlink exists.
-			if _, err := download.CreateSymlink(toolBinPath); err != nil {
-				return err
+			if !run.DryRun(ctx) {
+				if _, err := download.CreateSymlink(toolBinPath); err != nil {
+					return err
+				}
 			}
 			if run.Verbose(ctx) {
 				run.Printf(ctx, "  [install] %s@%s already installed\n", binaryName, version)
@@ -69,6 +73,15 @@ func install(ctx context.Context, pkg, version string) error {
 		if run.Verbose(ctx) {
 			run.Printf(ctx, "  [install] %s@%s rebuilding (Go version changed)\n", binaryName, version)
 		}
+		stale = true
+	}
+
+	if run.DryRun(ctx) {
+		run.Printf(ctx, "  [dry-run] go install %s (GOBIN=%s)\n", pkgWithVersion, toolDir)
+		return nil
+	}
+
+	if stale {
 		if err := os.RemoveAll(toolDir); err != nil {
 			return fmt.Errorf("remove stale tool: %w", err)
 		}
@@ -80,7 +93,6 @@ func install(ctx context.Context, pkg, version string) error {
 	}
 
 	// Run go install with GOBIN set.
-	pkgWithVersion := pkg + "@" + version
 	cmd := exec.CommandContext(ctx, "go", "install", pkgWithVersion)
 	cmd.Env = append(os.Environ(), "GOBIN="+toolDir)
 
@@ -2,12 +2,14 @@
 package golangcilint
 
 import (
+	"context"
 	_ "embed"
 	"os"
 	"path/filepath"
 
 	"github.com/fredrikaverpil/pocket/pk"
 	"github.com/fredrikaverpil/pocket/pk/repopath"
+	"github.com/fredrikaverpil/pocket/pk/run"
 	"github.com/fredrikaverpil/pocket/tools/golang"
 )
 
@@ -35,8 +37,19 @@ var configFileNames = []string{
 // EnsureDefaultConfig writes the bundled config to .pocket/tools/golangci-lint/
 // and returns its path. Safe to call multiple times.
 func EnsureDefaultConfig() string {
+	return EnsureDefaultConfigContext(context.Background())
+}
+
+// EnsureDefaultConfigContext is like [EnsureDefaultConfig], but in dry-run
+// mode (see [run.DryRun]) it prints the write instead of doing it. The path
+// is returned either way.
+func EnsureDefaultConfigContext(ctx context.Context) string {
 	configPath := repopath.FromToolsDir("golangci-lint", DefaultConfigFile)
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		if run.DryRun(ctx) {
+			run.Printf(ctx, "  [dry-run] write %s\n", configPath)
+			return configPath
+		}
 		_ = os.MkdirAll(filepath.Dir(configPath), 0o755)
 		_ = os.WriteFile(configPath, defaultConfig, 0o644)
 	}
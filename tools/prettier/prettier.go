@@ -10,6 +10,7 @@ import (
 
 	"github.com/fredrikaverpil/pocket/pk"
 	"github.com/fredrikaverpil/pocket/pk/repopath"
+	"github.com/fredrikaverpil/pocket/pk/run"
 	"github.com/fredrikaverpil/pocket/tools/bun"
 )
 
@@ -82,8 +83,19 @@ var configFileNames = []string{
 // EnsureDefaultConfig writes the bundled config to .pocket/tools/prettier/
 // and returns its path. Safe to call multiple times.
 func EnsureDefaultConfig() string {
+	return EnsureDefaultConfigContext(context.Background())
+}
+
+// EnsureDefaultConfigContext is like [EnsureDefaultConfig], but in dry-run
+// mode (see [run.DryRun]) it prints the write instead of doing it. The path
+// is returned either way.
+func EnsureDefaultConfigContext(ctx context.Context) string {
 	configPath := repopath.FromToolsDir("prettier", DefaultConfigFile)
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		if run.DryRun(ctx) {
+			run.Printf(ctx, "  [dry-run] write %s\n", configPath)
+			return configPath
+		}
 		_ = os.MkdirAll(filepath.Dir(configPath), 0o755)
 		_ = os.WriteFile(configPath, defaultConfig, 0o644)
 	}
@@ -103,11 +115,22 @@ func HasProjectConfig() bool {
 
 // EnsureIgnoreFile ensures a .prettierignore file exists at git root.
 func EnsureIgnoreFile() (string, error) {
+	return EnsureIgnoreFileContext(context.Background())
+}
+
+// EnsureIgnoreFileContext is like [EnsureIgnoreFile], but in dry-run mode
+// (see [run.DryRun]) it prints the write instead of doing it. The path is
+// returned either way.
+func EnsureIgnoreFileContext(ctx context.Context) (string, error) {
 	ignoreFile := repopath.FromGitRoot(".prettierignore")
 
 	if _, err := os.Stat(ignoreFile); err == nil {
 		return ignoreFile, nil
 	}
+	if run.DryRun(ctx) {
+		run.Printf(ctx, "  [dry-run] write %s\n", ignoreFile)
+		return ignoreFile, nil
+	}
 
 	if err := os.WriteFile(ignoreFile, defaultIgnore, 0o644); err != nil {
 		return "", err
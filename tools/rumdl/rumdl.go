@@ -2,6 +2,7 @@
 package rumdl
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 	"os"
@@ -11,6 +12,7 @@ import (
 	"github.com/fredrikaverpil/pocket/pk/download"
 	"github.com/fredrikaverpil/pocket/pk/platform"
 	"github.com/fredrikaverpil/pocket/pk/repopath"
+	"github.com/fredrikaverpil/pocket/pk/run"
 )
 
 // Name is the binary name for rumdl.
@@ -44,7 +46,18 @@ var configFileNames = []string{
 //
 //	extends = ".pocket/tools/rumdl/rumdl.toml"
 func EnsureDefaultConfig() string {
+	return EnsureDefaultConfigContext(context.Background())
+}
+
+// EnsureDefaultConfigContext is like [EnsureDefaultConfig], but in dry-run
+// mode (see [run.DryRun]) it prints the write instead of doing it. The path
+// is returned either way.
+func EnsureDefaultConfigContext(ctx context.Context) string {
 	configPath := repopath.FromToolsDir("rumdl", DefaultConfigFile)
+	if run.DryRun(ctx) {
+		run.Printf(ctx, "  [dry-run] write %s\n", configPath)
+		return configPath
+	}
 	_ = os.MkdirAll(filepath.Dir(configPath), 0o755)
 	_ = os.WriteFile(configPath, defaultConfig, 0o644)
 	return configPath
@@ -137,6 +137,10 @@ func removeStaleVenv(ctx context.Context, venvPath string) error {
 	if _, err := os.Stat(home); err == nil {
 		return nil // Base Python exists, venv is fine.
 	}
+	if run.DryRun(ctx) {
+		run.Printf(ctx, "  [dry-run] remove stale venv %s\n", venvPath)
+		return nil
+	}
 	if run.Verbose(ctx) {
 		run.Printf(ctx, "Removing stale venv %s (Python home %s no longer exists)\n", venvPath, home)
 	}